@@ -0,0 +1,474 @@
+// Package abi describes the ABI of the target platforms, i.e. the size and
+// alignment of the C basic types, which the layout of aggregates depends on.
+package abi
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A Target describes the ABI of a platform, i.e. the size and alignment of
+// the C basic types within an aggregate, and how enums are sized. Types holds
+// the metadata of any other named type, e.g. `int_fast16_t`, overriding the
+// one that would be derived from the basic types.
+//
+// Targets can be looked up by name or target triple among the built-in ones,
+// or loaded from a YAML or JSON file.
+type Target struct {
+	Name       string              `json:"name" yaml:"name"`
+	Triples    []string            `json:"triples,omitempty" yaml:"triples,omitempty"`
+	Pointer    TypeMeta            `json:"pointer" yaml:"pointer"`
+	Enum       TypeMeta            `json:"enum" yaml:"enum"`
+	ShortEnums bool                `json:"short_enums" yaml:"short_enums"`
+	Char       TypeMeta            `json:"char" yaml:"char"`
+	Short      TypeMeta            `json:"short" yaml:"short"`
+	Int        TypeMeta            `json:"int" yaml:"int"`
+	Long       TypeMeta            `json:"long" yaml:"long"`
+	LongLong   TypeMeta            `json:"long_long" yaml:"long_long"`
+	Float      TypeMeta            `json:"float" yaml:"float"`
+	Double     TypeMeta            `json:"double" yaml:"double"`
+	LongDouble TypeMeta            `json:"long_double" yaml:"long_double"`
+	Types      map[string]TypeMeta `json:"types,omitempty" yaml:"types,omitempty"`
+}
+
+var (
+	ErrTarget     = errors.New("invalid target")
+	ErrNoTarget   = errors.New("unknown target")
+	ErrTargetFile = errors.New("cannot load target file")
+
+	// the fast integer types are as wide as a long on 64bit glibc targets
+	fastLongTypes = map[string]TypeMeta{
+		"int_fast16_t":  {8, 8},
+		"uint_fast16_t": {8, 8},
+		"int_fast32_t":  {8, 8},
+		"uint_fast32_t": {8, 8},
+	}
+
+	targets = []Target{
+		{
+			Name:       "x86_64",
+			Triples:    []string{"x86_64-linux-gnu", "x86_64-pc-linux-gnu", "x86_64-unknown-linux-gnu"},
+			Pointer:    TypeMeta{8, 8},
+			Enum:       TypeMeta{4, 4},
+			Char:       TypeMeta{1, 1},
+			Short:      TypeMeta{2, 2},
+			Int:        TypeMeta{4, 4},
+			Long:       TypeMeta{8, 8},
+			LongLong:   TypeMeta{8, 8},
+			Float:      TypeMeta{4, 4},
+			Double:     TypeMeta{8, 8},
+			LongDouble: TypeMeta{16, 16},
+			Types:      fastLongTypes,
+		},
+		{
+			Name:       "i386",
+			Triples:    []string{"i386-linux-gnu", "i686-linux-gnu", "i686-pc-linux-gnu"},
+			Pointer:    TypeMeta{4, 4},
+			Enum:       TypeMeta{4, 4},
+			Char:       TypeMeta{1, 1},
+			Short:      TypeMeta{2, 2},
+			Int:        TypeMeta{4, 4},
+			Long:       TypeMeta{4, 4},
+			LongLong:   TypeMeta{4, 8},
+			Float:      TypeMeta{4, 4},
+			Double:     TypeMeta{4, 8},
+			LongDouble: TypeMeta{4, 12},
+		},
+		{
+			Name:       "win64",
+			Triples:    []string{"x86_64-pc-windows-msvc", "x86_64-pc-windows-gnu", "x86_64-w64-mingw32"},
+			Pointer:    TypeMeta{8, 8},
+			Enum:       TypeMeta{4, 4},
+			Char:       TypeMeta{1, 1},
+			Short:      TypeMeta{2, 2},
+			Int:        TypeMeta{4, 4},
+			Long:       TypeMeta{4, 4},
+			LongLong:   TypeMeta{8, 8},
+			Float:      TypeMeta{4, 4},
+			Double:     TypeMeta{8, 8},
+			LongDouble: TypeMeta{8, 8},
+		},
+		{
+			Name:       "aarch64",
+			Triples:    []string{"aarch64-linux-gnu", "aarch64-unknown-linux-gnu", "aarch64-none-elf"},
+			Pointer:    TypeMeta{8, 8},
+			Enum:       TypeMeta{4, 4},
+			Char:       TypeMeta{1, 1},
+			Short:      TypeMeta{2, 2},
+			Int:        TypeMeta{4, 4},
+			Long:       TypeMeta{8, 8},
+			LongLong:   TypeMeta{8, 8},
+			Float:      TypeMeta{4, 4},
+			Double:     TypeMeta{8, 8},
+			LongDouble: TypeMeta{16, 16},
+			Types:      fastLongTypes,
+		},
+		{
+			Name:       "arm-eabi",
+			Triples:    []string{"arm-none-eabi", "arm-none-eabihf"},
+			Pointer:    TypeMeta{4, 4},
+			Enum:       TypeMeta{4, 4},
+			ShortEnums: true,
+			Char:       TypeMeta{1, 1},
+			Short:      TypeMeta{2, 2},
+			Int:        TypeMeta{4, 4},
+			Long:       TypeMeta{4, 4},
+			LongLong:   TypeMeta{8, 8},
+			Float:      TypeMeta{4, 4},
+			Double:     TypeMeta{8, 8},
+			LongDouble: TypeMeta{8, 8},
+		},
+		{
+			Name:       "arm-linux",
+			Triples:    []string{"arm-linux-gnueabi", "arm-linux-gnueabihf"},
+			Pointer:    TypeMeta{4, 4},
+			Enum:       TypeMeta{4, 4},
+			Char:       TypeMeta{1, 1},
+			Short:      TypeMeta{2, 2},
+			Int:        TypeMeta{4, 4},
+			Long:       TypeMeta{4, 4},
+			LongLong:   TypeMeta{8, 8},
+			Float:      TypeMeta{4, 4},
+			Double:     TypeMeta{8, 8},
+			LongDouble: TypeMeta{8, 8},
+		},
+		{
+			Name:       "riscv32",
+			Triples:    []string{"riscv32-unknown-elf", "riscv32-unknown-linux-gnu"},
+			Pointer:    TypeMeta{4, 4},
+			Enum:       TypeMeta{4, 4},
+			Char:       TypeMeta{1, 1},
+			Short:      TypeMeta{2, 2},
+			Int:        TypeMeta{4, 4},
+			Long:       TypeMeta{4, 4},
+			LongLong:   TypeMeta{8, 8},
+			Float:      TypeMeta{4, 4},
+			Double:     TypeMeta{8, 8},
+			LongDouble: TypeMeta{16, 16},
+		},
+		{
+			Name:       "riscv64",
+			Triples:    []string{"riscv64-linux-gnu", "riscv64-unknown-linux-gnu", "riscv64-unknown-elf"},
+			Pointer:    TypeMeta{8, 8},
+			Enum:       TypeMeta{4, 4},
+			Char:       TypeMeta{1, 1},
+			Short:      TypeMeta{2, 2},
+			Int:        TypeMeta{4, 4},
+			Long:       TypeMeta{8, 8},
+			LongLong:   TypeMeta{8, 8},
+			Float:      TypeMeta{4, 4},
+			Double:     TypeMeta{8, 8},
+			LongDouble: TypeMeta{16, 16},
+			Types:      fastLongTypes,
+		},
+		{
+			Name:       "avr",
+			Triples:    []string{"avr", "avr-none", "avr-unknown-none"},
+			Pointer:    TypeMeta{1, 2},
+			Enum:       TypeMeta{1, 2},
+			Char:       TypeMeta{1, 1},
+			Short:      TypeMeta{1, 2},
+			Int:        TypeMeta{1, 2},
+			Long:       TypeMeta{1, 4},
+			LongLong:   TypeMeta{1, 8},
+			Float:      TypeMeta{1, 4},
+			Double:     TypeMeta{1, 4},
+			LongDouble: TypeMeta{1, 8},
+		},
+		{
+			Name:       "msp430",
+			Triples:    []string{"msp430-elf", "msp430-none-elf"},
+			Pointer:    TypeMeta{2, 2},
+			Enum:       TypeMeta{2, 2},
+			Char:       TypeMeta{1, 1},
+			Short:      TypeMeta{2, 2},
+			Int:        TypeMeta{2, 2},
+			Long:       TypeMeta{2, 4},
+			LongLong:   TypeMeta{2, 8},
+			Float:      TypeMeta{2, 4},
+			Double:     TypeMeta{2, 8},
+			LongDouble: TypeMeta{2, 8},
+		},
+		{
+			Name:       "xtensa",
+			Triples:    []string{"xtensa-esp32-elf", "xtensa-esp32s3-elf", "xtensa-lx106-elf"},
+			Pointer:    TypeMeta{4, 4},
+			Enum:       TypeMeta{4, 4},
+			Char:       TypeMeta{1, 1},
+			Short:      TypeMeta{2, 2},
+			Int:        TypeMeta{4, 4},
+			Long:       TypeMeta{4, 4},
+			LongLong:   TypeMeta{8, 8},
+			Float:      TypeMeta{4, 4},
+			Double:     TypeMeta{8, 8},
+			LongDouble: TypeMeta{8, 8},
+		},
+	}
+
+	// architecture names that differ from the ones used by the built-in
+	// targets, matched by prefix, in order
+	archAliases = []struct {
+		prefix string
+		arch   string
+	}{
+		{"amd64", "x86_64"},
+		{"i486", "i386"},
+		{"i586", "i386"},
+		{"i686", "i386"},
+		{"arm64", "aarch64"},
+		{"thumb", "arm"},
+		{"arm", "arm"},
+		{"riscv32", "riscv32"},
+		{"riscv64", "riscv64"},
+		{"xtensa", "xtensa"},
+		{"avr", "avr"},
+	}
+)
+
+// DefaultTarget returns the target used when none is specified, i.e. a
+// 64bit System V one, such as x86_64 Linux.
+func DefaultTarget() Target {
+	return targets[0]
+}
+
+// Targets returns every built-in target.
+func Targets() []Target {
+	return append([]Target(nil), targets...)
+}
+
+// LookupTarget retrieves a built-in target by name or target triple, e.g.
+// `arm-none-eabi`. A triple that is not known verbatim selects the target
+// with the same architecture sharing most of its components, e.g.
+// `thumbv7em-none-eabihf` selects `arm-eabi`.
+func LookupTarget(name string) (Target, error) {
+	for _, target := range targets {
+		if target.Name == name {
+			return target, nil
+		}
+
+		for _, triple := range target.Triples {
+			if triple == name {
+				return target, nil
+			}
+		}
+	}
+
+	var (
+		components = strings.Split(name, "-")
+		arch       = normalizeArch(components[0])
+		best       = -1
+		bestScore  = -1
+	)
+
+	for idx, target := range targets {
+		for _, triple := range target.Triples {
+			tripleComps := strings.Split(triple, "-")
+			if tripleComps[0] != arch {
+				continue
+			}
+
+			score := 0
+			for _, comp := range components[1:] {
+				for _, tripleComp := range tripleComps[1:] {
+					if comp == tripleComp {
+						score++
+						break
+					}
+				}
+			}
+
+			if score > bestScore {
+				best, bestScore = idx, score
+			}
+		}
+	}
+
+	if best == -1 {
+		return Target{}, fmt.Errorf("%w: %s", ErrNoTarget, name)
+	}
+	return targets[best], nil
+}
+
+// Arch returns the architecture of the target, as used by the built-in
+// targets, e.g. `i386` for `i686-linux-gnu`, which is derived from its first
+// triple, or from its name if it has none.
+func (target Target) Arch() string {
+	name := target.Name
+	if len(target.Triples) != 0 {
+		name = target.Triples[0]
+	}
+	return normalizeArch(strings.Split(name, "-")[0])
+}
+
+// System returns the operating system of the target, derived from its first
+// triple, i.e. `windows` or `linux`, or an empty string for bare-metal targets
+// and the ones without a triple.
+func (target Target) System() string {
+	if len(target.Triples) == 0 {
+		return ""
+	}
+
+	for _, comp := range strings.Split(target.Triples[0], "-")[1:] {
+		switch {
+		case comp == "windows", strings.HasPrefix(comp, "mingw"):
+			return "windows"
+		case comp == "linux":
+			return "linux"
+		}
+	}
+	return ""
+}
+
+// normalizeArch maps the architecture component of a target triple to the
+// one used by the built-in targets, e.g. `i686` to `i386`.
+func normalizeArch(arch string) string {
+	for _, alias := range archAliases {
+		if strings.HasPrefix(arch, alias.prefix) {
+			return alias.arch
+		}
+	}
+	return arch
+}
+
+// LoadTarget reads a target description from the YAML or JSON file at the
+// passed path. The description may set a `base` target, by name or triple,
+// in which case only the values that differ from it need to be specified.
+func LoadTarget(path string) (Target, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Target{}, fmt.Errorf("%w: %w", ErrTargetFile, err)
+	}
+
+	target, err := ParseTarget(data)
+	if err != nil {
+		return Target{}, fmt.Errorf("%w: %s: %w", ErrTargetFile, path, err)
+	}
+	return target, nil
+}
+
+// ParseTarget decodes a target description in YAML or JSON, which is a
+// subset of YAML, as described for LoadTarget.
+func ParseTarget(data []byte) (Target, error) {
+	var header struct {
+		Base string `yaml:"base"`
+	}
+
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return Target{}, err
+	}
+
+	var target Target
+	if header.Base != "" {
+		base, err := LookupTarget(header.Base)
+		if err != nil {
+			return Target{}, err
+		}
+
+		// the target is a new one, not known by the names of its base
+		target = base
+		target.Name, target.Triples = "", nil
+		target.Types = maps.Clone(base.Types)
+	}
+
+	if err := yaml.Unmarshal(data, &target); err != nil {
+		return Target{}, err
+	}
+	return target, target.Validate()
+}
+
+// Validate checks that the target has a name, and that every size and
+// alignment it holds is a positive one, alignments being powers of two.
+func (target Target) Validate() error {
+	if target.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrTarget)
+	}
+
+	metas := map[string]TypeMeta{
+		"pointer":     target.Pointer,
+		"enum":        target.Enum,
+		"char":        target.Char,
+		"short":       target.Short,
+		"int":         target.Int,
+		"long":        target.Long,
+		"long long":   target.LongLong,
+		"float":       target.Float,
+		"double":      target.Double,
+		"long double": target.LongDouble,
+	}
+	maps.Copy(metas, target.Types)
+
+	for name, meta := range metas {
+		if meta.Size <= 0 || meta.Alignment <= 0 || meta.Alignment&(meta.Alignment-1) != 0 {
+			return fmt.Errorf("%w: %s: %s: size %d, alignment %d", ErrTarget,
+				target.Name, name, meta.Size, meta.Alignment)
+		}
+	}
+	return nil
+}
+
+// TypeMap builds the lookup table holding the metadata of each type name
+// known to the target: the basic types, with all of their spellings, and the
+// ones defined by `stdint.h` and `stddef.h`, which are derived from them.
+func (target Target) TypeMap() map[string]TypeMeta {
+	types := map[string]TypeMeta{
+		"_Bool":       {1, 1},
+		"float":       target.Float,
+		"double":      target.Double,
+		"long double": target.LongDouble,
+	}
+
+	families := []struct {
+		names []string
+		meta  TypeMeta
+	}{
+		{charTypes, target.Char},
+		{shortTypes, target.Short},
+		{intTypes, target.Int},
+		{longTypes, target.Long},
+		{longlongTypes, target.LongLong},
+	}
+
+	for _, family := range families {
+		for _, name := range family.names {
+			types[name] = family.meta
+		}
+	}
+
+	// the first integer type that is exactly, or at least, as wide as needed
+	integer := func(size int, exact bool) TypeMeta {
+		for _, family := range families {
+			if family.meta.Size == size || (!exact && family.meta.Size > size) {
+				return family.meta
+			}
+		}
+		return TypeMeta{size, size}
+	}
+
+	// the fast types, but the 8 bits ones, are at least as wide as an int
+	for _, bits := range []int{8, 16, 32, 64} {
+		fast := integer(max(bits/8, target.Int.Size), false)
+		if bits == 8 {
+			fast = target.Char
+		}
+
+		for _, sign := range []string{"", "u"} {
+			types[fmt.Sprintf("%sint%d_t", sign, bits)] = integer(bits/8, true)
+			types[fmt.Sprintf("%sint_least%d_t", sign, bits)] = integer(bits/8, false)
+			types[fmt.Sprintf("%sint_fast%d_t", sign, bits)] = fast
+		}
+	}
+
+	pointerInt := integer(target.Pointer.Size, true)
+	for _, name := range []string{"intptr_t", "uintptr_t", "size_t", "ssize_t", "ptrdiff_t"} {
+		types[name] = pointerInt
+	}
+	types["intmax_t"], types["uintmax_t"] = target.LongLong, target.LongLong
+
+	maps.Copy(types, target.Types)
+	return types
+}
@@ -0,0 +1,119 @@
+package abi
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLookupTarget(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"x86_64", "x86_64"},
+		{"win64", "win64"},
+		{"arm-none-eabi", "arm-eabi"},
+		{"x86_64-pc-linux-gnu", "x86_64"},
+		{"x86_64-unknown-linux-musl", "x86_64"},
+		{"x86_64-w64-windows-gnu", "win64"},
+		{"amd64-pc-windows-msvc", "win64"},
+		{"i686-pc-linux-gnu", "i386"},
+		{"i586-linux-gnu", "i386"},
+		{"thumbv7em-none-eabihf", "arm-eabi"},
+		{"armv7-unknown-linux-gnueabihf", "arm-linux"},
+		{"arm64-apple-darwin", "aarch64"},
+		{"riscv32imac-unknown-none-elf", "riscv32"},
+		{"avr-atmega328p", "avr"},
+		{"xtensa-esp32-none-elf", "xtensa"},
+	}
+
+	for _, testCase := range testCases {
+		target, err := LookupTarget(testCase.name)
+		if err != nil {
+			t.Errorf("Unexpected error when looking up %s: %s", testCase.name, err)
+			continue
+		}
+
+		if target.Name != testCase.expected {
+			t.Errorf("Expected target %s for %s: got %s", testCase.expected,
+				testCase.name, target.Name)
+		}
+	}
+
+	for _, name := range []string{"", "foo", "mips-linux-gnu"} {
+		if _, err := LookupTarget(name); !errors.Is(err, ErrNoTarget) {
+			t.Errorf("Expected an unknown target error for %q: got %v", name, err)
+		}
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	const yamlTarget = `
+name: cortex-m-big
+base: arm-none-eabi
+short_enums: false
+long_double: {size: 16, alignment: 8}
+types:
+  wchar_t: {size: 4, alignment: 4}
+`
+	target, err := ParseTarget([]byte(yamlTarget))
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing the target: %s", err)
+	}
+
+	if target.Name != "cortex-m-big" || target.ShortEnums || target.Pointer.Size != 4 ||
+		target.LongDouble != (TypeMeta{8, 16}) || target.Types["wchar_t"] != (TypeMeta{4, 4}) {
+		t.Errorf("Unexpected target: %+v", target)
+	}
+
+	const jsonTarget = `{"name": "tiny", "base": "avr", "int": {"size": 4, "alignment": 1}}`
+	target, err = ParseTarget([]byte(jsonTarget))
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing the target: %s", err)
+	}
+
+	if target.Name != "tiny" || target.Int != (TypeMeta{1, 4}) || target.Pointer.Size != 2 {
+		t.Errorf("Unexpected target: %+v", target)
+	}
+
+	invalid := []string{
+		`base: avr`,
+		`{name: nobase, pointer: {size: 8, alignment: 8}}`,
+		`{name: odd, base: x86_64, long: {size: 8, alignment: 3}}`,
+		`{name: zero, base: x86_64, types: {wchar_t: {size: 0, alignment: 4}}}`,
+	}
+
+	for _, data := range invalid {
+		if _, err := ParseTarget([]byte(data)); !errors.Is(err, ErrTarget) {
+			t.Errorf("Expected an invalid target error for %q: got %v", data, err)
+		}
+	}
+}
+
+func TestTargetArch(t *testing.T) {
+	testCases := []struct {
+		name   string
+		arch   string
+		system string
+	}{
+		{"x86_64", "x86_64", "linux"},
+		{"i386", "i386", "linux"},
+		{"win64", "x86_64", "windows"},
+		{"arm-eabi", "arm", ""},
+		{"riscv32", "riscv32", ""},
+		{"avr", "avr", ""},
+	}
+
+	for _, testCase := range testCases {
+		target, err := LookupTarget(testCase.name)
+		if err != nil {
+			t.Errorf("Unexpected error when looking up %s: %s", testCase.name, err)
+			continue
+		}
+
+		if arch, system := target.Arch(), target.System(); arch != testCase.arch || system != testCase.system {
+			t.Errorf("Expected %s to be %s/%s: got %s/%s", testCase.name,
+				testCase.arch, testCase.system, arch, system)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package abi
+
+// A TypeMeta holds the alignment and size of a C type, in bytes.
+type TypeMeta struct {
+	Alignment int `json:"alignment" yaml:"alignment"`
+	Size      int `json:"size" yaml:"size"`
+}
+
+// the spellings of each basic integer type, which share the same metadata
+var (
+	charTypes = []string{
+		"char",
+		"signed char",
+		"unsigned char",
+	}
+
+	shortTypes = []string{
+		"short",
+		"short int",
+		"signed short",
+		"signed short int",
+		"unsigned short",
+		"unsigned short int",
+	}
+
+	intTypes = []string{
+		"int",
+		"signed",
+		"signed int",
+		"unsigned",
+		"unsigned int",
+	}
+
+	longTypes = []string{
+		"long",
+		"long int",
+		"signed long",
+		"signed long int",
+		"unsigned long",
+		"unsigned long int",
+	}
+
+	longlongTypes = []string{
+		"long long",
+		"long long int",
+		"signed long long",
+		"signed long long int",
+		"unsigned long long",
+		"unsigned long long int",
+	}
+)
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Abathargh/stropt/layout"
+)
+
+// assertsGuard is the include guard of the headers generated for the source
+// code passed as a string.
+const assertsGuard = "STROPT_LAYOUT_ASSERTS_H"
+
+var ErrAsserts = errors.New("cannot generate the assertions")
+
+// printAssertions prints a header holding the static assertions enforcing
+// the layout of the aggregates identified by the passed names within the
+// passed source, or of every aggregate if no name is passed, skipping the
+// ones that cannot be resolved with a warning. The header includes the
+// file passed with -file, and holds both the C11 and C++ assertions.
+func printAssertions(fname, cont string, names []string, opts options) {
+	aggregates, err := layout.ExtractAggregates(fname, cont, opts.compiler, opts.target)
+	if err != nil {
+		logError(err)
+	}
+
+	skipErrors := names == nil
+	if skipErrors {
+		for _, aggregate := range aggregates.Aggregates() {
+			names = append(names, layout.GetAggregateNames(aggregate)[0])
+		}
+	}
+
+	var assertions [][]layout.Assertion
+	for _, name := range names {
+		meta, err := aggregates.ResolveMeta(name)
+		if err != nil && skipErrors {
+			logWarning(err)
+			continue
+		} else if err != nil {
+			logError(err)
+		}
+		assertions = append(assertions, aggregates.Assertions(name, meta))
+	}
+
+	fmt.Print(formatAssertions(fname, opts.target.Name, assertions))
+}
+
+// formatAssertions formats the header holding the passed assertions, grouped
+// by aggregate, for the passed target, including the passed file, if any.
+func formatAssertions(fname, target string, assertions [][]layout.Assertion) string {
+	guard := assertsGuard
+	if fname != "" {
+		guard = headerGuard(filepath.Base(fname))
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "/* Layout assertions generated by stropt for the %s target. */\n", target)
+	fmt.Fprintf(&builder, "#ifndef %s\n#define %s\n\n#include <stddef.h>\n", guard, guard)
+	if fname != "" {
+		fmt.Fprintf(&builder, "#include \"%s\"\n", filepath.Base(fname))
+	}
+
+	for _, cpp := range []bool{true, false} {
+		if cpp {
+			builder.WriteString("\n#ifdef __cplusplus\n")
+		} else {
+			builder.WriteString("\n#else\n")
+		}
+
+		for _, group := range assertions {
+			builder.WriteString("\n")
+			for _, assertion := range group {
+				builder.WriteString(assertion.Format(cpp) + "\n")
+			}
+		}
+	}
+
+	fmt.Fprintf(&builder, "\n#endif /* __cplusplus */\n\n#endif /* %s */\n", guard)
+	return builder.String()
+}
+
+// headerGuard returns the include guard of the header holding the assertions
+// for the passed file, e.g. `PROTO_H_LAYOUT_ASSERTS` for `proto.h`.
+func headerGuard(fname string) string {
+	guard := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, fname)
+
+	if guard == "" || guard[0] >= '0' && guard[0] <= '9' {
+		guard = "_" + guard
+	}
+	return guard + "_LAYOUT_ASSERTS"
+}
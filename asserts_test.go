@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestHeaderGuard(t *testing.T) {
+	testCases := []struct {
+		fname    string
+		expected string
+	}{
+		{"proto.h", "PROTO_H_LAYOUT_ASSERTS"},
+		{"my-types.hpp", "MY_TYPES_HPP_LAYOUT_ASSERTS"},
+		{"2d.h", "_2D_H_LAYOUT_ASSERTS"},
+	}
+
+	for _, testCase := range testCases {
+		if guard := headerGuard(testCase.fname); guard != testCase.expected {
+			t.Errorf("Expected guard %s for %s: got %s", testCase.expected, testCase.fname, guard)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Abathargh/stropt/layout"
+	"github.com/Abathargh/stropt/report"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+var ErrCompare = errors.New("cannot compare the layouts")
+
+// stroptCompare compares the layout of the aggregates identified by the
+// passed names within the passed source with the one they have within the
+// old version of the source passed with -compare, or of every aggregate
+// found within either version if no name is passed, skipping the ones that
+// cannot be resolved with a warning.
+func stroptCompare(fname, cont string, names []string, opts options) {
+	oldName, oldCont, err := readBaseline(opts.compare, fname)
+	if err != nil {
+		logError(err)
+	}
+
+	before, err := layout.ExtractAggregates(oldName, oldCont, opts.compiler, opts.target)
+	if err != nil {
+		logError(fmt.Errorf("%s: %w", opts.compare, err))
+	}
+
+	after, err := layout.ExtractAggregates(fname, cont, opts.compiler, opts.target)
+	if err != nil {
+		logError(err)
+	}
+
+	skipErrors := names == nil
+	if skipErrors {
+		for _, aggregates := range []layout.Context{after, before} {
+			for _, aggregate := range aggregates.Aggregates() {
+				if name := layout.GetAggregateNames(aggregate)[0]; !slices.Contains(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+
+	diffs := make([]report.Diff, 0, len(names))
+	for _, name := range names {
+		diff, err := compareAggregate(before, after, name)
+		if err != nil && skipErrors {
+			logWarning(err)
+			continue
+		} else if err != nil {
+			logError(err)
+		}
+		diffs = append(diffs, diff)
+	}
+
+	if opts.format != formatTable {
+		doc := report.New()
+		doc.Diffs = diffs
+		if err := encodeReport(doc, opts.format); err != nil {
+			logError(err)
+		}
+		return
+	}
+
+	for _, diff := range diffs {
+		printDiff(diff, opts.bare)
+	}
+}
+
+// readBaseline reads the old version of the source to compare with, which is
+// either a file, a git revision and path, e.g. HEAD:include/foo.h, or just a
+// revision, e.g. HEAD~1, of the file passed with -file. Revisions are read
+// with `git show`. It returns the name to parse the old version with,
+// alongside with its content.
+func readBaseline(source, fname string) (string, string, error) {
+	if cont, err := os.ReadFile(source); err == nil {
+		return source, string(cont), nil
+	}
+
+	var (
+		name   = source
+		dir    = "."
+		object = source
+	)
+
+	if !strings.Contains(source, ":") {
+		if fname == "" {
+			return "", "", fmt.Errorf("%w: %s is neither a file nor a revision "+
+				"and path, e.g. HEAD:foo.h", ErrCompare, source)
+		}
+
+		// the path is relative to the file, rather than to the repository root
+		name, dir = fname, filepath.Dir(fname)
+		object = fmt.Sprintf("%s:./%s", source, filepath.Base(fname))
+	}
+
+	cmd := exec.Command("git", "-C", dir, "show", object)
+	out, err := cmd.Output()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) != 0 {
+		return "", "", fmt.Errorf("%w: %s: %s", ErrCompare, object,
+			strings.TrimSpace(string(exitErr.Stderr)))
+	} else if err != nil {
+		return "", "", fmt.Errorf("%w: %s: %w", ErrCompare, object, err)
+	}
+	return name, string(out), nil
+}
+
+// compareAggregate compares the layout of the aggregate identified by name
+// within the contexts of the old and new version of the source. Aggregates
+// only found within one of them are reported as added or removed.
+func compareAggregate(before, after layout.Context, name string) (report.Diff, error) {
+	oldReport, err := resolveReport(before, name)
+	if err != nil {
+		return report.Diff{}, fmt.Errorf("old version: %w", err)
+	}
+
+	newReport, err := resolveReport(after, name)
+	if err != nil {
+		return report.Diff{}, err
+	}
+
+	if oldReport == nil && newReport == nil {
+		return report.Diff{}, fmt.Errorf("%w: %v", layout.ErrSymbol, name)
+	}
+	return report.Compare(oldReport, newReport), nil
+}
+
+// resolveReport resolves the aggregate identified by name and builds its
+// report, or returns nil if the aggregate cannot be found.
+func resolveReport(aggregates layout.Context, name string) (*report.Aggregate, error) {
+	if _, ok := aggregates.Lookup(name); !ok {
+		return nil, nil
+	}
+
+	meta, err := aggregates.ResolveMeta(name)
+	if err != nil {
+		return nil, err
+	}
+
+	aggReport, err := report.NewAggregate(aggregates, name, meta, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &aggReport, nil
+}
+
+// printDiff prints how the layout of an aggregate changed, i.e. its size,
+// alignment and padding, followed by a row for each field which changed,
+// showing what changed about it, and its old and new offset, size and
+// alignment.
+func printDiff(diff report.Diff, bare bool) {
+	// added and removed aggregates only have one version to show
+	changes := []report.Change{diff.Size, diff.Alignment, diff.Padding}
+	for idx := range changes {
+		switch diff.Status {
+		case report.StatusAdded:
+			changes[idx].Old = changes[idx].New
+		case report.StatusRemoved:
+			changes[idx].New = changes[idx].Old
+		}
+	}
+
+	var (
+		size  = formatChange(changes[0], strconv.Itoa)
+		align = formatChange(changes[1], strconv.Itoa)
+		pad   = formatChange(changes[2], strconv.Itoa)
+	)
+
+	if bare {
+		fmt.Fprintf(os.Stdout, "(cmp) %s: %s, size: %s, alignment: %s, padding: %s\n",
+			diff.Name, diff.Status, size, align, pad)
+		for _, field := range diff.Fields {
+			offset, fSize, fAlign := formatFieldDiff(field)
+			fmt.Fprintf(os.Stdout, "%s: %s, offset: %s, size: %s, alignment: %s\n",
+				field.Name, fieldStatus(field), offset, fSize, fAlign)
+		}
+		return
+	}
+
+	fmt.Println(titleBox.Render(fmt.Sprintf("stropt - %s", diff.Name)))
+
+	switch diff.Status {
+	case report.StatusUnchanged:
+		fmt.Println("The layout is unchanged")
+		return
+	case report.StatusAdded, report.StatusRemoved:
+		fmt.Printf("The aggregate was %s, size: %s, alignment: %s\n", diff.Status, size, align)
+		return
+	}
+
+	fmt.Printf("Size: %s%s\n", size, formatDelta(diff.Size))
+	fmt.Printf("Alignment: %s\n", align)
+	fmt.Printf("Padding: %s%s\n", pad, formatDelta(diff.Padding))
+
+	if len(diff.Fields) == 0 {
+		return
+	}
+
+	t := table.New().
+		Border(lipgloss.RoundedBorder()).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == -1 {
+				return headerStyle
+			}
+			return rowStyle
+		}).
+		Headers("Field", "Change", "Offset", "Size", "Alignment")
+
+	for _, field := range diff.Fields {
+		offset, size, align := formatFieldDiff(field)
+		t.Row(field.Name, fieldStatus(field), offset, size, align)
+	}
+	fmt.Println(t)
+}
+
+// fieldStatus describes what changed about a field: whether it was added or
+// removed, or else the list of its changes.
+func fieldStatus(field report.FieldDiff) string {
+	if field.Status != report.StatusChanged {
+		return field.Status
+	}
+	return strings.Join(field.Changes, " ")
+}
+
+// formatFieldDiff formats the offset, size and alignment of a field, showing
+// both the old and new values when they differ. Offsets and sizes are shown
+// in bits when they are not a whole number of bytes.
+func formatFieldDiff(field report.FieldDiff) (string, string, string) {
+	var offset, size, align report.Change
+	if field.Old != nil {
+		offset.Old, size.Old, align.Old = field.Old.BitOffset, field.Old.BitSize, field.Old.Alignment
+	}
+
+	if field.New != nil {
+		offset.New, size.New, align.New = field.New.BitOffset, field.New.BitSize, field.New.Alignment
+	}
+
+	switch {
+	case field.Old == nil:
+		offset.Old, size.Old, align.Old = offset.New, size.New, align.New
+	case field.New == nil:
+		offset.New, size.New, align.New = offset.Old, size.Old, align.Old
+	}
+	return formatChange(offset, formatBits), formatChange(size, formatBits),
+		formatChange(align, strconv.Itoa)
+}
+
+// formatChange formats a value which may have changed, as `old -> new`, or
+// just as its value if it did not.
+func formatChange(change report.Change, format func(int) string) string {
+	if !change.Changed() {
+		return format(change.New)
+	}
+	return fmt.Sprintf("%s -> %s", format(change.Old), format(change.New))
+}
+
+// formatDelta formats the growth of a value, e.g. ` (+8)`, if it changed.
+func formatDelta(change report.Change) string {
+	if !change.Changed() {
+		return ""
+	}
+	return fmt.Sprintf(" (%+d)", change.New-change.Old)
+}
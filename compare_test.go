@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadBaseline(t *testing.T) {
+	const test = "struct b1 { int a; };"
+
+	fname := filepath.Join(t.TempDir(), "old.h")
+	if err := os.WriteFile(fname, []byte(test), 0o644); err != nil {
+		t.Fatalf("Unexpected error when writing %s: %s", fname, err)
+	}
+
+	name, cont, err := readBaseline(fname, "")
+	if err != nil || name != fname || cont != test {
+		t.Errorf("Expected %s to be read as %q: got %s: %q, %v", fname, test, name, cont, err)
+	}
+
+	// a bare revision needs a file to refer to
+	if _, _, err := readBaseline("HEAD~1", ""); !errors.Is(err, ErrCompare) {
+		t.Errorf("Expected an error for a revision without a file: got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Abathargh/stropt/optimize"
+)
+
+var ErrConstraintFlag = errors.New("invalid constraint")
+
+// A listFlag collects the values of a flag that can be passed multiple
+// times, e.g. `-group a,b -group c,d`.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseConstraints builds the optimizer constraints passed through the
+// command line: a comma-separated list of pinned fields, each of them with
+// an optional index, e.g. `magic,crc=3`, the amount of leading fields to be
+// kept in place, and the comma-separated lists of fields to be kept either
+// next to each other or in order.
+func parseConstraints(pins string, prefix int, groups, orders []string) (optimize.Constraints, error) {
+	if prefix < 0 {
+		return optimize.Constraints{}, fmt.Errorf("%w: negative prefix %d", ErrConstraintFlag, prefix)
+	}
+
+	constraints := optimize.Constraints{Prefix: prefix}
+	for _, pin := range splitFields(pins) {
+		name, index, found := strings.Cut(pin, "=")
+		name = strings.TrimSpace(name)
+
+		pos := -1
+		if found {
+			var err error
+			if pos, err = strconv.Atoi(strings.TrimSpace(index)); err != nil || pos < 0 || name == "" {
+				return optimize.Constraints{}, fmt.Errorf("%w: pin %s", ErrConstraintFlag, pin)
+			}
+		}
+
+		if constraints.Pins == nil {
+			constraints.Pins = make(map[string]int)
+		}
+		constraints.Pins[name] = pos
+	}
+
+	for _, group := range groups {
+		if fields := splitFields(group); len(fields) > 0 {
+			constraints.Groups = append(constraints.Groups, fields)
+		}
+	}
+
+	for _, order := range orders {
+		if fields := splitFields(order); len(fields) > 0 {
+			constraints.Orders = append(constraints.Orders, fields)
+		}
+	}
+	return constraints, nil
+}
+
+// splitFields splits a comma-separated list of fields, skipping the empty
+// ones.
+func splitFields(list string) []string {
+	var fields []string
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
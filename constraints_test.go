@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Abathargh/stropt/optimize"
+)
+
+func TestParseConstraints(t *testing.T) {
+	constraints, err := parseConstraints("magic, crc=3", 1, []string{"a,b", " "},
+		[]string{"hdr, len"})
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing the constraints: %s", err)
+	}
+
+	expected := optimize.Constraints{
+		Pins:   map[string]int{"magic": -1, "crc": 3},
+		Prefix: 1,
+		Groups: [][]string{{"a", "b"}},
+		Orders: [][]string{{"hdr", "len"}},
+	}
+
+	if !reflect.DeepEqual(constraints, expected) {
+		t.Errorf("Expected constraints %+v: got %+v", expected, constraints)
+	}
+
+	for _, pins := range []string{"crc=x", "crc=-1", "=2"} {
+		if _, err := parseConstraints(pins, 0, nil, nil); !errors.Is(err, ErrConstraintFlag) {
+			t.Errorf("Expected an error for -pin %s: got %v", pins, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Abathargh/stropt/layout"
+	"github.com/Abathargh/stropt/optimize"
+	"github.com/Abathargh/stropt/rewrite"
+)
+
+// diffSourceName is the name used within diffs for the source code passed
+// as a string.
+const diffSourceName = "source"
+
+var ErrFix = errors.New("cannot rewrite the source")
+
+// fixSource re-orders the fields of the aggregates identified by the passed
+// names within the passed source, or of every aggregate if no name is
+// passed, skipping the ones that cannot be rewritten with a warning. The
+// result is written back to the file with -fix, or printed as a unified
+// diff with -diff.
+func fixSource(fname, cont string, names []string, opts options) {
+	aggregates, err := layout.ExtractAggregates(fname, cont, opts.compiler, opts.target)
+	if err != nil {
+		logError(err)
+	}
+
+	skipErrors := names == nil
+	if skipErrors {
+		for _, aggregate := range aggregates.Aggregates() {
+			names = append(names, layout.GetAggregateNames(aggregate)[0])
+		}
+	}
+
+	rewriter := rewrite.New(fname, cont)
+	for _, name := range names {
+		err := scheduleRewrite(rewriter, aggregates, name, opts)
+		if err != nil && skipErrors {
+			logWarning(err)
+		} else if err != nil {
+			logError(err)
+		}
+	}
+
+	rewritten, err := rewriter.Rewrite()
+	if err != nil && skipErrors {
+		logWarning(fmt.Errorf("%w: %w", ErrFix, err))
+	} else if err != nil {
+		logError(fmt.Errorf("%w: %w", ErrFix, err))
+	}
+
+	if opts.diff {
+		label := fname
+		if label == "" {
+			label = diffSourceName
+		}
+		fmt.Print(rewrite.Diff(label, cont, rewritten))
+		return
+	}
+
+	if rewritten == cont {
+		return
+	}
+
+	info, err := os.Stat(fname)
+	if err != nil {
+		logError(fmt.Errorf("%w: %w", ErrFix, err))
+	}
+
+	if err := os.WriteFile(fname, []byte(rewritten), info.Mode().Perm()); err != nil {
+		logError(fmt.Errorf("%w: %w", ErrFix, err))
+	}
+}
+
+// scheduleRewrite optimizes the aggregate identified by name, alongside with
+// the nested ones if optimizing recursively, and schedules the rewrite of
+// the ones whose fields are moved.
+func scheduleRewrite(rewriter *rewrite.Rewriter, aggregates layout.Context, name string, opts options) error {
+	agg, ok := aggregates.Lookup(name)
+	if !ok {
+		return fmt.Errorf("%w: %v", layout.ErrSymbol, name)
+	}
+
+	meta, err := aggregates.ResolveMeta(name)
+	if err != nil {
+		return err
+	}
+
+	var nested optimize.Nested
+	if opts.recursive {
+		nested, err = optimize.OptimizeRecursive(aggregates, name, meta, opts.constraints)
+	} else {
+		nested.Optimized, err = optimize.Optimize(aggregates, name, meta, opts.constraints)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, change := range nested.Changes {
+		order := optimize.Order(change.Original.Layout, change.Optimized.Layout)
+		if err := rewriter.Reorder(change.Name, change.Aggregate, order); err != nil {
+			return err
+		}
+	}
+
+	if agg.Kind == layout.EnumKind {
+		return nil
+	}
+	return rewriter.Reorder(name, agg, optimize.Order(meta.Layout, nested.Optimized.Layout))
+}
@@ -4,7 +4,9 @@ go 1.24.1
 
 require (
 	github.com/charmbracelet/lipgloss v1.0.0
+	gopkg.in/yaml.v3 v3.0.1
 	modernc.org/cc/v4 v4.24.4
+	modernc.org/token v1.1.0
 )
 
 require (
@@ -22,5 +24,4 @@ require (
 	modernc.org/opt v0.1.4 // indirect
 	modernc.org/sortutil v1.2.1 // indirect
 	modernc.org/strutil v1.2.1 // indirect
-	modernc.org/token v1.1.0 // indirect
 )
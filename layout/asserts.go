@@ -0,0 +1,161 @@
+package layout
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AssertionKind represents what an Assertion checks about an aggregate.
+type AssertionKind uint
+
+const (
+	SizeAssertion AssertionKind = iota
+	AlignmentAssertion
+	OffsetAssertion
+	ValueAssertion
+)
+
+// An Assertion is a compile-time check of the layout of an aggregate, which
+// holds for the layout it has been built from: the size or alignment of the
+// aggregate identified by Type, the offset of one of its members, or the
+// value of an enumerator. Member is the member designator of the checked
+// field, e.g. `hdr.len` for the fields of nested aggregates, or the name of
+// the checked enumerator, and Value is the expected size, alignment, offset
+// or value.
+type Assertion struct {
+	Kind   AssertionKind
+	Type   string
+	Member string
+	Value  int64
+}
+
+// Assertions returns the assertions enforcing the passed layout of the
+// aggregate identified by name: its size and alignment, the offset of each
+// of its fields, including the ones of nested aggregates, and the value of
+// each of its enumerators, for enums. Bit-fields have no offset that can be
+// checked, and are left out, as are the fields of the elements of arrays,
+// including the ones of array typedefs.
+func (ctx Context) Assertions(name string, meta AggregateMeta) []Assertion {
+	assertions := []Assertion{
+		{Kind: SizeAssertion, Type: name, Value: int64(meta.Size)},
+		{Kind: AlignmentAssertion, Type: name, Value: int64(meta.Alignment)},
+	}
+	return append(assertions, ctx.memberAssertions(name, "", 0, meta.Layout)...)
+}
+
+// memberAssertions returns the assertions for the passed field layouts,
+// whose designators are prefixed by the passed path, and whose offsets are
+// relative to the passed base one, in bytes.
+func (ctx Context) memberAssertions(name, path string, base int, layouts []Layout) []Assertion {
+	var assertions []Assertion
+	for _, fLayout := range layouts {
+		switch field := fLayout.Field.(type) {
+		case EnumEntry:
+			assertions = append(assertions, Assertion{
+				Kind:   ValueAssertion,
+				Type:   name,
+				Member: field.Name,
+				Value:  field.Value,
+			})
+			continue
+		case BitField:
+			continue
+		}
+
+		var (
+			fieldName = FieldName(fLayout.Field)
+			offset    = base + fLayout.Offset()
+			member    = path
+		)
+
+		// the members of anonymous ones are designated as direct members
+		if fieldName != "" {
+			member = path + fieldName
+			assertions = append(assertions, Assertion{
+				Kind:   OffsetAssertion,
+				Type:   name,
+				Member: member,
+				Value:  int64(offset),
+			})
+			member += "."
+		}
+
+		// enumerators are not members, and are only checked for enums
+		fields := fLayout.Fields()
+		if len(fields) == 0 || ctx.isArrayType(fLayout.Field) {
+			continue
+		}
+
+		if _, isEntry := fields[0].Field.(EnumEntry); isEntry {
+			continue
+		}
+		assertions = append(assertions, ctx.memberAssertions(name, member, offset, fields)...)
+	}
+	return assertions
+}
+
+// isArrayType reports whether the passed field has an array type, either
+// directly or through a chain of typedefs.
+func (ctx Context) isArrayType(field Field) bool {
+	for range len(ctx.typedefs) + 1 {
+		switch field.(type) {
+		case Array, FlexibleArray:
+			return true
+		}
+
+		basic, isBasic := field.(Basic)
+		if !isBasic {
+			return false
+		}
+
+		typedef, isTypedef := ctx.typedefs[basic.UnqualifiedType()]
+		if !isTypedef {
+			return false
+		}
+		field = typedef
+	}
+	return false
+}
+
+// Expression returns the C constant expression checked by the assertion,
+// e.g. `offsetof(struct hdr, len) == 4`, using the C++ operators if cpp is
+// set, e.g. `alignof` rather than `_Alignof`.
+func (a Assertion) Expression(cpp bool) string {
+	switch a.Kind {
+	case SizeAssertion:
+		return fmt.Sprintf("sizeof(%s) == %d", a.Type, a.Value)
+	case AlignmentAssertion:
+		operator := "_Alignof"
+		if cpp {
+			operator = "alignof"
+		}
+		return fmt.Sprintf("%s(%s) == %d", operator, a.Type, a.Value)
+	case OffsetAssertion:
+		return fmt.Sprintf("offsetof(%s, %s) == %d", a.Type, a.Member, a.Value)
+	}
+	return fmt.Sprintf("%s == %d", a.Member, a.Value)
+}
+
+// Format returns the assertion as a C11 static assertion, or as a C++ one if
+// cpp is set, e.g. `_Static_assert(sizeof(struct hdr) == 12, "...");`.
+func (a Assertion) Format(cpp bool) string {
+	keyword := "_Static_assert"
+	if cpp {
+		keyword = "static_assert"
+	}
+
+	var subject string
+	switch a.Kind {
+	case SizeAssertion:
+		subject = "size"
+	case AlignmentAssertion:
+		subject = "alignment"
+	case OffsetAssertion:
+		subject = "offset of " + a.Member
+	case ValueAssertion:
+		subject = "value of " + a.Member
+	}
+
+	message := strings.ReplaceAll(fmt.Sprintf("%s: unexpected %s", a.Type, subject), `"`, `\"`)
+	return fmt.Sprintf("%s(%s, \"%s\");", keyword, a.Expression(cpp), message)
+}
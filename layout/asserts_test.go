@@ -0,0 +1,103 @@
+package layout
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Abathargh/stropt/abi"
+)
+
+func TestAssertions(t *testing.T) {
+	const test = `typedef struct p { int x; char y; } parr[2];
+	enum kind { K_A, K_B = 3 };
+	struct a1 { char c; unsigned f : 3; struct p pt; parr q; struct p r[2];
+	union { int i; float fl; }; enum kind k; char data[]; };`
+
+	testCases := []struct {
+		name     string
+		expected []string
+	}{
+		{
+			"struct a1",
+			[]string{
+				"sizeof(struct a1) == 52",
+				"_Alignof(struct a1) == 4",
+				"offsetof(struct a1, c) == 0",
+				"offsetof(struct a1, pt) == 4",
+				"offsetof(struct a1, pt.x) == 4",
+				"offsetof(struct a1, pt.y) == 8",
+				"offsetof(struct a1, q) == 12",
+				"offsetof(struct a1, r) == 28",
+				"offsetof(struct a1, i) == 44",
+				"offsetof(struct a1, fl) == 44",
+				"offsetof(struct a1, k) == 48",
+				"offsetof(struct a1, data) == 52",
+			},
+		},
+		{
+			"enum kind",
+			[]string{
+				"sizeof(enum kind) == 4",
+				"_Alignof(enum kind) == 4",
+				"K_A == 0",
+				"K_B == 3",
+			},
+		},
+	}
+
+	structs, err := ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	for _, testCase := range testCases {
+		meta, err := structs.ResolveMeta(testCase.name)
+		if err != nil {
+			t.Fatalf("Unexpected error when resolving %s: %s", testCase.name, err)
+		}
+
+		var expressions []string
+		for _, assertion := range structs.Assertions(testCase.name, meta) {
+			expressions = append(expressions, assertion.Expression(false))
+		}
+
+		if !slices.Equal(expressions, testCase.expected) {
+			t.Errorf("%s: expected assertions %v: got %v", testCase.name, testCase.expected, expressions)
+		}
+	}
+}
+
+func TestFormatAssertion(t *testing.T) {
+	testCases := []struct {
+		assertion Assertion
+		cpp       bool
+		expected  string
+	}{
+		{
+			Assertion{Kind: SizeAssertion, Type: "struct s", Value: 8},
+			false,
+			`_Static_assert(sizeof(struct s) == 8, "struct s: unexpected size");`,
+		},
+		{
+			Assertion{Kind: AlignmentAssertion, Type: "s_t", Value: 4},
+			true,
+			`static_assert(alignof(s_t) == 4, "s_t: unexpected alignment");`,
+		},
+		{
+			Assertion{Kind: OffsetAssertion, Type: "struct s", Member: "pos.x", Value: 12},
+			true,
+			`static_assert(offsetof(struct s, pos.x) == 12, "struct s: unexpected offset of pos.x");`,
+		},
+		{
+			Assertion{Kind: ValueAssertion, Type: "enum e", Member: "RED", Value: -1},
+			false,
+			`_Static_assert(RED == -1, "enum e: unexpected value of RED");`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if formatted := testCase.assertion.Format(testCase.cpp); formatted != testCase.expected {
+			t.Errorf("Expected %+v to be formatted as %q, got %q", testCase.assertion, testCase.expected, formatted)
+		}
+	}
+}
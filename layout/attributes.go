@@ -0,0 +1,437 @@
+package layout
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"modernc.org/cc/v4"
+	"modernc.org/token"
+)
+
+// attributes holds the GCC attributes that affect the layout of an aggregate
+// or of one of its fields.
+type attributes struct {
+	packed    bool
+	alignment int
+}
+
+const (
+	// biggestAlignment is the alignment used by a bare 'aligned' attribute,
+	// i.e. the largest alignment ever used for any data type on the target.
+	biggestAlignment = 16
+
+	// maxMacroDepth limits the expansion of macros within attribute text.
+	maxMacroDepth = 16
+)
+
+// parseAttributes extracts the layout related attributes from the passed
+// attribute specifier list, merging them into attrs.
+func (ctx Context) parseAttributes(list *cc.AttributeSpecifierList, attrs *attributes) error {
+	for ; list != nil; list = list.AttributeSpecifierList {
+		values := list.AttributeSpecifier.AttributeValueList
+		for ; values != nil; values = values.AttributeValueList {
+			var (
+				value = values.AttributeValue
+				name  = value.Token.SrcStr()
+				arg   = -1
+			)
+
+			// only the argument of aligned is evaluated, as the ones of other
+			// attributes may not be integer constant expressions at all
+			args := value.ArgumentExpressionList
+			if strings.Trim(name, "_") == "aligned" && args != nil {
+				var err error
+				if arg, err = ctx.evalInt(args.AssignmentExpression); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			}
+			attrs.apply(name, arg)
+		}
+	}
+	return nil
+}
+
+// parseFieldAttributes extracts the layout related attributes of a struct
+// declaration. These can be found both within the specifier list and after
+// the declarators, and apply to every field declared within it. Alignment
+// specifiers, i.e. `_Alignas`, are handled as an aligned attribute.
+func (ctx Context) parseFieldAttributes(fieldDecl *cc.StructDeclaration) (attributes, error) {
+	var attrs attributes
+
+	list := fieldDecl.SpecifierQualifierList
+	for ; list != nil; list = list.SpecifierQualifierList {
+		switch list.Case {
+		case cc.SpecifierQualifierListTypeQual:
+			qual := list.TypeQualifier
+			if qual.Case != cc.TypeQualifierAttr {
+				continue
+			}
+
+			if err := ctx.parseAttributes(qual.AttributeSpecifierList, &attrs); err != nil {
+				return attributes{}, err
+			}
+		case cc.SpecifierQualifierListAlignSpec:
+			alignment, err := ctx.parseAlignmentSpecifier(list.AlignmentSpecifier)
+			if err != nil {
+				return attributes{}, err
+			}
+			attrs.apply("aligned", alignment)
+		}
+	}
+
+	err := ctx.parseAttributes(fieldDecl.AttributeSpecifierList, &attrs)
+	return attrs, err
+}
+
+// parseSpecifiersAttributes extracts the layout related attributes found
+// within the specifiers of a declaration, e.g. a typedef, which apply to
+// every declarator within it.
+func (ctx Context) parseSpecifiersAttributes(specs *cc.DeclarationSpecifiers) (attributes, error) {
+	var attrs attributes
+	for ; specs != nil; specs = specs.DeclarationSpecifiers {
+		var list *cc.AttributeSpecifierList
+
+		switch specs.Case {
+		case cc.DeclarationSpecifiersAttr:
+			list = specs.AttributeSpecifierList
+		case cc.DeclarationSpecifiersTypeQual:
+			if specs.TypeQualifier.Case == cc.TypeQualifierAttr {
+				list = specs.TypeQualifier.AttributeSpecifierList
+			}
+		}
+
+		if err := ctx.parseAttributes(list, &attrs); err != nil {
+			return attributes{}, err
+		}
+	}
+	return attrs, nil
+}
+
+// parseAlignmentSpecifier computes the alignment requested by an alignment
+// specifier, which is either a constant expression or a type name, in which
+// case the alignment of that type is used.
+func (ctx Context) parseAlignmentSpecifier(spec *cc.AlignmentSpecifier) (int, error) {
+	if spec.Case == cc.AlignmentSpecifierExpr {
+		return ctx.evalInt(spec.ConstantExpression)
+	}
+
+	field, err := ctx.typeNameField(spec.TypeName)
+	if err != nil {
+		return 0, err
+	}
+
+	meta, err := ctx.fieldMeta(field)
+	if err != nil {
+		return 0, err
+	}
+	return meta.Alignment, nil
+}
+
+// apply merges a single attribute into attrs. The arg parameter is the
+// attribute argument, or -1 if the attribute has none.
+func (attrs *attributes) apply(name string, arg int) {
+	switch strings.Trim(name, "_") {
+	case "packed":
+		attrs.packed = true
+	case "aligned":
+		if arg < 0 {
+			arg = biggestAlignment
+		}
+		attrs.alignment = max(attrs.alignment, arg)
+	}
+}
+
+// parseAttributesText extracts the layout related attributes from raw source
+// text, expanding any object-like macro found within it. This is needed for
+// the attributes that the parser drops, e.g. the ones found between the
+// 'struct' keyword and the aggregate tag.
+func parseAttributesText(text string, macros map[string]*cc.Macro) attributes {
+	var (
+		attrs  attributes
+		tokens = expandMacros(tokenizeText(text), macros, 0)
+	)
+
+	for idx := 0; idx < len(tokens); idx++ {
+		if strings.Trim(tokens[idx], "_") != "attribute" {
+			continue
+		}
+
+		// __attribute__ ( ( value-list ) )
+		idx += 3
+		for depth := 2; idx < len(tokens) && depth > 0; idx++ {
+			switch tok := tokens[idx]; {
+			case tok == "(":
+				depth++
+			case tok == ")":
+				depth--
+			case depth == 2 && isIdentifier(tok):
+				arg := -1
+				if idx+2 < len(tokens) && tokens[idx+1] == "(" {
+					if value, err := parseIntLiteral(tokens[idx+2]); err == nil {
+						arg = value
+					}
+				}
+				attrs.apply(tok, arg)
+			}
+		}
+	}
+	return attrs
+}
+
+// tokenizeText splits C source text into identifiers, numbers and single
+// character punctuators, skipping white spaces and comments.
+func tokenizeText(text string) []string {
+	var tokens []string
+
+	for idx := 0; idx < len(text); {
+		switch ch := rune(text[idx]); {
+		case unicode.IsSpace(ch):
+			idx++
+		case strings.HasPrefix(text[idx:], "/*"):
+			end := strings.Index(text[idx+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			idx += end + 4
+		case strings.HasPrefix(text[idx:], "//"):
+			end := strings.IndexByte(text[idx:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			idx += end
+		case ch == '_' || unicode.IsLetter(ch) || unicode.IsDigit(ch):
+			start := idx
+			for idx < len(text) && isIdentRune(rune(text[idx])) {
+				idx++
+			}
+			tokens = append(tokens, text[start:idx])
+		default:
+			tokens = append(tokens, string(ch))
+			idx++
+		}
+	}
+	return tokens
+}
+
+// expandMacros replaces object-like macros within the passed token list with
+// their replacement list, recursively.
+func expandMacros(tokens []string, macros map[string]*cc.Macro, depth int) []string {
+	if depth > maxMacroDepth {
+		return tokens
+	}
+
+	var expanded []string
+	for _, tok := range tokens {
+		macro, isMacro := macros[tok]
+		if !isMacro || macro.IsFnLike {
+			expanded = append(expanded, tok)
+			continue
+		}
+
+		var replacement []string
+		for _, macroTok := range macro.ReplacementList() {
+			replacement = append(replacement, tokenizeText(macroTok.SrcStr())...)
+		}
+		expanded = append(expanded, expandMacros(replacement, macros, depth+1)...)
+	}
+	return expanded
+}
+
+// isIdentifier checks whether the passed token is a C identifier.
+func isIdentifier(tok string) bool {
+	return tok != "" && !unicode.IsDigit(rune(tok[0])) && isIdentRune(rune(tok[0]))
+}
+
+// isIdentRune checks whether the passed rune can be part of an identifier.
+func isIdentRune(ch rune) bool {
+	return ch == '_' || unicode.IsLetter(ch) || unicode.IsDigit(ch)
+}
+
+// parseIntLiteral parses a C integer literal, ignoring its suffix.
+func parseIntLiteral(literal string) (int, error) {
+	literal = strings.TrimRight(literal, "uUlL")
+	value, err := strconv.ParseInt(literal, 0, 0)
+	return int(value), err
+}
+
+// A sourceSet gives access to the text of the sources used within a
+// translation unit, for those parts that are not kept within the AST.
+type sourceSet map[string]string
+
+// newSourceSet initializes a sourceSet with the in-memory sources, while any
+// other file is read from disk when needed.
+func newSourceSet(sources []cc.Source) sourceSet {
+	set := make(sourceSet)
+	for _, source := range sources {
+		if value, isString := source.Value.(string); isString {
+			set[source.Name] = value
+		}
+	}
+	return set
+}
+
+// between returns the source text between the end of the from token and the
+// start of the to token, if both lie within the same file.
+func (set sourceSet) between(from, to cc.Token) string {
+	var (
+		start = from.Position()
+		end   = to.Position()
+	)
+
+	if start.Filename != end.Filename || start.Offset > end.Offset {
+		return ""
+	}
+
+	text, ok := set.file(start.Filename)
+	if !ok {
+		return ""
+	}
+
+	begin := start.Offset + len(from.Src())
+	if begin > end.Offset || end.Offset > len(text) {
+		return ""
+	}
+	return text[begin:end.Offset]
+}
+
+// file returns the text of the passed source file, reading it from disk if
+// it is not held already.
+func (set sourceSet) file(name string) (string, bool) {
+	if text, ok := set[name]; ok {
+		return text, true
+	}
+
+	cont, err := os.ReadFile(name)
+	if err != nil {
+		return "", false
+	}
+	set[name] = string(cont)
+	return set[name], true
+}
+
+// enumBaseRegexp matches the declaration of a C23 enum with a fixed
+// underlying type, e.g. `enum e : uint8_t {`, capturing the enum-type-specifier
+// and the underlying type.
+var enumBaseRegexp = regexp.MustCompile(`\benum(?:\s+[A-Za-z_]\w*)?\s*(:\s*([A-Za-z_][\w\s]*?))\s*\{`)
+
+// A sourcePos identifies a location within the sources of a translation unit.
+type sourcePos struct {
+	file   string
+	offset int
+}
+
+// positionKey returns the sourcePos for the passed token position.
+func positionKey(pos token.Position) sourcePos {
+	return sourcePos{pos.Filename, pos.Offset}
+}
+
+// stripEnumBases blanks out the enum-type-specifier of the C23 enums with a
+// fixed underlying type found within src, which the parser does not support,
+// keeping the offset of everything else unchanged. The underlying types are
+// returned keyed by the position of their 'enum' keyword.
+func stripEnumBases(name, src string) (string, map[sourcePos]string) {
+	var (
+		stripped = []byte(src)
+		bases    = make(map[sourcePos]string)
+	)
+
+	for _, match := range enumBaseRegexp.FindAllStringSubmatchIndex(src, -1) {
+		underlying := strings.Join(strings.Fields(src[match[4]:match[5]]), " ")
+		bases[sourcePos{name, match[0]}] = underlying
+
+		for idx := match[2]; idx < match[3]; idx++ {
+			if stripped[idx] != '\n' {
+				stripped[idx] = ' '
+			}
+		}
+	}
+	return string(stripped), bases
+}
+
+// A packEvent records the packing value in effect after a '#pragma pack'
+// directive, and where the directive was found.
+type packEvent struct {
+	pos   token.Position
+	value int
+}
+
+// A packTracker keeps track of the '#pragma pack' directives found while
+// preprocessing a translation unit, in the order they are found.
+type packTracker struct {
+	current int
+	stack   []int
+	events  []packEvent
+}
+
+// handlePragma implements the cc pragma handler, interpreting the supported
+// forms of the pack pragma: pack(N), pack(), pack(push[, N]) and pack(pop).
+// Identifiers within push/pop are accepted and ignored.
+func (pt *packTracker) handlePragma(toks []cc.Token) error {
+	var args []string
+	for idx := range toks {
+		if src := strings.TrimSpace(toks[idx].SrcStr()); src != "" {
+			args = append(args, src)
+		}
+	}
+
+	if len(args) < 3 || args[0] != "pack" || args[1] != "(" {
+		return nil
+	}
+
+	var (
+		value = 0
+		push  = false
+		pop   = false
+	)
+
+	for _, arg := range args[2 : len(args)-1] {
+		switch arg {
+		case "push":
+			push = true
+		case "pop":
+			pop = true
+		case ",":
+		default:
+			if num, err := parseIntLiteral(arg); err == nil {
+				value = num
+			}
+		}
+	}
+
+	switch {
+	case pop:
+		pt.current = 0
+		if len(pt.stack) > 0 {
+			pt.current = pt.stack[len(pt.stack)-1]
+			pt.stack = pt.stack[:len(pt.stack)-1]
+		}
+	case push:
+		pt.stack = append(pt.stack, pt.current)
+		if value != 0 {
+			pt.current = value
+		}
+	default:
+		pt.current = value
+	}
+
+	pt.events = append(pt.events, packEvent{toks[0].Position(), pt.current})
+	return nil
+}
+
+// packAt returns the packing value in effect at the passed position, or 0
+// if no packing is in effect. The AST does not tell where a directive lies
+// with respect to an aggregate found in another file, so only the directives
+// found earlier within the same file are known to precede it.
+func (pt *packTracker) packAt(pos token.Position) int {
+	for idx := len(pt.events) - 1; idx >= 0; idx-- {
+		event := pt.events[idx]
+		if event.pos.Filename == pos.Filename && event.pos.Offset < pos.Offset {
+			return event.value
+		}
+	}
+	return 0
+}
@@ -0,0 +1,429 @@
+package layout
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"modernc.org/cc/v4"
+)
+
+// aggregateKeywords maps the kind of an aggregate to the keyword defining it.
+var aggregateKeywords = map[AggregateKind]string{
+	StructKind: "struct",
+	UnionKind:  "union",
+	EnumKind:   "enum",
+}
+
+// DerivationKind represents the kind of a step within a Declarator.
+type DerivationKind uint
+
+const (
+	PointerDerivation DerivationKind = iota
+	ArrayDerivation
+	FunctionDerivation
+)
+
+// A Derivation is a step of a Declarator: a pointer, carrying its own
+// qualifiers, e.g. `*const`, an array of Length elements, or with no size at
+// all if Unsized, or a function taking the passed Parameters, followed by
+// `...` if Variadic. Functions declared with an empty parameter list have no
+// parameters, while the ones declared as taking `void` have a single one.
+type Derivation struct {
+	Kind       DerivationKind
+	Qualifiers []string
+	Length     int
+	Unsized    bool
+	Parameters []Parameter
+	Variadic   bool
+}
+
+// A Declarator describes how the type of a declaration is derived from the
+// type named by its specifiers, as the list of its derivations from the one
+// closest to the name outwards, e.g. `*names[4]` is an array of 4 pointers,
+// while `(*cb)(int)` is a pointer to a function. Values have no derivation.
+type Declarator []Derivation
+
+// A Parameter is a parameter of a function type, made of its qualifiers and
+// type, its name, which is empty for abstract declarators, e.g. `int *`, and
+// its own declarator.
+type Parameter struct {
+	Qualifiers []string
+	TypeName   string
+	Name       string
+	Declarator Declarator
+}
+
+// Format returns the C declarator declaring the passed name, which may be
+// empty for abstract declarators, with the derivations of d, e.g.
+// `*const names[4]` or `(*cb)(int, char *)`.
+func (d Declarator) Format(name string) string {
+	decl := name
+	for idx, derivation := range d {
+		if derivation.Kind == PointerDerivation {
+			qualifiers := strings.Join(derivation.Qualifiers, " ")
+			if qualifiers != "" && decl != "" {
+				qualifiers += " "
+			}
+			decl = "*" + qualifiers + decl
+			continue
+		}
+
+		// arrays and functions bind tighter than the pointers they follow
+		if idx > 0 && d[idx-1].Kind == PointerDerivation {
+			decl = "(" + decl + ")"
+		}
+
+		switch derivation.Kind {
+		case ArrayDerivation:
+			if derivation.Unsized {
+				decl += "[]"
+			} else {
+				decl += fmt.Sprintf("[%d]", derivation.Length)
+			}
+		case FunctionDerivation:
+			params := make([]string, 0, len(derivation.Parameters)+1)
+			for _, param := range derivation.Parameters {
+				params = append(params, param.String())
+			}
+
+			if derivation.Variadic {
+				params = append(params, "...")
+			}
+			decl += "(" + strings.Join(params, ", ") + ")"
+		}
+	}
+	return decl
+}
+
+// dimensions returns the length of each of the leading array derivations of
+// d, i.e. the dimensions of an array, zero for unsized ones.
+func (d Declarator) dimensions() []int {
+	var dims []int
+	for _, derivation := range d {
+		if derivation.Kind != ArrayDerivation {
+			break
+		}
+		dims = append(dims, derivation.Length)
+	}
+	return dims
+}
+
+// pointerQualifiers returns the qualifiers of the outermost pointer of d,
+// not counting the ones within the parameters or the return type of a
+// function, e.g. `const` for `*const names[4]`.
+func (d Declarator) pointerQualifiers() []string {
+	var qualifiers []string
+	for _, derivation := range d {
+		switch derivation.Kind {
+		case PointerDerivation:
+			qualifiers = derivation.Qualifiers
+		case FunctionDerivation:
+			return qualifiers
+		}
+	}
+	return qualifiers
+}
+
+// parameters returns the type of each parameter of the first function of d,
+// followed by `...` if it is variadic, e.g. `const char *` for
+// `(*cb)(const char *s)`.
+func (d Declarator) parameters() []string {
+	idx := slices.IndexFunc(d, func(derivation Derivation) bool {
+		return derivation.Kind == FunctionDerivation
+	})
+
+	if idx == -1 {
+		return nil
+	}
+
+	var params []string
+	for _, param := range d[idx].Parameters {
+		param.Name = ""
+		params = append(params, param.String())
+	}
+
+	if d[idx].Variadic {
+		params = append(params, "...")
+	}
+	return params
+}
+
+// String returns the C declaration of the parameter, e.g. `const char *s`.
+func (p Parameter) String() string {
+	return joinDeclaration(specifiers(p.Qualifiers, p.TypeName),
+		p.Declarator.Format(p.Name))
+}
+
+// Declare returns the C declaration of the passed field, without the
+// trailing semicolon, e.g. `const char *const names[4]`. Aggregates defined
+// inline are declared along with their body, on a single line, while
+// enumerators are declared alongside with their value, e.g. `RED = 1`.
+func Declare(field Field) string {
+	specs := Specifiers(field)
+
+	basic, isBasic := AsBasic(field)
+	if isBasic && basic.Inline != nil {
+		sep, term := " ", ";"
+		if basic.Inline.Kind == EnumKind {
+			sep, term = ", ", ""
+		}
+
+		var body []string
+		for _, inner := range basic.Inline.Fields {
+			body = append(body, Declare(inner)+term)
+		}
+		specs = fmt.Sprintf("%s { %s }", specs, strings.Join(body, sep))
+	}
+	return joinDeclaration(specs, FormatDeclarator(field))
+}
+
+// Specifiers returns the declaration specifiers of the passed field, i.e. its
+// alignment specifier, if any, its qualifiers and its type, e.g. `const char`
+// for `const char *const names[4]`. Enumerators have none.
+func Specifiers(field Field) string {
+	switch field := field.(type) {
+	case FuncPointer:
+		return specifiers(field.Qualifiers, field.ReturnType)
+	case EnumEntry:
+		return ""
+	}
+
+	basic, _ := AsBasic(field)
+	specs := specifiers(basic.Qualifiers, basic.TypeName)
+	if basic.Alignment != 0 {
+		specs = fmt.Sprintf("_Alignas(%d) %s", basic.Alignment, specs)
+	}
+	return specs
+}
+
+// FormatDeclarator returns the declarator of the passed field, which follows
+// its specifiers within its declaration, e.g. `*const names[4]`, alongside
+// with the width of bit-fields and the packed attribute, if any.
+func FormatDeclarator(field Field) string {
+	if entry, isEntry := field.(EnumEntry); isEntry {
+		return fmt.Sprintf("%s = %d", entry.Name, entry.Value)
+	}
+
+	var (
+		basic, _ = AsBasic(field)
+		decl     = fieldDeclarator(field).Format(FieldName(field))
+	)
+
+	if bitField, isBitField := field.(BitField); isBitField {
+		decl = fmt.Sprintf("%s:%d", decl, bitField.Width)
+	}
+
+	if basic.Packed {
+		decl = joinDeclaration(decl, "__attribute__((packed))")
+	}
+	return decl
+}
+
+// fieldDeclarator returns the declarator of the passed field.
+func fieldDeclarator(field Field) Declarator {
+	if funcPointer, isFuncPointer := field.(FuncPointer); isFuncPointer {
+		return funcPointer.Declarator
+	}
+
+	basic, _ := AsBasic(field)
+	return basic.Declarator
+}
+
+// OpenDefinition returns the lines opening the definition of the passed
+// aggregate, up to its opening brace, along with its attributes and typedef,
+// e.g. `typedef struct __attribute__((packed)) hdr {`. The '#pragma pack'
+// region the aggregate is defined within, if any, is opened first.
+func OpenDefinition(agg *Aggregate) string {
+	keyword, tag, _ := strings.Cut(agg.Name, " ")
+	if agg.Name == "" {
+		keyword = aggregateKeywords[agg.Kind]
+	}
+
+	var attributes []string
+	if agg.Packed {
+		attributes = append(attributes, "packed")
+	}
+
+	if agg.Alignment != 0 {
+		attributes = append(attributes, fmt.Sprintf("aligned(%d)", agg.Alignment))
+	}
+
+	head := keyword
+	if len(attributes) != 0 {
+		head += fmt.Sprintf(" __attribute__((%s))", strings.Join(attributes, ", "))
+	}
+
+	head = joinDeclaration(head, tag)
+	if agg.Underlying != "" {
+		head += " : " + agg.Underlying
+	}
+
+	if agg.Typedef != "" {
+		head = "typedef " + head
+	}
+
+	if agg.Pack != 0 {
+		return fmt.Sprintf("#pragma pack(push, %d)\n%s {", agg.Pack, head)
+	}
+	return head + " {"
+}
+
+// CloseDefinition returns the lines closing the definition of the passed
+// aggregate, from its closing brace, e.g. `} hdr_t;` for typedefs, followed
+// by the end of the '#pragma pack' region it is defined within, if any.
+func CloseDefinition(agg *Aggregate) string {
+	closing := joinDeclaration("}", agg.Typedef) + ";"
+	if agg.Pack != 0 {
+		closing += "\n#pragma pack(pop)"
+	}
+	return closing
+}
+
+// specifiers joins the passed qualifiers and type name.
+func specifiers(qualifiers []string, typeName string) string {
+	return strings.Join(append(slices.Clone(qualifiers), typeName), " ")
+}
+
+// joinDeclaration joins two parts of a declaration, leaving out the space
+// between them if either is empty.
+func joinDeclaration(first, second string) string {
+	if first == "" || second == "" {
+		return first + second
+	}
+	return first + " " + second
+}
+
+// parseDerivations returns the name declared by the passed declarator,
+// alongside with its derivations, from the one closest to the name outwards.
+func (ctx Context) parseDerivations(decl *cc.Declarator) (string, Declarator, error) {
+	name, derivations, err := ctx.directDerivations(decl.DirectDeclarator)
+	return name, append(derivations, pointerDerivations(decl.Pointer)...), err
+}
+
+// directDerivations is the equivalent of parseDerivations for a direct
+// declarator, whose array and function derivations precede the pointers of
+// the declarator holding it.
+func (ctx Context) directDerivations(direct *cc.DirectDeclarator) (string, Declarator, error) {
+	switch direct.Case {
+	case cc.DirectDeclaratorIdent:
+		return direct.Token.SrcStr(), nil, nil
+	case cc.DirectDeclaratorDecl:
+		return ctx.parseDerivations(direct.Declarator)
+	}
+
+	name, derivations, err := ctx.directDerivations(direct.DirectDeclarator)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var derivation Derivation
+	switch direct.Case {
+	case cc.DirectDeclaratorFuncParam, cc.DirectDeclaratorFuncIdent:
+		derivation, err = ctx.functionDerivation(direct.ParameterTypeList)
+	default:
+		derivation = ctx.arrayDerivation(direct.AssignmentExpression)
+	}
+	return name, append(derivations, derivation), err
+}
+
+// abstractDerivations is the equivalent of parseDerivations for abstract
+// declarators, e.g. `*[4]`, which may be nil.
+func (ctx Context) abstractDerivations(decl *cc.AbstractDeclarator) (Declarator, error) {
+	if decl == nil {
+		return nil, nil
+	}
+
+	var (
+		derivations Declarator
+		err         error
+	)
+
+	for direct := decl.DirectAbstractDeclarator; direct != nil; {
+		if direct.Case == cc.DirectAbstractDeclaratorDecl {
+			inner, err := ctx.abstractDerivations(direct.AbstractDeclarator)
+			derivations = append(inner, derivations...)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		var derivation Derivation
+		if direct.Case == cc.DirectAbstractDeclaratorFunc {
+			if derivation, err = ctx.functionDerivation(direct.ParameterTypeList); err != nil {
+				return nil, err
+			}
+		} else {
+			derivation = ctx.arrayDerivation(direct.AssignmentExpression)
+		}
+
+		// the outermost derivation is the first to be walked
+		derivations = append(Declarator{derivation}, derivations...)
+		direct = direct.DirectAbstractDeclarator
+	}
+	return append(derivations, pointerDerivations(decl.Pointer)...), nil
+}
+
+// pointerDerivations returns the derivations for the passed pointers, e.g.
+// `* const * volatile`, which are written from the outermost one.
+func pointerDerivations(ptr *cc.Pointer) Declarator {
+	var derivations Declarator
+	for ; ptr != nil; ptr = ptr.Pointer {
+		derivation := Derivation{Kind: PointerDerivation}
+		for q := ptr.TypeQualifiers; q != nil; q = q.TypeQualifiers {
+			if q.TypeQualifier.Case != cc.TypeQualifierAttr {
+				derivation.Qualifiers = append(derivation.Qualifiers, q.TypeQualifier.Token.SrcStr())
+			}
+		}
+		derivations = append(Declarator{derivation}, derivations...)
+	}
+	return derivations
+}
+
+// arrayDerivation returns the derivation for an array of the passed size.
+// Sizes which cannot be evaluated, e.g. the ones of variable length array
+// parameters, are left unspecified.
+func (ctx Context) arrayDerivation(size cc.ExpressionNode) Derivation {
+	if size == nil {
+		return Derivation{Kind: ArrayDerivation, Unsized: true}
+	}
+
+	length, err := ctx.arraySize(size)
+	if err != nil {
+		return Derivation{Kind: ArrayDerivation, Unsized: true}
+	}
+	return Derivation{Kind: ArrayDerivation, Length: length}
+}
+
+// functionDerivation returns the derivation for a function taking the passed
+// parameters, which are missing for empty parameter lists.
+func (ctx Context) functionDerivation(typeList *cc.ParameterTypeList) (Derivation, error) {
+	derivation := Derivation{Kind: FunctionDerivation}
+	if typeList == nil {
+		return derivation, nil
+	}
+
+	derivation.Variadic = typeList.Case == cc.ParameterTypeListVar
+	for list := typeList.ParameterList; list != nil; list = list.ParameterList {
+		paramDecl := list.ParameterDeclaration
+
+		qualifiers, typeName, _, err := ctx.parseDeclarationSpecifiers(paramDecl.DeclarationSpecifiers)
+		if err != nil {
+			return Derivation{}, err
+		}
+
+		param := Parameter{Qualifiers: qualifiers, TypeName: typeName}
+		if paramDecl.Case == cc.ParameterDeclarationDecl {
+			param.Name, param.Declarator, err = ctx.parseDerivations(paramDecl.Declarator)
+		} else {
+			param.Declarator, err = ctx.abstractDerivations(paramDecl.AbstractDeclarator)
+		}
+
+		if err != nil {
+			return Derivation{}, err
+		}
+		derivation.Parameters = append(derivation.Parameters, param)
+	}
+	return derivation, nil
+}
@@ -0,0 +1,131 @@
+package layout
+
+import (
+	"testing"
+
+	"github.com/Abathargh/stropt/abi"
+)
+
+func TestDeclare(t *testing.T) {
+	const test = `struct d1 {
+		int *a;
+		const char * const * volatile b;
+		int (*cb)(int);
+		const char *(*name)(const void *, unsigned long n, ...);
+		void (*handlers[4])(void);
+		int (*pa)[4];
+		int (**pp)(char *[]);
+		char *const names[2][3];
+		unsigned f : 3, : 0;
+		char *p[0];
+		unsigned char data[][4];
+	};
+	struct d2 {
+		_Alignas(8) char c;
+		char d __attribute__((packed));
+		void (*fn)();
+		struct { int x; char *y; } pos;
+		union { int i; float f; };
+		enum { RED, GREEN = 4 } color;
+	};`
+
+	expected := map[string][]string{
+		"struct d1": {
+			"int *a",
+			"const char *const *volatile b",
+			"int (*cb)(int)",
+			"const char *(*name)(const void *, unsigned long n, ...)",
+			"void (*handlers[4])(void)",
+			"int (*pa)[4]",
+			"int (**pp)(char *[])",
+			"char *const names[2][3]",
+			"unsigned f:3",
+			"unsigned :0",
+			"char *p[0]",
+			"unsigned char data[][4]",
+		},
+		"struct d2": {
+			"_Alignas(8) char c",
+			"char d __attribute__((packed))",
+			"void (*fn)()",
+			"struct { int x; char *y; } pos",
+			"union { int i; float f; }",
+			"enum { RED = 0, GREEN = 4 } color",
+		},
+	}
+
+	structs, err := ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	for name, declarations := range expected {
+		agg, _ := structs.Lookup(name)
+		if len(agg.Fields) != len(declarations) {
+			t.Fatalf("%s: expected %d fields, got %d", name, len(declarations), len(agg.Fields))
+		}
+
+		for idx, field := range agg.Fields {
+			if decl := Declare(field); decl != declarations[idx] {
+				t.Errorf("%s: expected field %d to be declared as %q, got %q",
+					name, idx, declarations[idx], decl)
+			}
+		}
+	}
+}
+
+func TestDeclareBuiltFields(t *testing.T) {
+	testCases := []struct {
+		field    Field
+		expected string
+		expType  string
+	}{
+		{Basic{Qualifiers: []string{"unsigned"}, TypeName: "int", Name: "a"}, "unsigned int a", "unsigned int"},
+		{
+			Pointer{Basic{TypeName: "char", Name: "p", Declarator: Declarator{pointerTo("const")}}},
+			"char *const p",
+			"char * const ",
+		},
+		{
+			Array{Basic: Basic{TypeName: "int", Name: "m", Declarator: Declarator{arrayOf(2), arrayOf(3)}}},
+			"int m[2][3]",
+			"int",
+		},
+		{
+			Array{
+				Basic: Basic{
+					TypeName: "void", Name: "h",
+					Declarator: Declarator{arrayOf(4), pointerTo(), functionOf("int", "char *")},
+				},
+				Element: FunctionPointerKind,
+			},
+			"void (*h[4])(int, char *)",
+			"void (*) int, char *",
+		},
+		{
+			FlexibleArray{Array{
+				Basic:   Basic{TypeName: "char", Name: "s", Declarator: Declarator{unsized(), pointerTo()}},
+				Element: PointerKind,
+			}, false},
+			"char *s[]",
+			"char * ",
+		},
+		{BitField{Basic{TypeName: "unsigned", Name: "f"}, 2}, "unsigned f:2", "unsigned"},
+		{
+			FuncPointer{ReturnType: "int", Name: "cb", Declarator: Declarator{pointerTo(), functionOf("int")}},
+			"int (*cb)(int)",
+			"int (*) int",
+		},
+		{EnumEntry{Name: "RED", Value: 1}, "RED = 1", "enum"},
+	}
+
+	for _, testCase := range testCases {
+		if decl := Declare(testCase.field); decl != testCase.expected {
+			t.Errorf("Expected %+v to be declared as %q, got %q", testCase.field, testCase.expected, decl)
+		}
+
+		if fType := testCase.field.Type(); fType != testCase.expType {
+			t.Errorf("Expected %+v to have type %q, got %q", testCase.field, testCase.expType, fType)
+		}
+	}
+}
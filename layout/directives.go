@@ -0,0 +1,112 @@
+package layout
+
+import (
+	"strings"
+
+	"modernc.org/cc/v4"
+)
+
+// directivePrefix introduces the stropt directives within a comment, e.g.
+// `/* stropt: pin */`.
+const directivePrefix = "stropt:"
+
+// fieldDirectives collects the stropt directives found within the comments
+// next to the fields of the passed struct or union body, keyed by the name
+// of the fields they refer to. A comment refers to the declaration it lies
+// within, or to the declaration ending on the same line before it, or else
+// to the next one, e.g.:
+//
+//	struct hdr {
+//		uint32_t magic;   /* stropt: pin */
+//		// stropt: group hot
+//		uint8_t flags;
+//	};
+//
+// The comments within the body of an inline aggregate refer to its fields.
+// Each of the fields of the passed declarations are listed in fields, and
+// the comments referring to anonymous members are ignored.
+func (ctx Context) fieldDirectives(spec *cc.StructOrUnionSpecifier,
+	decls []*cc.StructDeclaration, fields [][]Field) map[string][]string {
+	if ctx.text == nil || len(decls) == 0 {
+		return nil
+	}
+
+	var (
+		directives = make(map[string][]string)
+		prev       = spec.Token2
+	)
+
+	add := func(idx int, text string) {
+		for _, directive := range parseDirectives(text) {
+			for _, field := range fields[idx] {
+				if name := FieldName(field); name != "" {
+					directives[name] = append(directives[name], directive)
+				}
+			}
+		}
+	}
+
+	for idx, decl := range decls {
+		text := ctx.text.between(prev, decl.Token)
+
+		// the first line belongs to the previous declaration, if any
+		if idx > 0 {
+			trailing, rest, _ := strings.Cut(text, "\n")
+			add(idx-1, trailing)
+			text = rest
+		}
+
+		add(idx, text)
+		prev = decl.Token
+	}
+
+	trailing, _, _ := strings.Cut(ctx.text.between(prev, spec.Token3), "\n")
+	add(len(decls)-1, trailing)
+
+	if len(directives) == 0 {
+		return nil
+	}
+	return directives
+}
+
+// parseDirectives returns the stropt directives found within the comments of
+// the passed source text, excluding the ones nested within braces. Multiple
+// directives can be separated by commas, e.g. `// stropt: pin, group hot`.
+func parseDirectives(text string) []string {
+	var (
+		directives []string
+		depth      = 0
+	)
+
+	for len(text) > 0 {
+		var comment string
+
+		switch {
+		case strings.HasPrefix(text, "/*"):
+			comment, text, _ = strings.Cut(text[2:], "*/")
+		case strings.HasPrefix(text, "//"):
+			comment, text, _ = strings.Cut(text[2:], "\n")
+		default:
+			switch text[0] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+			}
+			text = text[1:]
+			continue
+		}
+
+		_, body, found := strings.Cut(comment, directivePrefix)
+		if !found || depth != 0 {
+			continue
+		}
+
+		for _, directive := range strings.Split(body, ",") {
+			if directive = strings.Join(strings.Fields(directive), " "); directive != "" {
+				directives = append(directives, directive)
+			}
+		}
+	}
+	return directives
+}
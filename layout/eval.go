@@ -0,0 +1,558 @@
+package layout
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"modernc.org/cc/v4"
+)
+
+var (
+	ErrExpr = errors.New("cannot evaluate constant expression")
+)
+
+// A constValue is the value of an integer constant expression, alongside with
+// the size in bytes and the signedness of its type, which are needed to wrap
+// the results around as C does, e.g. `~0u` is 0xffffffff with a 32 bit int.
+type constValue struct {
+	value    int64
+	size     int
+	unsigned bool
+}
+
+// Evaluate computes the value of an integer constant expression. Enumeration
+// constants, and the size and alignment of the types used within sizeof and
+// _Alignof, are looked up within the context.
+func (ctx Context) Evaluate(expr cc.ExpressionNode) (int64, error) {
+	value, err := ctx.evaluate(expr)
+	return value.value, err
+}
+
+// evalInt evaluates an integer constant expression whose value must fit an
+// int, e.g. an array size or a bit-field width.
+func (ctx Context) evalInt(expr cc.ExpressionNode) (int, error) {
+	value, err := ctx.Evaluate(expr)
+	if err != nil {
+		return 0, err
+	}
+
+	if minInt, maxInt := intRange(ctx.target.Int.Size); value < minInt || value > maxInt {
+		return 0, fmt.Errorf("%w: value out of range: %d", ErrExpr, value)
+	}
+	return int(value), nil
+}
+
+// evaluate implements the evaluation of integer constant expressions,
+// recursively walking the expression tree.
+func (ctx Context) evaluate(expr cc.ExpressionNode) (constValue, error) {
+	switch expr := expr.(type) {
+	case *cc.ConstantExpression:
+		return ctx.evaluate(expr.ConditionalExpression)
+	case *cc.ExpressionList:
+		// the comma operator yields its right-most operand
+		var (
+			value constValue
+			err   error
+		)
+		for ; expr != nil; expr = expr.ExpressionList {
+			if value, err = ctx.evaluate(expr.AssignmentExpression); err != nil {
+				return constValue{}, err
+			}
+		}
+		return value, nil
+	case *cc.AssignmentExpression:
+		if expr.Case != cc.AssignmentExpressionCond {
+			return constValue{}, unsupported(expr.Token)
+		}
+		return ctx.evaluate(expr.ConditionalExpression)
+	case *cc.ConditionalExpression:
+		return ctx.evalConditional(expr)
+	case *cc.LogicalOrExpression:
+		if expr.Case == cc.LogicalOrExpressionLAnd {
+			return ctx.evaluate(expr.LogicalAndExpression)
+		}
+		return ctx.evalLogical(expr.LogicalOrExpression, expr.LogicalAndExpression, true)
+	case *cc.LogicalAndExpression:
+		if expr.Case == cc.LogicalAndExpressionOr {
+			return ctx.evaluate(expr.InclusiveOrExpression)
+		}
+		return ctx.evalLogical(expr.LogicalAndExpression, expr.InclusiveOrExpression, false)
+	case *cc.InclusiveOrExpression:
+		if expr.Case == cc.InclusiveOrExpressionXor {
+			return ctx.evaluate(expr.ExclusiveOrExpression)
+		}
+		return ctx.evalBinary(expr.Token, expr.InclusiveOrExpression, expr.ExclusiveOrExpression)
+	case *cc.ExclusiveOrExpression:
+		if expr.Case == cc.ExclusiveOrExpressionAnd {
+			return ctx.evaluate(expr.AndExpression)
+		}
+		return ctx.evalBinary(expr.Token, expr.ExclusiveOrExpression, expr.AndExpression)
+	case *cc.AndExpression:
+		if expr.Case == cc.AndExpressionEq {
+			return ctx.evaluate(expr.EqualityExpression)
+		}
+		return ctx.evalBinary(expr.Token, expr.AndExpression, expr.EqualityExpression)
+	case *cc.EqualityExpression:
+		if expr.Case == cc.EqualityExpressionRel {
+			return ctx.evaluate(expr.RelationalExpression)
+		}
+		return ctx.evalBinary(expr.Token, expr.EqualityExpression, expr.RelationalExpression)
+	case *cc.RelationalExpression:
+		if expr.Case == cc.RelationalExpressionShift {
+			return ctx.evaluate(expr.ShiftExpression)
+		}
+		return ctx.evalBinary(expr.Token, expr.RelationalExpression, expr.ShiftExpression)
+	case *cc.ShiftExpression:
+		if expr.Case == cc.ShiftExpressionAdd {
+			return ctx.evaluate(expr.AdditiveExpression)
+		}
+		return ctx.evalBinary(expr.Token, expr.ShiftExpression, expr.AdditiveExpression)
+	case *cc.AdditiveExpression:
+		if expr.Case == cc.AdditiveExpressionMul {
+			return ctx.evaluate(expr.MultiplicativeExpression)
+		}
+		return ctx.evalBinary(expr.Token, expr.AdditiveExpression, expr.MultiplicativeExpression)
+	case *cc.MultiplicativeExpression:
+		if expr.Case == cc.MultiplicativeExpressionCast {
+			return ctx.evaluate(expr.CastExpression)
+		}
+		return ctx.evalBinary(expr.Token, expr.MultiplicativeExpression, expr.CastExpression)
+	case *cc.CastExpression:
+		if expr.Case == cc.CastExpressionUnary {
+			return ctx.evaluate(expr.UnaryExpression)
+		}
+		return ctx.evalCast(expr)
+	case *cc.UnaryExpression:
+		return ctx.evalUnary(expr)
+	case *cc.PostfixExpression:
+		if expr.Case != cc.PostfixExpressionPrimary {
+			return constValue{}, unsupported(expr.Token)
+		}
+		return ctx.evaluate(expr.PrimaryExpression)
+	case *cc.PrimaryExpression:
+		return ctx.evalPrimary(expr)
+	case nil:
+		return constValue{}, fmt.Errorf("%w: missing expression", ErrExpr)
+	}
+	return constValue{}, fmt.Errorf("%w: unsupported expression %T", ErrExpr, expr)
+}
+
+// evalPrimary evaluates identifiers, i.e. enumeration constants, integer and
+// character literals, and parenthesized expressions.
+func (ctx Context) evalPrimary(expr *cc.PrimaryExpression) (constValue, error) {
+	switch expr.Case {
+	case cc.PrimaryExpressionIdent:
+		value, isEnumerator := ctx.enumerators[expr.Token.SrcStr()]
+		if !isEnumerator {
+			return constValue{}, fmt.Errorf("%w: %s: not an enumeration constant",
+				ErrExpr, expr.Token.SrcStr())
+		}
+		return value, nil
+	case cc.PrimaryExpressionInt:
+		return ctx.parseIntConstant(expr.Token.SrcStr())
+	case cc.PrimaryExpressionChar, cc.PrimaryExpressionLChar:
+		return ctx.parseCharConstant(expr.Token.SrcStr())
+	case cc.PrimaryExpressionExpr:
+		return ctx.evaluate(expr.ExpressionList)
+	}
+	return constValue{}, unsupported(expr.Token)
+}
+
+// evalUnary evaluates the unary arithmetic operators, sizeof and _Alignof.
+func (ctx Context) evalUnary(expr *cc.UnaryExpression) (constValue, error) {
+	switch expr.Case {
+	case cc.UnaryExpressionPostfix:
+		return ctx.evaluate(expr.PostfixExpression)
+	case cc.UnaryExpressionSizeofType, cc.UnaryExpressionAlignofType:
+		field, err := ctx.typeNameField(expr.TypeName)
+		if err != nil {
+			return constValue{}, err
+		}
+
+		meta, err := ctx.fieldMeta(field)
+		if err != nil {
+			return constValue{}, err
+		}
+
+		if expr.Case == cc.UnaryExpressionAlignofType {
+			return ctx.sizeValue(meta.Alignment), nil
+		}
+		return ctx.sizeValue(meta.Size), nil
+	case cc.UnaryExpressionSizeofExpr, cc.UnaryExpressionAlignofExpr:
+		// only the type of constant expressions is known
+		operand, err := ctx.evaluate(expr.UnaryExpression)
+		if err != nil {
+			return constValue{}, err
+		}
+		return ctx.sizeValue(operand.size), nil
+	}
+
+	operand, err := ctx.evaluate(expr.CastExpression)
+	if err != nil {
+		return constValue{}, err
+	}
+
+	operand = ctx.promote(operand)
+	switch expr.Case {
+	case cc.UnaryExpressionPlus:
+		return operand, nil
+	case cc.UnaryExpressionMinus:
+		operand.value = -operand.value
+		return operand.wrap(), nil
+	case cc.UnaryExpressionCpl:
+		operand.value = ^operand.value
+		return operand.wrap(), nil
+	case cc.UnaryExpressionNot:
+		return ctx.boolValue(operand.value == 0), nil
+	}
+	return constValue{}, unsupported(expr.Token)
+}
+
+// evalCast evaluates a cast to an integer type, converting the value to it.
+func (ctx Context) evalCast(expr *cc.CastExpression) (constValue, error) {
+	field, err := ctx.typeNameField(expr.TypeName)
+	if err != nil {
+		return constValue{}, err
+	}
+
+	basic, isBasic := field.(Basic)
+	if !isBasic {
+		return constValue{}, fmt.Errorf("%w: cast to non integer type %s",
+			ErrExpr, field.Type())
+	}
+
+	meta, err := ctx.fieldMeta(basic)
+	if err != nil {
+		return constValue{}, err
+	}
+
+	operand, err := ctx.evaluate(expr.CastExpression)
+	if err != nil {
+		return constValue{}, err
+	}
+
+	typeName := ctx.underlyingType(basic.UnqualifiedType())
+	if typeName == "_Bool" {
+		return constValue{value: ctx.boolValue(operand.value != 0).value, size: meta.Size}, nil
+	}
+
+	operand.size, operand.unsigned = meta.Size, isUnsignedType(typeName)
+	return operand.wrap(), nil
+}
+
+// evalConditional evaluates the conditional operator, only evaluating the
+// operand which is selected.
+func (ctx Context) evalConditional(expr *cc.ConditionalExpression) (constValue, error) {
+	if expr.Case == cc.ConditionalExpressionLOr {
+		return ctx.evaluate(expr.LogicalOrExpression)
+	}
+
+	cond, err := ctx.evaluate(expr.LogicalOrExpression)
+	if err != nil {
+		return constValue{}, err
+	}
+
+	if cond.value != 0 {
+		return ctx.evaluate(expr.ExpressionList)
+	}
+	return ctx.evaluate(expr.ConditionalExpression)
+}
+
+// evalLogical evaluates the logical and/or operators, short-circuiting them.
+func (ctx Context) evalLogical(lhs, rhs cc.ExpressionNode, or bool) (constValue, error) {
+	left, err := ctx.evaluate(lhs)
+	if err != nil {
+		return constValue{}, err
+	}
+
+	if (left.value != 0) == or {
+		return ctx.boolValue(or), nil
+	}
+
+	right, err := ctx.evaluate(rhs)
+	if err != nil {
+		return constValue{}, err
+	}
+	return ctx.boolValue(right.value != 0), nil
+}
+
+// evalBinary evaluates the binary arithmetic, bitwise, shift and comparison
+// operators, applying the usual arithmetic conversions to their operands.
+func (ctx Context) evalBinary(op cc.Token, lhs, rhs cc.ExpressionNode) (constValue, error) {
+	left, err := ctx.evaluate(lhs)
+	if err != nil {
+		return constValue{}, err
+	}
+
+	right, err := ctx.evaluate(rhs)
+	if err != nil {
+		return constValue{}, err
+	}
+
+	// shifts have the type of their promoted left operand
+	if opStr := op.SrcStr(); opStr == "<<" || opStr == ">>" {
+		left = ctx.promote(left)
+		if right.value < 0 || right.value >= int64(left.size*8) {
+			return constValue{}, fmt.Errorf("%w: invalid shift count %d", ErrExpr,
+				right.value)
+		}
+
+		if opStr == "<<" {
+			left.value <<= right.value
+		} else if left.unsigned {
+			left.value = int64(uint64(left.value) >> right.value)
+		} else {
+			left.value >>= right.value
+		}
+		return left.wrap(), nil
+	}
+
+	var (
+		result       = ctx.commonType(left, right)
+		lVal, rVal   = left.value, right.value
+		lUnsig, rUns = uint64(lVal), uint64(rVal)
+	)
+
+	switch op.SrcStr() {
+	case "+":
+		result.value = lVal + rVal
+	case "-":
+		result.value = lVal - rVal
+	case "*":
+		result.value = lVal * rVal
+	case "/", "%":
+		if rVal == 0 {
+			return constValue{}, fmt.Errorf("%w: division by zero", ErrExpr)
+		}
+
+		switch {
+		case result.unsigned && op.SrcStr() == "/":
+			result.value = int64(lUnsig / rUns)
+		case result.unsigned:
+			result.value = int64(lUnsig % rUns)
+		case op.SrcStr() == "/":
+			result.value = lVal / rVal
+		default:
+			result.value = lVal % rVal
+		}
+	case "&":
+		result.value = lVal & rVal
+	case "|":
+		result.value = lVal | rVal
+	case "^":
+		result.value = lVal ^ rVal
+	case "==":
+		return ctx.boolValue(lVal == rVal), nil
+	case "!=":
+		return ctx.boolValue(lVal != rVal), nil
+	case "<":
+		return ctx.boolValue(less(lVal, rVal, result.unsigned)), nil
+	case ">":
+		return ctx.boolValue(less(rVal, lVal, result.unsigned)), nil
+	case "<=":
+		return ctx.boolValue(!less(rVal, lVal, result.unsigned)), nil
+	case ">=":
+		return ctx.boolValue(!less(lVal, rVal, result.unsigned)), nil
+	default:
+		return constValue{}, unsupported(op)
+	}
+	return result.wrap(), nil
+}
+
+// typeNameField builds a Field out of a type name, e.g. the operand of
+// sizeof, so that its size and alignment can be computed as for any field.
+func (ctx Context) typeNameField(typeName *cc.TypeName) (Field, error) {
+	qualifiers, name, inline, err := ctx.parseQualifiers(typeName.SpecifierQualifierList)
+	if err != nil {
+		return nil, err
+	}
+
+	basic := Basic{Qualifiers: qualifiers, TypeName: name, Inline: inline}
+	if typeName.AbstractDeclarator == nil {
+		return basic, nil
+	}
+
+	meta, kind, err := ctx.parseAbstractDeclarator(typeName.AbstractDeclarator)
+	if err != nil {
+		return nil, err
+	}
+
+	meta.declarator, err = ctx.abstractDerivations(typeName.AbstractDeclarator)
+	if err != nil {
+		return nil, err
+	}
+	return newField(basic, meta, kind), nil
+}
+
+// underlyingType follows the chain of typedefs starting from the passed type
+// name, returning the name of the type they resolve to.
+func (ctx Context) underlyingType(typeName string) string {
+	for range len(ctx.typedefs) {
+		typedef, isTypedef := ctx.typedefs[typeName].(Basic)
+		if !isTypedef {
+			break
+		}
+		typeName = typedef.UnqualifiedType()
+	}
+	return typeName
+}
+
+// promote applies the integer promotions: any type smaller than int is
+// promoted to int.
+func (ctx Context) promote(v constValue) constValue {
+	if intSize := ctx.target.Int.Size; v.size < intSize {
+		v.size, v.unsigned = intSize, false
+	}
+	return v
+}
+
+// wrap truncates the value to the size of its type, sign extending it if the
+// type is a signed one.
+func (v constValue) wrap() constValue {
+	bits := v.size * 8
+	if bits <= 0 || bits >= 64 {
+		return v
+	}
+
+	if v.unsigned {
+		v.value &= 1<<bits - 1
+	} else {
+		shift := 64 - bits
+		v.value = v.value << shift >> shift
+	}
+	return v
+}
+
+// intRange returns the smallest and the largest value of a signed integer
+// type of the passed size.
+func intRange(size int) (int64, int64) {
+	if bits := size * 8; bits > 0 && bits < 64 {
+		return -1 << (bits - 1), 1<<(bits-1) - 1
+	}
+	return math.MinInt64, math.MaxInt64
+}
+
+// commonType applies the usual arithmetic conversions to the operands of a
+// binary operator, returning a value of the resulting type.
+func (ctx Context) commonType(left, right constValue) constValue {
+	left, right = ctx.promote(left), ctx.promote(right)
+
+	switch {
+	case left.size > right.size:
+		return constValue{size: left.size, unsigned: left.unsigned}
+	case right.size > left.size:
+		return constValue{size: right.size, unsigned: right.unsigned}
+	}
+	return constValue{size: left.size, unsigned: left.unsigned || right.unsigned}
+}
+
+// less compares two values, as unsigned ones if needed.
+func less(lhs, rhs int64, unsigned bool) bool {
+	if unsigned {
+		return uint64(lhs) < uint64(rhs)
+	}
+	return lhs < rhs
+}
+
+// boolValue returns the int value of a comparison or logical operator.
+func (ctx Context) boolValue(cond bool) constValue {
+	value := constValue{size: ctx.target.Int.Size}
+	if cond {
+		value.value = 1
+	}
+	return value
+}
+
+// sizeValue returns a value of type size_t, i.e. the result of sizeof.
+func (ctx Context) sizeValue(size int) constValue {
+	return constValue{value: int64(size), size: ctx.types["size_t"].Size, unsigned: true}
+}
+
+// isUnsignedType checks whether the passed integer type name is unsigned.
+func isUnsignedType(typeName string) bool {
+	return strings.Contains(typeName, "unsigned") || strings.HasPrefix(typeName, "uint") ||
+		typeName == "_Bool" || typeName == "size_t"
+}
+
+// parseIntConstant parses a C integer literal, be it a decimal, octal, hex or
+// binary one, and determines its type from its suffix and its value: the
+// first type among int, long and long long, or their unsigned counterparts,
+// that can represent it.
+func (ctx Context) parseIntConstant(literal string) (constValue, error) {
+	var (
+		lower  = strings.ToLower(literal)
+		digits = strings.TrimRight(lower, "ul")
+		suffix = lower[len(digits):]
+	)
+
+	value, err := strconv.ParseUint(digits, 0, 64)
+	if err != nil {
+		return constValue{}, fmt.Errorf("%w: invalid integer constant %s", ErrExpr, literal)
+	}
+
+	var (
+		unsigned = strings.Contains(suffix, "u")
+		decimal  = digits == "0" || !strings.HasPrefix(digits, "0")
+		sizes    = []int{ctx.target.Int.Size, ctx.target.Long.Size, ctx.target.LongLong.Size}
+	)
+
+	switch strings.Count(suffix, "l") {
+	case 1:
+		sizes = sizes[1:]
+	case 2:
+		sizes = sizes[2:]
+	}
+
+	for _, size := range sizes {
+		maxSigned := uint64(1)<<(size*8-1) - 1
+		switch {
+		case !unsigned && value <= maxSigned:
+			return constValue{int64(value), size, false}, nil
+		case (unsigned || !decimal) && value <= maxSigned<<1|1:
+			return constValue{int64(value), size, true}, nil
+		}
+	}
+	return constValue{int64(value), sizes[len(sizes)-1], true}, nil
+}
+
+// parseCharConstant parses a C character constant, e.g. 'a', '\n', '\0' or
+// '\x41', which has type int.
+func (ctx Context) parseCharConstant(literal string) (constValue, error) {
+	var (
+		wide  = !strings.HasPrefix(literal, "'")
+		start = strings.IndexByte(literal, '\'')
+		body  = strings.TrimSuffix(literal[start+1:], "'")
+		value int64
+	)
+
+	switch {
+	case strings.HasPrefix(body, "\\") && len(body) > 1 && body[1] >= '0' && body[1] <= '7':
+		octal, err := strconv.ParseInt(body[1:], 8, 64)
+		if err != nil {
+			return constValue{}, fmt.Errorf("%w: invalid character constant %s", ErrExpr, literal)
+		}
+		value = octal
+	default:
+		char, _, tail, err := strconv.UnquoteChar(body, '\'')
+		if err != nil || tail != "" {
+			return constValue{}, fmt.Errorf("%w: invalid character constant %s", ErrExpr, literal)
+		}
+		value = int64(char)
+	}
+
+	// plain characters are signed
+	if !wide && value > math.MaxInt8 && value <= math.MaxUint8 {
+		value = int64(int8(value))
+	}
+	return constValue{value: value, size: ctx.target.Int.Size}, nil
+}
+
+// unsupported returns the error for an operator that cannot be used within
+// an integer constant expression.
+func unsupported(tok cc.Token) error {
+	return fmt.Errorf("%w: unsupported operator '%s' at %v", ErrExpr, tok.SrcStr(),
+		tok.Position())
+}
@@ -0,0 +1,1084 @@
+// Package layout parses the definitions of C aggregates, i.e. structs, unions
+// and enums, and computes their memory layout for a target ABI. The parser
+// and the layout engine go together, since the constant expressions found
+// within definitions, e.g. `char buf[sizeof(struct hdr)];`, need layouts.
+package layout
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/Abathargh/stropt/abi"
+	"modernc.org/cc/v4"
+)
+
+// A Context object holds the name/aggregate mappings for all parsed
+// aggregates within the source code under analysis. A pointer to an aggregate
+// may be present under multiple identifiers, which are the mapping keys.
+// The types defined through typedefs that do not define an aggregate, e.g.
+// `typedef unsigned int u32;`, are held as well, described by a Field named
+// after the typedef. The values of the enumeration constants are held too, so
+// that they can be used within constant expressions, e.g. array sizes, as
+// well as the fixed underlying types of C23 enums, which are stripped from
+// the source before parsing it, and the source text itself, so that the
+// comments holding stropt directives can be recovered.
+// The aggregates are also kept in the order they are defined, so that they
+// can be listed.
+// Both constant expressions and layouts are evaluated for the target the
+// context is created for, whose type metadata is held in a lookup table.
+type Context struct {
+	aggregates  map[string]*Aggregate
+	typedefs    map[string]Field
+	enumerators map[string]constValue
+	enumBases   map[sourcePos]string
+	text        sourceSet
+	order       []*Aggregate
+	target      abi.Target
+	types       map[string]abi.TypeMeta
+}
+
+// NewContext returns an empty context for the passed target, ready to hold
+// the result of parsing a translation unit.
+func NewContext(target abi.Target) Context {
+	return Context{
+		aggregates:  make(map[string]*Aggregate),
+		typedefs:    make(map[string]Field),
+		enumerators: make(map[string]constValue),
+		enumBases:   make(map[sourcePos]string),
+		target:      target,
+		types:       target.TypeMap(),
+	}
+}
+
+// Target returns the target the context has been created for.
+func (ctx Context) Target() abi.Target {
+	return ctx.target
+}
+
+// A Layout object holds size/alignment/padding information with reference to
+// a field of an aggregate. If the field is an Aggregate type, then the
+// subAggregate slice is non-nil and contains the layout information for
+// each of its sub-fields.
+//
+// Sizes and paddings are expressed in bytes, with bitSize and bitPadding
+// holding any remaining bits that do not make up a whole byte, which can
+// only happen when bit-fields are involved. For bit-fields, bitOffset holds
+// the offset of the field in bits, from the start of the aggregate, while
+// offset holds the offset in bytes of any field, rounded down for bit-fields.
+// The fields of a union all have a zero offset.
+type Layout struct {
+	Field
+	offset       int
+	size         int
+	alignment    int
+	padding      int
+	bitSize      int
+	bitPadding   int
+	bitOffset    int
+	subAggregate []Layout
+}
+
+// An AggregateMeta object holds the size and alignment data related to an
+// aggregate in general, and a list of the same layout information for each
+// of its fields-
+// Diagnostics holds the issues found within an aggregate that do not prevent
+// its layout from being computed, e.g. a flexible array member which is not
+// the last field of the aggregate. For enums, Underlying holds the name of
+// their underlying type.
+type AggregateMeta struct {
+	Size        int
+	Alignment   int
+	Layout      []Layout
+	Diagnostics []error
+	Underlying  string
+}
+
+var (
+	ErrConfig = errors.New("could not create a config for the parser")
+	ErrParse  = errors.New("cannot parse source")
+	ErrSymbol = errors.New("cannot find symbol")
+	ErrWidth  = errors.New("invalid bit-field width")
+	ErrFlex   = errors.New("flexible array member not at the end of the aggregate")
+)
+
+const (
+	includeErrMsg = `
+you are using an '#include' directive, but the tool does not resolve include 
+paths by default. Use the '-use-compiler' flag to force that behavior.`
+	compErrMsg = `
+you are attempting to use the system compiler through the '-use-compiler' 
+flag, but it could be that you do not have one installed or that this tool 
+cannot find it. The tool checks the CC environment variable, cc alias and gcc 
+executable for a compiler and fails if no one works.`
+	parseErrMsg = `
+you may have a C syntax error or you may be using a type defined somewhere 
+else, without including it via '#include'.`
+
+	predefined = `
+int __predefined_declarator;
+
+typedef unsigned %s __predefined_size_t;
+`
+
+	// C23 makes alignas a keyword, while C11 defines it in 'stdalign.h': let
+	// us accept it in both cases
+	stdalign = `
+#ifndef alignas
+#define alignas _Alignas
+#endif
+`
+
+	// if the user is not using an include path from a local compiler
+	// installation, we add a simplified version of 'stdint.h' so that quick
+	// test snippets do not need '#include'
+	stdint = `
+typedef signed char        int8_t;
+typedef short              int16_t;
+typedef int                int32_t;
+typedef long long          int64_t;
+
+typedef unsigned char      uint8_t;
+typedef unsigned short     uint16_t;
+typedef unsigned int       uint32_t;
+typedef unsigned long long uint64_t;
+
+typedef int8_t             int_least8_t;
+typedef int16_t            int_least16_t;
+typedef int32_t            int_least32_t;
+typedef int64_t            int_least64_t;
+
+typedef uint8_t            uint_least8_t;
+typedef uint16_t           uint_least16_t;
+typedef uint32_t           uint_least32_t;
+typedef uint64_t           uint_least64_t;
+
+typedef int8_t             int_fast8_t;
+typedef int16_t            int_fast16_t;
+typedef int32_t            int_fast32_t;
+typedef int64_t            int_fast64_t;
+
+typedef uint8_t            uint_fast8_t;
+typedef uint16_t           uint_fast16_t;
+typedef uint32_t           uint_fast32_t;
+typedef uint64_t           uint_fast64_t;
+
+typedef long               intptr_t;
+typedef unsigned long      uintptr_t;
+typedef int64_t            intmax_t;
+typedef uint64_t           uintmax_t;
+	`
+)
+
+// ExtractAggregates parses the passed C source code contained in `cont` to
+// produce a mapping of the aggregates in use within a context object instance.
+// On error, it returns a description of where the process failed.
+// You can specify a name together with the source code passed as input.
+// The layouts of the aggregates are resolved for the passed target, which is
+// also used to evaluate constant expressions, e.g. `sizeof(long)`.
+//
+// Note that by default, this works without a compiler installation, but an
+// include path will be needed if any `#include` directives are used. In that
+// case, the `useCompiler` flag can be used.
+func ExtractAggregates(fname, cont string, useCompiler bool, target abi.Target) (Context, error) {
+	config, sources, err := getConfigs(useCompiler, target)
+	if err != nil {
+		return Context{}, fmt.Errorf("%w:\n%s Original error: \n\t%w", ErrConfig,
+			compErrMsg, err)
+	}
+
+	// C23 enums with a fixed underlying type are not supported by the parser
+	cont, bases := stripEnumBases(fname, cont)
+	sources = append(sources, cc.Source{Name: fname, Value: cont})
+
+	var packs packTracker
+	config.PragmaHandler = packs.handlePragma
+
+	ast, err := cc.Translate(config, sources)
+	if err != nil {
+		msg := parseErrMsg
+		if strings.Contains(err.Error(), "include") {
+			msg = includeErrMsg
+		}
+		return Context{}, fmt.Errorf("%w:%s\nOriginal error: \n\t%w", ErrParse, msg, err)
+	}
+
+	var (
+		ctx  = NewContext(target)
+		text = newSourceSet(sources)
+	)
+	ctx.enumBases = bases
+	ctx.text = text
+
+	// let us iterate over all declaration in the translation unit
+	for l := ast.TranslationUnit; l != nil; l = l.TranslationUnit {
+		extDecl := l.ExternalDeclaration
+
+		// we are only interested in ExternalDeclarationDecl...
+		if extDecl.Case != cc.ExternalDeclarationDecl {
+			continue
+		}
+
+		decl := extDecl.Declaration
+		if IsTypedef(decl) {
+			typedefs, err := ctx.ParseTypedef(decl)
+			if err != nil {
+				return Context{}, fmt.Errorf("%w: %v: %w", ErrParse, decl.Position(), err)
+			}
+
+			for name, typedef := range typedefs {
+				ctx.typedefs[name] = typedef
+			}
+		}
+
+		// ...and specifically to Structs, Unions and Enums definitions
+		if !IsAggregateDefinition(decl) {
+			continue
+		}
+
+		switch decl.DeclarationSpecifiers.Type().(type) {
+		case *cc.StructType, *cc.UnionType, *cc.EnumType:
+			aggregate, err := ctx.ParseAggregate(decl)
+			if err != nil {
+				return Context{}, fmt.Errorf("%w: %v: %w", ErrParse, decl.Position(), err)
+			}
+
+			// '#pragma pack' regions and the attributes found between the
+			// 'struct' keyword and the tag are not part of the AST
+			aggregate.Pack = packs.packAt(decl.Position())
+			recoverTagAttributes(aggregate, decl, text, ast.Macros)
+
+			names := GetAggregateNames(aggregate)
+			for _, name := range names {
+				ctx.aggregates[name] = aggregate
+				delete(ctx.typedefs, name)
+			}
+			ctx.order = append(ctx.order, aggregate)
+
+			// aggregates defined inline share the packing of the enclosing
+			// one, and the named ones can be referred to elsewhere
+			for _, inline := range InlineAggregates(aggregate) {
+				inline.Pack = aggregate.Pack
+				if inline.Name != "" {
+					ctx.aggregates[inline.Name] = inline
+					ctx.order = append(ctx.order, inline)
+				}
+			}
+		}
+	}
+	return ctx, nil
+}
+
+// Aggregates returns every aggregate that can be referred to by name within
+// the context, in the order they are defined. Each aggregate is returned only
+// once, even if it can be referred to by multiple names, while the ones that
+// are shadowed by a later definition with the same name are skipped.
+func (ctx Context) Aggregates() []*Aggregate {
+	var (
+		aggregates []*Aggregate
+		seen       = make(map[*Aggregate]struct{})
+	)
+
+	for _, aggregate := range ctx.order {
+		names := GetAggregateNames(aggregate)
+		if len(names) == 0 || ctx.aggregates[names[0]] != aggregate {
+			continue
+		}
+
+		if _, isSeen := seen[aggregate]; !isSeen {
+			seen[aggregate] = struct{}{}
+			aggregates = append(aggregates, aggregate)
+		}
+	}
+	return aggregates
+}
+
+// Replace returns a copy of the context where every name referring to agg
+// refers to the replacement instead, e.g. a copy of it with its fields
+// re-ordered, so that the layout of the aggregates using it can be computed
+// accordingly. The passed context is left untouched.
+func (ctx Context) Replace(agg, replacement *Aggregate) Context {
+	ctx.aggregates = maps.Clone(ctx.aggregates)
+	for name, curr := range ctx.aggregates {
+		if curr == agg {
+			ctx.aggregates[name] = replacement
+		}
+	}
+
+	ctx.order = slices.Clone(ctx.order)
+	for idx, curr := range ctx.order {
+		if curr == agg {
+			ctx.order[idx] = replacement
+		}
+	}
+	return ctx
+}
+
+// Lookup retrieves the aggregate identified by name, following any chain of
+// typedefs that resolve to it, e.g. `typedef struct foo foo_t;`.
+func (ctx Context) Lookup(name string) (*Aggregate, bool) {
+	for range len(ctx.typedefs) + 1 {
+		if agg, ok := ctx.aggregates[name]; ok {
+			return agg, true
+		}
+
+		typedef, isTypedef := ctx.typedefs[name].(Basic)
+		if !isTypedef {
+			break
+		}
+		name = typedef.UnqualifiedType()
+	}
+	return nil, false
+}
+
+// ResolveMeta attempts to get the alignment/size metadata for the aggregate
+// identified by name, within an initialized context. This will also
+// recursively compute said metadata for any inner aggregate.
+func (ctx Context) ResolveMeta(name string) (AggregateMeta, error) {
+	// retrieve the aggregate
+	agg, ok := ctx.Lookup(name)
+	if !ok {
+		return AggregateMeta{}, fmt.Errorf("%w: %v", ErrSymbol, name)
+	}
+	return ctx.Resolve(name, agg)
+}
+
+// Resolve computes the alignment/size metadata for the passed aggregate,
+// which is referred to by name in error messages. The aggregate does not
+// need to be part of the context, e.g. it may be a copy of one of them with
+// its fields re-ordered, but the types it refers to must be.
+func (ctx Context) Resolve(name string, agg *Aggregate) (AggregateMeta, error) {
+	// simplified case: enum
+	if agg.Kind == EnumKind {
+		meta, err := ctx.resolveEnum(agg)
+		if err != nil {
+			return AggregateMeta{}, fmt.Errorf("name %s: %w", name, err)
+		}
+		return meta, nil
+	}
+
+	// perform the first pass of the algorithm
+	resMetas, maxAlign, err := ctx.Members(agg)
+	if err != nil {
+		return AggregateMeta{}, fmt.Errorf("name %s: %w", name, err)
+	}
+
+	// simplified case: union
+	if agg.Kind == UnionKind {
+		// find the biggest element in size
+		return resolveUnion(agg, resMetas, maxAlign), nil
+	}
+
+	// second pass: place every field, keeping track of the current offset in
+	// bits, so that bit-fields can share storage units with their neighbours
+	var (
+		bitPos      = 0
+		starts      = make([]int, len(agg.Fields))
+		widths      = make([]int, len(agg.Fields))
+		layouts     = make([]Layout, 0, len(agg.Fields))
+		diagnostics []error
+	)
+
+	for idx, field := range agg.Fields {
+		curr := resMetas[idx]
+
+		if _, isFlex := field.(FlexibleArray); isFlex && idx != len(agg.Fields)-1 {
+			diagnostics = append(diagnostics, fmt.Errorf("name %s: %s: %w", name,
+				field.Declaration(), ErrFlex))
+		}
+
+		starts[idx], widths[idx], err = agg.Place(bitPos, field, curr)
+		if err != nil {
+			return AggregateMeta{}, fmt.Errorf("name %s: %s: %w", name,
+				field.Declaration(), err)
+		}
+
+		bitPos = starts[idx] + widths[idx]
+	}
+
+	// the total size must be a multiple of the aggregate alignment, so that
+	// if another aggregate of the same type would be lied next to this one,
+	// it would be aligned too.
+	totBits := alignUp(bitPos, maxAlign*8)
+
+	for idx, field := range agg.Fields {
+		curr := resMetas[idx]
+
+		// the padding of a field is the gap between its end and the start of
+		// the next field, or the end of the aggregate for the last one
+		next := totBits
+		if idx != len(agg.Fields)-1 {
+			next = starts[idx+1]
+		}
+		padding := next - (starts[idx] + widths[idx])
+
+		layout := Layout{
+			Field:      field,
+			offset:     starts[idx] / 8,
+			size:       widths[idx] / 8,
+			alignment:  curr.Alignment,
+			padding:    padding / 8,
+			bitSize:    widths[idx] % 8,
+			bitPadding: padding % 8,
+		}
+
+		if _, isBitField := field.(BitField); isBitField {
+			layout.bitOffset = starts[idx]
+		}
+
+		// this is an aggregate field, let's add some metadata to the Layout
+		if curr.Layout != nil {
+			layout.subAggregate = curr.Layout
+		}
+		layouts = append(layouts, layout)
+	}
+
+	return AggregateMeta{
+		Size:        totBits / 8,
+		Alignment:   maxAlign,
+		Layout:      layouts,
+		Diagnostics: diagnostics,
+	}, nil
+}
+
+// Members implements the first pass in the metadata resolution algorithm: it
+// computes the metadata of each field of the passed aggregate, in order, and
+// the max alignment for the aggregate, recursively calling the resolution
+// algorithm once more if it encounters another aggregate. The alignment of
+// each field already takes packing into account.
+func (ctx Context) Members(agg *Aggregate) ([]AggregateMeta, int, error) {
+	maxAlign := 0
+	resMetas := make([]AggregateMeta, 0, len(agg.Fields))
+
+	// First pass: evaluate the max alignment in the struct
+	for _, field := range agg.Fields {
+		meta, err := ctx.fieldMeta(field)
+		if err != nil {
+			return nil, -1, err
+		}
+
+		// zero-width bit-fields move to the next unit of their type, as
+		// naturally aligned, regardless of any packing
+		bitField, isBitField := field.(BitField)
+		if !isBitField || bitField.Width != 0 {
+			meta.Alignment = agg.fieldAlignment(field, meta.Alignment)
+		}
+		resMetas = append(resMetas, meta)
+
+		// unnamed bit-fields do not affect the alignment of the aggregate
+		if isBitField && bitField.Name == "" {
+			continue
+		}
+
+		if meta.Alignment > maxAlign {
+			maxAlign = meta.Alignment
+		}
+	}
+
+	// the aligned attribute can only increase the alignment of the aggregate,
+	// and an aggregate made only of unnamed bit-fields is still byte aligned
+	maxAlign = max(maxAlign, agg.Alignment, 1)
+	return resMetas, maxAlign, nil
+}
+
+// Offset returns the offset of the field in bytes, from the start of the
+// aggregate it belongs to, rounded down for bit-fields.
+func (l Layout) Offset() int {
+	return l.offset
+}
+
+// BitOffset returns the offset of the field in bits, from the start of the
+// aggregate it belongs to.
+func (l Layout) BitOffset() int {
+	if _, isBitField := l.Field.(BitField); isBitField {
+		return l.bitOffset
+	}
+	return l.offset * 8
+}
+
+// Size returns the size of the field in bytes, rounded down for bit-fields.
+func (l Layout) Size() int {
+	return l.size
+}
+
+// BitSize returns the size of the field in bits.
+func (l Layout) BitSize() int {
+	return l.size*8 + l.bitSize
+}
+
+// Alignment returns the alignment of the field within the aggregate, in
+// bytes, which already takes packing into account.
+func (l Layout) Alignment() int {
+	return l.alignment
+}
+
+// Padding returns the padding following the field in bytes, rounded down.
+func (l Layout) Padding() int {
+	return l.padding
+}
+
+// BitPadding returns the padding following the field in bits.
+func (l Layout) BitPadding() int {
+	return l.padding*8 + l.bitPadding
+}
+
+// Fields returns the layout of the fields of the aggregate the field is an
+// instance of, or nil if the field has no aggregate type. The offsets of
+// the fields are relative to the start of the field.
+func (l Layout) Fields() []Layout {
+	return l.subAggregate
+}
+
+// Padding returns the total amount of padding within the aggregate, in bits.
+func (meta AggregateMeta) Padding() int {
+	padding := 0
+	for _, fLayout := range meta.Layout {
+		padding += fLayout.BitPadding()
+	}
+	return padding
+}
+
+// fieldMeta computes the natural size and alignment of the passed field,
+// recursively resolving any aggregate or typedef'd type it refers to.
+func (ctx Context) fieldMeta(field Field) (AggregateMeta, error) {
+	switch field := field.(type) {
+	case Basic, Array, BitField:
+		return ctx.handleValueType(field)
+	case FlexibleArray:
+		// flexible arrays only contribute with their alignment
+		meta, err := ctx.handleValueType(field.Array)
+		meta.Size = 0
+		return meta, err
+	case FuncPointer, Pointer:
+		return AggregateMeta{
+			Size:      ctx.target.Pointer.Size,
+			Alignment: ctx.target.Pointer.Alignment,
+		}, nil
+	case EnumEntry:
+		return AggregateMeta{
+			Size:      ctx.target.Enum.Size,
+			Alignment: ctx.target.Enum.Alignment,
+		}, nil
+	}
+	return AggregateMeta{}, nil
+}
+
+// fieldAlignment computes the actual alignment of a field within the
+// aggregate, given its natural alignment: packed fields and aggregates have
+// no alignment requirements, an explicit alignment can only make it stricter,
+// while '#pragma pack' caps the alignment of each field to the packing value.
+func (agg *Aggregate) fieldAlignment(field Field, natural int) int {
+	var (
+		alignment      = natural
+		basic, isBasic = AsBasic(field)
+	)
+
+	if agg.Packed || (isBasic && basic.Packed) {
+		alignment = 1
+	}
+
+	if isBasic && basic.Alignment > alignment {
+		alignment = basic.Alignment
+	}
+
+	if agg.Pack != 0 && alignment > agg.Pack {
+		alignment = agg.Pack
+	}
+	return alignment
+}
+
+// AsBasic returns the Basic part of the passed field, if it has one.
+func AsBasic(field Field) (Basic, bool) {
+	switch field := field.(type) {
+	case Basic:
+		return field, true
+	case Pointer:
+		return field.Basic, true
+	case Array:
+		return field.Basic, true
+	case BitField:
+		return field.Basic, true
+	case FlexibleArray:
+		return field.Basic, true
+	}
+	return Basic{}, false
+}
+
+// FieldName returns the name of the passed field, which is empty for
+// anonymous members and unnamed bit-fields.
+func FieldName(field Field) string {
+	switch field := field.(type) {
+	case FuncPointer:
+		return field.Name
+	case EnumEntry:
+		return field.Name
+	}
+
+	basic, _ := AsBasic(field)
+	return basic.Name
+}
+
+// handleValueType implements the value type (and array) handling strategy
+// for the metadata resolution algorithm. If the type is a primitive one, it
+// checks for its metadata within a lookup table, otherwise it attempts to
+// compute the aggregate metadata.
+func (ctx Context) handleValueType(field Field) (AggregateMeta, error) {
+	arrType, isArray := field.(Array)
+	fType := field.UnqualifiedType()
+
+	// arrays of pointers and function pointers
+	if isArray && arrType.Element != ValueKind {
+		return AggregateMeta{
+			Size:      ctx.target.Pointer.Size * arrType.Elements(),
+			Alignment: ctx.target.Pointer.Alignment,
+		}, nil
+	}
+
+	fMeta, isBase := ctx.types[fType]
+	if isBase {
+		size := fMeta.Size
+		if isArray {
+			size *= arrType.Elements()
+		}
+
+		return AggregateMeta{
+			Size:      size,
+			Alignment: fMeta.Alignment,
+		}, nil
+	}
+
+	// Aggregate case, either defined inline or elsewhere
+	var (
+		subMeta AggregateMeta
+		err     error
+	)
+
+	basic, _ := AsBasic(field)
+	typedef, isTypedef := ctx.typedefs[fType]
+
+	switch {
+	case basic.Inline != nil:
+		subMeta, err = ctx.Resolve(fType, basic.Inline)
+	case isTypedef:
+		subMeta, err = ctx.fieldMeta(typedef)
+
+		// the aligned attribute of a typedef sets the alignment of the type,
+		// which may even be decreased
+		if tdBasic, _ := AsBasic(typedef); tdBasic.Alignment != 0 {
+			subMeta.Alignment = tdBasic.Alignment
+		}
+	default:
+		subMeta, err = ctx.resolveAggregate(fType)
+	}
+
+	if err != nil {
+		return AggregateMeta{}, err
+	}
+
+	if isArray {
+		subMeta.Size *= arrType.Elements()
+	}
+
+	return subMeta, nil
+}
+
+// resolveAggregate tries to resolve the sub-aggregate passed by its type.
+func (ctx Context) resolveAggregate(aggType string) (AggregateMeta, error) {
+	// Let us check if this type is defined first
+	fAgg, isAggregate := ctx.Lookup(aggType)
+	if !isAggregate {
+		return AggregateMeta{}, fmt.Errorf("%w: inner '%s'", ErrSymbol, aggType)
+	}
+
+	// If so, let us recursively resolve its alignment/size/padding
+	subMeta, err := ctx.Resolve(aggType, fAgg)
+	if err != nil {
+		return AggregateMeta{}, err
+	}
+	return subMeta, nil
+}
+
+// resolveEnum computes the size and alignment of an enum, which are the ones
+// of its underlying type. That is the fixed underlying type of C23 enums, if
+// any, or else the smallest type able to represent all of its values: with
+// short enums, this can be as small as a char, otherwise it is an int, unless
+// some value does not fit one. Each enumerator is part of the layout, with
+// the size of the enum.
+func (ctx Context) resolveEnum(agg *Aggregate) (AggregateMeta, error) {
+	var (
+		minValue, maxValue int64
+		underlying         = agg.Underlying
+		meta               AggregateMeta
+	)
+
+	for idx, field := range agg.Fields {
+		entry := field.(EnumEntry)
+		if idx == 0 || entry.Value < minValue {
+			minValue = entry.Value
+		}
+
+		if idx == 0 || entry.Value > maxValue {
+			maxValue = entry.Value
+		}
+	}
+
+	if underlying != "" {
+		var err error
+		if meta, err = ctx.fieldMeta(basicType(underlying)); err != nil {
+			return AggregateMeta{}, err
+		}
+	} else {
+		// packed enums are sized by their values, as with short enums
+		shortEnums := ctx.target.ShortEnums || agg.Packed
+		underlying, meta = ctx.enumType(minValue, maxValue, shortEnums)
+	}
+
+	meta.Underlying = underlying
+	for _, field := range agg.Fields {
+		meta.Layout = append(meta.Layout, Layout{
+			Field:     field,
+			size:      meta.Size,
+			alignment: meta.Alignment,
+		})
+	}
+	return meta, nil
+}
+
+// enumType returns the smallest integer type able to represent all the
+// values within the passed range, among the ones an enum can use, alongside
+// with its metadata. Ranges without negative values use unsigned types.
+// Short enums may also use the integer types smaller than an int.
+func (ctx Context) enumType(minValue, maxValue int64, shortEnums bool) (string, AggregateMeta) {
+	candidates := []string{"int", "long", "long long"}
+	if shortEnums {
+		candidates = []string{"char", "short", "int", "long", "long long"}
+	}
+
+	// the enum size only applies to the int sized enums
+	typeMeta := func(typeName string) abi.TypeMeta {
+		if typeName == "int" && !shortEnums {
+			return ctx.target.Enum
+		}
+		return ctx.types[typeName]
+	}
+
+	typeName := candidates[len(candidates)-1]
+	for _, candidate := range candidates {
+		if fitsRange(minValue, maxValue, typeMeta(candidate).Size) {
+			typeName = candidate
+			break
+		}
+	}
+
+	meta := typeMeta(typeName)
+	return signedName(typeName, minValue < 0), AggregateMeta{
+		Size:      meta.Size,
+		Alignment: meta.Alignment,
+	}
+}
+
+// fitsRange checks whether all the values within the passed range can be
+// represented by an integer type of the passed size, which is signed only if
+// the range holds negative values.
+func fitsRange(minValue, maxValue int64, size int) bool {
+	bits := size * 8
+	switch {
+	case bits >= 64:
+		return true
+	case minValue < 0:
+		return minValue >= -(1<<(bits-1)) && maxValue < 1<<(bits-1)
+	}
+	return maxValue < 1<<bits
+}
+
+// signedName returns the name of the signed or unsigned variant of the
+// passed integer type.
+func signedName(typeName string, signed bool) string {
+	switch {
+	case !signed:
+		return "unsigned " + typeName
+	case typeName == "char":
+		return "signed char"
+	}
+	return typeName
+}
+
+// basicType builds a Basic field out of a type name, e.g. `unsigned char`,
+// the last word of which is the type, the others being qualifiers.
+func basicType(typeName string) Basic {
+	words := strings.Fields(typeName)
+	return Basic{Qualifiers: words[:len(words)-1], TypeName: words[len(words)-1]}
+}
+
+// resolveUnion is a builder for AggregateMeta when encountering an enum.
+func resolveUnion(agg *Aggregate, meta []AggregateMeta, max int) AggregateMeta {
+	var (
+		layouts []Layout
+		maxSize = -1
+	)
+
+	for idx, curr := range meta {
+		size, bitSize := curr.Size, 0
+
+		// bit-fields only take the bytes needed to hold their width
+		if bitField, isBitField := agg.Fields[idx].(BitField); isBitField {
+			size, bitSize = bitField.Width/8, bitField.Width%8
+			curr.Size = alignUp(bitField.Width, 8) / 8
+		}
+
+		if curr.Size > maxSize {
+			maxSize = curr.Size
+		}
+
+		layouts = append(layouts, Layout{
+			Field:     agg.Fields[idx],
+			size:      size,
+			alignment: curr.Alignment,
+			padding:   0,
+			bitSize:   bitSize,
+		})
+
+		// this is an aggregate field, let's add some metadata to the Layout
+		if curr.Layout != nil {
+			layouts[idx].subAggregate = curr.Layout
+		}
+	}
+
+	// empty unions, a GNU extension, take no space at all
+	if len(layouts) == 0 {
+		return AggregateMeta{Size: 0, Alignment: max}
+	}
+
+	// if the biggest element in size is not the one with bigger alignment
+	// then we must account for some padding -- it's the same case as for
+	// padding the last element of a struct
+	padding := (max - (maxSize % max)) % max
+	layouts[len(layouts)-1].padding = padding
+
+	return AggregateMeta{
+		Size:      maxSize + padding,
+		Alignment: max,
+		Layout:    layouts,
+	}
+}
+
+// Place computes where the passed field of the struct starts, in bits, when
+// placed right after the passed bit position, and the amount of bits it
+// takes, given its metadata as computed by Members. Placing a field only
+// depends on the current position, so that the fields of an aggregate can
+// be placed in any order.
+func (agg *Aggregate) Place(pos int, field Field, meta AggregateMeta) (int, int, error) {
+	bitField, isBitField := field.(BitField)
+	if !isBitField {
+		return alignUp(pos, meta.Alignment*8), meta.Size * 8, nil
+	}
+
+	start, err := placeBitField(pos, bitField.Width, meta,
+		agg.IsPacked() || bitField.Packed)
+	return start, bitField.Width, err
+}
+
+// placeBitField computes the starting bit for a bit-field of the passed width,
+// given the current bit position and the metadata of its declared type. A
+// bit-field may not straddle the boundary of a storage unit of its type, in
+// which case it is moved to the next one, while a zero width bit-field just
+// aligns the current position to the next unit boundary. Packed bit-fields
+// are always placed at the current position.
+func placeBitField(pos, width int, unit AggregateMeta, packed bool) (int, error) {
+	var (
+		unitBits  = unit.Size * 8
+		alignBits = unit.Alignment * 8
+	)
+
+	if width < 0 || width > unitBits {
+		return -1, fmt.Errorf("%w: %d", ErrWidth, width)
+	}
+
+	if width == 0 {
+		return alignUp(pos, alignBits), nil
+	}
+
+	if packed {
+		return pos, nil
+	}
+
+	unitStart := pos - pos%alignBits
+	if pos+width > unitStart+unitBits {
+		return alignUp(pos, alignBits), nil
+	}
+	return pos, nil
+}
+
+// alignUp rounds the passed value up to the next multiple of alignment.
+func alignUp(value, alignment int) int {
+	if alignment <= 1 {
+		return value
+	}
+	return (value + alignment - 1) / alignment * alignment
+}
+
+// recoverTagAttributes looks for the attributes placed between the 'struct'
+// keyword and the tag of a named aggregate, which the parser drops, and
+// merges them into the passed aggregate.
+func recoverTagAttributes(agg *Aggregate, decl *cc.Declaration, text sourceSet,
+	macros map[string]*cc.Macro) {
+	typeSpec := getTypeSpecifier(decl)
+	if typeSpec == nil || typeSpec.StructOrUnionSpecifier == nil {
+		return
+	}
+
+	spec := typeSpec.StructOrUnionSpecifier
+	if spec.Token.SrcStr() == "" {
+		return
+	}
+
+	attrs := parseAttributesText(text.between(spec.StructOrUnion.Token, spec.Token), macros)
+	agg.Packed = agg.Packed || attrs.packed
+	agg.Alignment = max(agg.Alignment, attrs.alignment)
+}
+
+// getConfigs initialize the various configurations structs/slices depending
+// on if the user wants to use a local compiler include path or not. The ABI
+// of the parser follows the passed target, as do the predefined macros, such
+// as `__SIZEOF_POINTER__`, unless the compiler is used, which predefines the
+// ones of its own target.
+func getConfigs(useCompiler bool, target abi.Target) (*cc.Config, []cc.Source, error) {
+	goos, goarch := parserABI(target)
+	if !useCompiler {
+		ccABI, err := cc.NewABI(goos, goarch)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &cc.Config{ABI: ccABI}, []cc.Source{
+			{Name: "<predefined>", Value: predefinedMacros(target)},
+			{Name: "stdalign.h", Value: stdalign},
+			{Name: "stdint.h", Value: stdint},
+		}, nil
+	}
+
+	config, err := cc.NewConfig(goos, goarch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return config, []cc.Source{
+		{Name: "<predefined>", Value: config.Predefined},
+		{Name: "<builtin>", Value: cc.Builtin},
+		{Name: "<stdalign>", Value: stdalign},
+	}, nil
+}
+
+// parserABI returns the GOOS and GOARCH of the parser ABI closest to the
+// passed target. The parser only supports a subset of the targets, and uses
+// its ABI for type checking alone, so other targets are mapped to one with
+// the same pointer size.
+func parserABI(target abi.Target) (string, string) {
+	goos := "linux"
+	if target.System() == "windows" {
+		goos = "windows"
+	}
+
+	switch target.Arch() {
+	case "x86_64":
+		return goos, "amd64"
+	case "i386":
+		return goos, "386"
+	case "aarch64":
+		return goos, "arm64"
+	case "arm":
+		return "linux", "arm"
+	case "riscv64":
+		return "linux", "riscv64"
+	}
+
+	if target.Pointer.Size == 8 {
+		return goos, "amd64"
+	}
+	return goos, "386"
+}
+
+// predefinedMacros returns the source predefining the macros that describe
+// the passed target, as a compiler for it would: the size of the basic types,
+// e.g. `__SIZEOF_LONG__`, the data model, e.g. `__LP64__`, and the
+// architecture and operating system, e.g. `__x86_64__` and `__linux__`.
+func predefinedMacros(target abi.Target) string {
+	var (
+		builder strings.Builder
+		define  = func(name string, value int) {
+			fmt.Fprintf(&builder, "#define %s %d\n", name, value)
+		}
+	)
+
+	define("__CHAR_BIT__", 8)
+	define("__SIZEOF_POINTER__", target.Pointer.Size)
+	define("__SIZEOF_SIZE_T__", target.Pointer.Size)
+	define("__SIZEOF_SHORT__", target.Short.Size)
+	define("__SIZEOF_INT__", target.Int.Size)
+	define("__SIZEOF_LONG__", target.Long.Size)
+	define("__SIZEOF_LONG_LONG__", target.LongLong.Size)
+	define("__SIZEOF_FLOAT__", target.Float.Size)
+	define("__SIZEOF_DOUBLE__", target.Double.Size)
+	define("__SIZEOF_LONG_DOUBLE__", target.LongDouble.Size)
+
+	switch {
+	case target.Long.Size == 8 && target.Pointer.Size == 8:
+		define("__LP64__", 1)
+		define("_LP64", 1)
+	case target.Int.Size == 4 && target.Long.Size == 4 && target.Pointer.Size == 4:
+		define("__ILP32__", 1)
+		define("_ILP32", 1)
+	}
+
+	switch target.Arch() {
+	case "x86_64":
+		define("__x86_64__", 1)
+		define("__x86_64", 1)
+		define("__amd64__", 1)
+		define("__amd64", 1)
+	case "i386":
+		define("__i386__", 1)
+		define("__i386", 1)
+	case "aarch64":
+		define("__aarch64__", 1)
+	case "arm":
+		define("__arm__", 1)
+	case "riscv32", "riscv64":
+		define("__riscv", 1)
+		define("__riscv_xlen", target.Pointer.Size*8)
+	case "avr":
+		define("__AVR__", 1)
+	case "msp430":
+		define("__MSP430__", 1)
+	case "xtensa":
+		define("__XTENSA__", 1)
+		define("__xtensa__", 1)
+	}
+
+	switch target.System() {
+	case "windows":
+		define("_WIN32", 1)
+		if target.Pointer.Size == 8 {
+			define("_WIN64", 1)
+		}
+	case "linux":
+		define("__linux__", 1)
+		define("__unix__", 1)
+	}
+
+	// size_t is as wide as a pointer
+	sizeType := "long long"
+	switch target.Pointer.Size {
+	case target.Int.Size:
+		sizeType = "int"
+	case target.Long.Size:
+		sizeType = "long"
+	case target.Short.Size:
+		sizeType = "short"
+	}
+
+	fmt.Fprintf(&builder, predefined, sizeType)
+	return builder.String()
+}
@@ -0,0 +1,1060 @@
+package layout
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/Abathargh/stropt/abi"
+)
+
+func TestComputeMeta(t *testing.T) {
+	testCases := []struct {
+		test        string
+		name        string
+		expSize     int
+		expAlig     int
+		expLayout   []Layout
+		expectedErr error
+	}{
+		{
+			`#include <stdint.h> 
+			struct a1 { int32_t a; int8_t b; int16_t c; int32_t d; int64_t e; };`,
+			"struct a1",
+			24,
+			8,
+			[]Layout{
+				{size: 4, alignment: 4, padding: 0},
+				{size: 1, alignment: 1, padding: 1},
+				{size: 2, alignment: 2, padding: 0},
+				{size: 4, alignment: 4, padding: 4},
+				{size: 8, alignment: 8, padding: 0},
+			},
+			nil,
+		},
+		{
+			`#include <stdint.h> 
+			struct a2 { int32_t a; int64_t b; int8_t c; int32_t d; };`,
+			"struct a2",
+			24,
+			8,
+			[]Layout{
+				{size: 4, alignment: 4, padding: 4},
+				{size: 8, alignment: 8, padding: 0},
+				{size: 1, alignment: 1, padding: 3},
+				{size: 4, alignment: 4, padding: 0},
+			},
+			nil,
+		},
+		{
+			`#include <stdint.h> 
+			struct t1 { int16_t a; int8_t b; };
+			struct s1 { int32_t a; struct t1 t; int32_t d; };`,
+			"struct s1",
+			12,
+			4,
+			[]Layout{
+				{size: 4, alignment: 4, padding: 0},
+				{size: 4, alignment: 2, padding: 0, subAggregate: []Layout{
+					{size: 2, alignment: 2, padding: 0},
+					{size: 1, alignment: 1, padding: 1},
+				}},
+				{size: 4, alignment: 4, padding: 0},
+			},
+			nil,
+		},
+		{
+			"struct p1 { char * str; int a; };",
+			"struct p1",
+			16,
+			8,
+			[]Layout{
+				{size: 8, alignment: 8, padding: 0},
+				{size: 4, alignment: 4, padding: 4},
+			},
+			nil,
+		},
+		{
+			"struct p2 { char * str; int a; float f[100]; };",
+			"struct p2",
+			416,
+			8,
+			[]Layout{
+				{size: 8, alignment: 8, padding: 0},
+				{size: 4, alignment: 4, padding: 0},
+				{size: 400, alignment: 4, padding: 4},
+			},
+			nil,
+		},
+		{
+			`typedef struct T { short b; char c; } T;
+			struct p3 { char * str; int a; struct T f[100]; };`,
+			"struct p3",
+			416,
+			8,
+			[]Layout{
+				{size: 8, alignment: 8, padding: 0},
+				{size: 4, alignment: 4, padding: 0},
+				{size: 400, alignment: 2, padding: 4, subAggregate: []Layout{
+					{size: 2, alignment: 2, padding: 0},
+					{size: 1, alignment: 1, padding: 1},
+				}},
+			},
+			nil,
+		},
+		{
+			`typedef enum E { a, b, c } E;
+			struct p4 { enum E en; char * str; int a; };`,
+			"struct p4",
+			24,
+			8,
+			[]Layout{
+				{size: 4, alignment: 4, padding: 4},
+				{size: 8, alignment: 8, padding: 0},
+				{size: 4, alignment: 4, padding: 4},
+			},
+			nil,
+		},
+		{
+			"typedef struct { char * str; int a; } p1_t;",
+			"p1_t",
+			16,
+			8,
+			[]Layout{
+				{size: 8, alignment: 8, padding: 0},
+				{size: 4, alignment: 4, padding: 4},
+			},
+			nil,
+		},
+		{
+			"typedef struct p1_plain{ char * str; int a; } p1_t;",
+			"struct p1_plain",
+			16,
+			8,
+			[]Layout{
+				{size: 8, alignment: 8, padding: 0},
+				{size: 4, alignment: 4, padding: 4},
+			},
+			nil,
+		},
+		{
+			"typedef struct p1_plain{ char * str; int a; } p1_t;",
+			"p1_plain",
+			16,
+			8,
+			[]Layout{
+				{size: 8, alignment: 8, padding: 0},
+				{size: 4, alignment: 4, padding: 4},
+			},
+			nil,
+		},
+		{
+			"typedef struct p6 { char * str; int a; } p6_t;",
+			"struct p6",
+			16,
+			8,
+			[]Layout{
+				{size: 8, alignment: 8, padding: 0},
+				{size: 4, alignment: 4, padding: 4},
+			},
+			nil,
+		},
+		{
+			`
+			typedef struct int_cont {
+				volatile int a;
+				int * b;
+				const int * const c;
+				} int_cont_t;`,
+			"struct int_cont",
+			24,
+			8,
+			[]Layout{
+				{size: 4, alignment: 4, padding: 4},
+				{size: 8, alignment: 8, padding: 0},
+				{size: 8, alignment: 8, padding: 0},
+			},
+			nil,
+		},
+		{
+			"union u1 { int a; double b };",
+			"union u1",
+			8,
+			8,
+			[]Layout{
+				{size: 4, alignment: 4, padding: 0},
+				{size: 8, alignment: 8, padding: 0},
+			},
+			nil,
+		},
+		{
+			"union u2 { char arr[9]; double b; };",
+			"union u2",
+			16,
+			8,
+			[]Layout{
+				{size: 9, alignment: 1, padding: 0},
+				{size: 8, alignment: 8, padding: 7},
+			},
+			nil,
+		},
+		{
+			"struct uinner { char a; }; union u3 { struct uinner i; double b; };",
+			"union u3",
+			8,
+			8,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 0},
+				{size: 8, alignment: 8, padding: 0},
+			},
+			nil,
+		},
+		{
+			"union e {}; struct ue { char c; union e e; int i; };",
+			"struct ue",
+			8,
+			4,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 0},
+				{size: 0, alignment: 1, padding: 3},
+				{size: 4, alignment: 4, padding: 0},
+			},
+			nil,
+		},
+		{
+			"union e {};",
+			"union e",
+			0,
+			1,
+			nil,
+			nil,
+		},
+		{
+			"struct b1 { unsigned flags : 3; unsigned mode : 2; int value; };",
+			"struct b1",
+			8,
+			4,
+			[]Layout{
+				{size: 0, bitSize: 3, alignment: 4, padding: 0, bitOffset: 0},
+				{size: 0, bitSize: 2, alignment: 4, padding: 3, bitPadding: 3,
+					bitOffset: 3},
+				{size: 4, alignment: 4, padding: 0},
+			},
+			nil,
+		},
+		{
+			`struct b2 { unsigned char a : 4; unsigned char : 0; unsigned char b : 7;
+			unsigned short : 3; unsigned long long wide : 40; };`,
+			"struct b2",
+			8,
+			8,
+			[]Layout{
+				{size: 0, bitSize: 4, alignment: 1, padding: 0, bitPadding: 4},
+				{size: 0, bitSize: 0, alignment: 1, padding: 0, bitOffset: 8},
+				{size: 0, bitSize: 7, alignment: 1, padding: 0, bitPadding: 1,
+					bitOffset: 8},
+				{size: 0, bitSize: 3, alignment: 2, padding: 0, bitOffset: 16},
+				{size: 5, bitSize: 0, alignment: 8, padding: 0, bitPadding: 5,
+					bitOffset: 19},
+			},
+			nil,
+		},
+		{
+			"struct b3 { char c; int : 30; };",
+			"struct b3",
+			8,
+			1,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 3},
+				{size: 3, bitSize: 6, alignment: 4, padding: 0, bitPadding: 2,
+					bitOffset: 32},
+			},
+			nil,
+		},
+		{
+			"struct b4 { unsigned char a : 9; };",
+			"struct b4",
+			0,
+			0,
+			nil,
+			ErrWidth,
+		},
+		{
+			"struct __attribute__((packed)) k1 { char a; int b : 30; int c; };",
+			"struct k1",
+			9,
+			1,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 0},
+				{size: 3, bitSize: 6, alignment: 1, padding: 0, bitPadding: 2,
+					bitOffset: 8},
+				{size: 4, alignment: 1, padding: 0},
+			},
+			nil,
+		},
+		{
+			`#pragma pack(push, 2)
+			struct k2 { char a; int b : 30; int c; };
+			#pragma pack(pop)`,
+			"struct k2",
+			10,
+			2,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 0},
+				{size: 3, bitSize: 6, alignment: 2, padding: 1, bitPadding: 2,
+					bitOffset: 8},
+				{size: 4, alignment: 2, padding: 0},
+			},
+			nil,
+		},
+		{
+			"struct z1 { char a; unsigned : 0; char b; } __attribute__((packed));",
+			"struct z1",
+			5,
+			1,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 3},
+				{size: 0, alignment: 4, padding: 0, bitOffset: 32},
+				{size: 1, alignment: 1, padding: 0},
+			},
+			nil,
+		},
+		{
+			`#pragma pack(push, 2)
+			struct z2 { char a; long long : 0; char b; };
+			#pragma pack(pop)`,
+			"struct z2",
+			9,
+			1,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 7},
+				{size: 0, alignment: 8, padding: 0, bitOffset: 64},
+				{size: 1, alignment: 1, padding: 0},
+			},
+			nil,
+		},
+		{
+			`#pragma pack(push, 2)
+			struct k3 { char a; int b; };
+			#pragma pack(pop)
+			struct k4 { char a; int b; };`,
+			"struct k4",
+			8,
+			4,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 3},
+				{size: 4, alignment: 4, padding: 0},
+			},
+			nil,
+		},
+		{
+			"struct k5 { char a; int b __attribute__((packed)); short s; };",
+			"struct k5",
+			8,
+			2,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 0},
+				{size: 4, alignment: 1, padding: 1},
+				{size: 2, alignment: 2, padding: 0},
+			},
+			nil,
+		},
+		{
+			`#define __packed __attribute__((packed, aligned(4)))
+			struct __packed k6 { char a; int b; };
+			struct k7 { char a; struct k6 x; };`,
+			"struct k7",
+			12,
+			4,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 3},
+				{size: 8, alignment: 4, padding: 0, subAggregate: []Layout{
+					{size: 1, alignment: 1, padding: 0},
+					{size: 4, alignment: 1, padding: 3},
+				}},
+			},
+			nil,
+		},
+		{
+			"struct c1 { char c; alignas(64) int x; short s; };",
+			"struct c1",
+			128,
+			64,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 63},
+				{size: 4, alignment: 64, padding: 0},
+				{size: 2, alignment: 2, padding: 58},
+			},
+			nil,
+		},
+		{
+			`struct c2 { char c; int a[3] __attribute__((aligned(16)));
+			char * p __attribute__((aligned(32))); };`,
+			"struct c2",
+			64,
+			32,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 15},
+				{size: 12, alignment: 16, padding: 4},
+				{size: 8, alignment: 32, padding: 24},
+			},
+			nil,
+		},
+		{
+			"struct c3 { char c; int x __attribute__((packed, aligned(2))); };",
+			"struct c3",
+			6,
+			2,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 1},
+				{size: 4, alignment: 2, padding: 0},
+			},
+			nil,
+		},
+		{
+			`#pragma pack(1)
+			struct c4 { char a; int b __attribute__((aligned(8))); };`,
+			"struct c4",
+			5,
+			1,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 0},
+				{size: 4, alignment: 1, padding: 0},
+			},
+			nil,
+		},
+		{
+			"struct md1 { char a, *p; int b, c[3]; short s : 3, : 0, t : 5; };",
+			"struct md1",
+			40,
+			8,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 7},
+				{size: 8, alignment: 8, padding: 0},
+				{size: 4, alignment: 4, padding: 0},
+				{size: 12, alignment: 4, padding: 0},
+				{bitSize: 3, alignment: 2, padding: 1, bitPadding: 5, bitOffset: 256},
+				{size: 0, alignment: 2, padding: 0, bitOffset: 272},
+				{bitSize: 5, alignment: 2, padding: 5, bitPadding: 3, bitOffset: 272},
+			},
+			nil,
+		},
+		{
+			"struct fp1 { int (*cb)(const char *); void (*log)(const char *fmt, int level); char c; };",
+			"struct fp1",
+			24,
+			8,
+			[]Layout{
+				{size: 8, alignment: 8, padding: 0},
+				{size: 8, alignment: 8, padding: 0},
+				{size: 1, alignment: 1, padding: 7},
+			},
+			nil,
+		},
+		{
+			`struct ar1 { char c; int m[4][8]; char *names[3];
+			int (*handlers[4])(int, char); short s[2][3][5]; };`,
+			"struct ar1",
+			256,
+			8,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 3},
+				{size: 128, alignment: 4, padding: 4},
+				{size: 24, alignment: 8, padding: 0},
+				{size: 32, alignment: 8, padding: 0},
+				{size: 60, alignment: 2, padding: 4},
+			},
+			nil,
+		},
+		{
+			`typedef unsigned int my_u32; typedef const my_u32 reg_t;
+			typedef struct node *node_ptr; typedef char name_t[12];
+			typedef name_t names_t[2]; typedef int (*cb_t)(int);
+			typedef struct t0 t0_t; struct t0 { char a; reg_t r; }; struct t0;
+			struct t1 { char c; my_u32 a; node_ptr n; names_t nm; cb_t cb;
+			t0_t t; reg_t bits : 3; };`,
+			"struct t1",
+			64,
+			8,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 3},
+				{size: 4, alignment: 4, padding: 0},
+				{size: 8, alignment: 8, padding: 0},
+				{size: 24, alignment: 1, padding: 0},
+				{size: 8, alignment: 8, padding: 0},
+				{size: 8, alignment: 4, padding: 0},
+				{bitSize: 3, alignment: 4, padding: 7, bitPadding: 5, bitOffset: 448},
+			},
+			nil,
+		},
+		{
+			"typedef struct { char c; double d; } anon_t; typedef anon_t anon2_t;",
+			"anon2_t",
+			16,
+			8,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 7},
+				{size: 8, alignment: 8, padding: 0},
+			},
+			nil,
+		},
+		{
+			`typedef struct { int y; } *ptr_t; typedef struct { int x; } arr_t[2];
+			struct t2 { ptr_t p; arr_t a; char c; };`,
+			"struct t2",
+			24,
+			8,
+			[]Layout{
+				{size: 8, alignment: 8, padding: 0},
+				{size: 8, alignment: 4, padding: 0},
+				{size: 1, alignment: 1, padding: 7},
+			},
+			nil,
+		},
+		{
+			`typedef int (*cb_t)(const char *); typedef void (*log_t)(const char *fmt, int);
+			struct t3 { char c; cb_t cb; log_t log; };`,
+			"struct t3",
+			24,
+			8,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 7},
+				{size: 8, alignment: 8, padding: 0},
+				{size: 8, alignment: 8, padding: 0},
+			},
+			nil,
+		},
+		{
+			`struct i1 { char tag; union { int i; float f; };
+			struct inner { char a; double d; } pos; char end; };`,
+			"struct i1",
+			32,
+			8,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 3},
+				{size: 4, alignment: 4, padding: 0, subAggregate: []Layout{
+					{size: 4, alignment: 4, padding: 0},
+					{size: 4, alignment: 4, padding: 0},
+				}},
+				{size: 16, alignment: 8, padding: 0, subAggregate: []Layout{
+					{size: 1, alignment: 1, padding: 7},
+					{size: 8, alignment: 8, padding: 0},
+				}},
+				{size: 1, alignment: 1, padding: 7},
+			},
+			nil,
+		},
+		{
+			`struct i2 { struct inner { char a; double d; } pos; };
+			struct i3 { char c; struct inner in; };`,
+			"struct i3",
+			24,
+			8,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 7},
+				{size: 16, alignment: 8, padding: 0},
+			},
+			nil,
+		},
+		{
+			`#pragma pack(2)
+			struct i4 { char c; struct { char a; int b; } in; };`,
+			"struct i4",
+			8,
+			2,
+			[]Layout{
+				{size: 1, alignment: 1, padding: 1},
+				{size: 6, alignment: 2, padding: 0, subAggregate: []Layout{
+					{size: 1, alignment: 1, padding: 1},
+					{size: 4, alignment: 2, padding: 0},
+				}},
+			},
+			nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		structs, err := ExtractAggregates("", testCase.test, true, abi.DefaultTarget())
+		if err != nil {
+			t.Errorf("Unexpected error when parsing %s: %s", testCase.test, err)
+			continue
+		}
+
+		meta, err := structs.ResolveMeta(testCase.name)
+		if err != nil {
+			if !errors.Is(err, testCase.expectedErr) {
+				t.Errorf("Expected error %v: got %v", testCase.expectedErr, err)
+				t.Errorf("%v", structs)
+			}
+			continue
+		}
+
+		if meta.Size != testCase.expSize {
+			t.Errorf("Expected size: %d: got: %d for '%s'", testCase.expSize,
+				meta.Size, testCase.test)
+		}
+
+		if meta.Alignment != testCase.expAlig {
+			t.Errorf("Expected alignment: %d: got: %d for '%s'", testCase.expAlig,
+				meta.Alignment, testCase.test)
+		}
+
+		for idx, layout := range testCase.expLayout {
+			actualLayout := meta.Layout[idx]
+
+			if layout.size != actualLayout.size {
+				t.Errorf("Expected size for field %s: %d: got: %d for '%s'",
+					actualLayout.Declaration(), layout.size, actualLayout.size,
+					testCase.test)
+			}
+
+			if layout.alignment != actualLayout.alignment {
+				t.Errorf("Expected alignment for field %s: %d: got: %d for '%s'",
+					actualLayout.Declaration(), layout.alignment, actualLayout.alignment,
+					testCase.test)
+			}
+
+			if layout.padding != actualLayout.padding {
+				t.Errorf("Expected padding for field %s: %d: got: %d for '%s'",
+					actualLayout.Declaration(), layout.padding, actualLayout.padding,
+					testCase.test)
+			}
+
+			if layout.bitSize != actualLayout.bitSize ||
+				layout.bitPadding != actualLayout.bitPadding ||
+				layout.bitOffset != actualLayout.bitOffset {
+				t.Errorf("Expected bits for field %s: %d/%d@%d: got: %d/%d@%d for '%s'",
+					actualLayout.Declaration(), layout.bitSize, layout.bitPadding,
+					layout.bitOffset, actualLayout.bitSize, actualLayout.bitPadding,
+					actualLayout.bitOffset, testCase.test)
+			}
+
+			if layout.subAggregate != nil {
+				for jdx, subLayout := range layout.subAggregate {
+					actualSubL := actualLayout.subAggregate[jdx]
+
+					if subLayout.size != actualSubL.size {
+						t.Errorf("Expected size for field %s: %d: got: %d for '%s'",
+							actualSubL.Declaration(), subLayout.size, actualSubL.size,
+							testCase.test)
+					}
+
+					if subLayout.alignment != actualSubL.alignment {
+						t.Errorf("Expected alignment for field %s: %d: got: %d for '%s'",
+							actualSubL.Declaration(), subLayout.alignment,
+							actualSubL.alignment, testCase.test,
+						)
+					}
+
+					if subLayout.padding != actualSubL.padding {
+						t.Errorf("Expected padding for field %s: %d: got: %d for '%s'",
+							actualSubL.Declaration(), subLayout.padding, actualSubL.padding,
+							testCase.test,
+						)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestFlexibleArray(t *testing.T) {
+	testCases := []struct {
+		test        string
+		name        string
+		expSize     int
+		expAlig     int
+		diagnostics int
+	}{
+		{"struct f1 { int n; char c; double d[]; };", "struct f1", 8, 8, 0},
+		{"struct f2 { char c; int m[][3]; };", "struct f2", 4, 4, 0},
+		{"struct f3 { char c; double *p[0]; };", "struct f3", 8, 8, 0},
+		{"struct f4 { char c; int z[0]; double d; };", "struct f4", 16, 8, 1},
+		{"struct f5 { char c; double d; short s[]; };", "struct f5", 16, 8, 0},
+	}
+
+	for _, testCase := range testCases {
+		structs, err := ExtractAggregates("", testCase.test, false, abi.DefaultTarget())
+		if err != nil {
+			t.Errorf("Unexpected error when parsing %s: %s", testCase.test, err)
+			continue
+		}
+
+		meta, err := structs.ResolveMeta(testCase.name)
+		if err != nil {
+			t.Errorf("Unexpected error when resolving %s: %s", testCase.test, err)
+			continue
+		}
+
+		if meta.Size != testCase.expSize || meta.Alignment != testCase.expAlig {
+			t.Errorf("Expected size/alignment: %d/%d: got: %d/%d for '%s'",
+				testCase.expSize, testCase.expAlig, meta.Size, meta.Alignment,
+				testCase.test)
+		}
+
+		if len(meta.Diagnostics) != testCase.diagnostics {
+			t.Errorf("Expected %d diagnostics: got: %v for '%s'",
+				testCase.diagnostics, meta.Diagnostics, testCase.test)
+		}
+
+		for _, diagnostic := range meta.Diagnostics {
+			if !errors.Is(diagnostic, ErrFlex) {
+				t.Errorf("Expected error %v: got %v", ErrFlex, diagnostic)
+			}
+		}
+	}
+}
+
+func TestConstantExpressions(t *testing.T) {
+	const defs = `enum { N = 4, M, BIG = 1 << 4 }; enum { F_A = 0x10u, F_C = 'a' - 'A' };
+	struct in { char c; double d; };`
+
+	testCases := []struct {
+		test    string
+		name    string
+		expSize int
+	}{
+		{"struct c1 { char a[N]; char b[M * 2]; };", "struct c1", 14},
+		{"struct c2 { char a[BIG >> 2]; char b[F_A]; char c[010]; };", "struct c2", 28},
+		{"struct c3 { char a[sizeof(struct in)]; char b[sizeof(struct in[2])]; };", "struct c3", 48},
+		{"struct c4 { char a[(int)(sizeof(struct in) / sizeof(char))]; };", "struct c4", 16},
+		{"struct c5 { char a[N > 3 ? 3 : 5]; char b[F_C]; char c[(unsigned char)257]; };", "struct c5", 36},
+		{"struct c6 { char a[~0u >> 28]; char b[-1 < 0u ? 1 : 2]; char c[0xFFull & 3]; };", "struct c6", 20},
+		{"struct c7 { char a[_Alignof(double) + sizeof(int *)]; int b : sizeof(short) * 4; };", "struct c7", 20},
+		{"struct c8 { int a; _Alignas(struct in) char b; };", "struct c8", 16},
+	}
+
+	for _, testCase := range testCases {
+		structs, err := ExtractAggregates("", defs+testCase.test, false, abi.DefaultTarget())
+		if err != nil {
+			t.Errorf("Unexpected error when parsing %s: %s", testCase.test, err)
+			continue
+		}
+
+		meta, err := structs.ResolveMeta(testCase.name)
+		if err != nil {
+			t.Errorf("Unexpected error when resolving %s: %s", testCase.test, err)
+			continue
+		}
+
+		if meta.Size != testCase.expSize {
+			t.Errorf("Expected size: %d: got: %d for '%s'", testCase.expSize, meta.Size,
+				testCase.test)
+		}
+	}
+
+	errorCases := []string{
+		"struct e1 { char a[1 << 40]; };",
+		"struct e2 { char a[N / (M - 5)]; };",
+		"struct e3 { char a[(long)(char *)8]; };",
+		"struct e4 { char a[sizeof(N) - 8]; };",
+	}
+
+	for _, test := range errorCases {
+		_, err := ExtractAggregates("", defs+test, false, abi.DefaultTarget())
+		if !errors.Is(err, ErrExpr) {
+			t.Errorf("Expected error %v: got %v for '%s'", ErrExpr, err, test)
+		}
+	}
+}
+
+func TestEnumSizing(t *testing.T) {
+	const defs = `enum a { A1 = 1 }; enum b { B1 = -1, B2 = 200 }; enum d { D1 = 256 };
+	enum f { F1 = 0x7fffffff }; enum h { H1 = -1, H2 = 0x80000000 };
+	enum i { I1 = 0x100000000 }; enum fix : unsigned short { X1 };
+	typedef enum : signed char { T1 = -4 } fix_t;
+	struct s { char c; enum fix x; fix_t t; enum a a; };
+	enum pe { P1, P2 } __attribute__((packed)); struct se { char c; enum pe e; };
+	typedef enum { N1 = -1, N2 = 300 } __attribute__((packed)) pn_t;`
+
+	testCases := []struct {
+		name       string
+		short      bool
+		expSize    int
+		underlying string
+	}{
+		{"enum a", false, 4, "unsigned int"},
+		{"enum b", false, 4, "int"},
+		{"enum h", false, 8, "long"},
+		{"enum i", false, 8, "unsigned long"},
+		{"enum fix", false, 2, "unsigned short"},
+		{"fix_t", false, 1, "signed char"},
+		{"struct s", false, 12, ""},
+		{"enum pe", false, 1, "unsigned char"},
+		{"struct se", false, 2, ""},
+		{"pn_t", false, 2, "short"},
+		{"enum a", true, 1, "unsigned char"},
+		{"enum b", true, 2, "short"},
+		{"enum d", true, 2, "unsigned short"},
+		{"enum f", true, 4, "unsigned int"},
+		{"enum h", true, 8, "long"},
+		{"enum fix", true, 2, "unsigned short"},
+		{"struct s", true, 6, ""},
+		{"enum pe", true, 1, "unsigned char"},
+	}
+
+	for _, testCase := range testCases {
+		target := abi.DefaultTarget()
+		target.ShortEnums = testCase.short
+
+		structs, err := ExtractAggregates("", defs, false, target)
+		if err != nil {
+			t.Fatalf("Unexpected error when parsing: %s", err)
+		}
+
+		meta, err := structs.ResolveMeta(testCase.name)
+		if err != nil {
+			t.Errorf("Unexpected error when resolving %s: %s", testCase.name, err)
+			continue
+		}
+
+		if meta.Size != testCase.expSize || meta.Underlying != testCase.underlying {
+			t.Errorf("Expected size/underlying type: %d/%q: got: %d/%q for '%s' (short %t)",
+				testCase.expSize, testCase.underlying, meta.Size, meta.Underlying,
+				testCase.name, testCase.short)
+		}
+	}
+}
+
+func TestTargetIntRange(t *testing.T) {
+	testCases := []struct {
+		target string
+		test   string
+		fits   bool
+	}{
+		{"x86_64", "struct r1 { char a[0x8000]; };", true},
+		{"avr", "struct r1 { char a[0x8000]; };", false},
+		{"avr", "struct r2 { char a[0x7fff]; };", true},
+		{"msp430", "struct r3 { char a[40000u]; };", false},
+	}
+
+	for _, testCase := range testCases {
+		target, err := abi.LookupTarget(testCase.target)
+		if err != nil {
+			t.Fatalf("Unexpected error when looking up %s: %s", testCase.target, err)
+		}
+
+		_, err = ExtractAggregates("", testCase.test, false, target)
+		switch {
+		case testCase.fits && err != nil:
+			t.Errorf("Unexpected error when parsing %s on %s: %s", testCase.test,
+				testCase.target, err)
+		case !testCase.fits && !errors.Is(err, ErrExpr):
+			t.Errorf("Expected error %v: got %v for '%s' on %s", ErrExpr, err,
+				testCase.test, testCase.target)
+		}
+	}
+}
+
+func TestAggregates(t *testing.T) {
+	const test = `enum { N = 4 }; struct a { char c; double d; };
+	typedef struct b { int x; } b_t; typedef struct { int y; } c_t;
+	struct wrap { struct inner { char z; } in; struct { int w; } anon; };
+	typedef b_t b2_t; union u { char c; int i; };`
+
+	structs, err := ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	var names []string
+	for _, aggregate := range structs.Aggregates() {
+		names = append(names, GetAggregateNames(aggregate)[0])
+	}
+
+	expected := []string{"struct a", "b_t", "c_t", "struct wrap", "struct inner", "union u"}
+	if !slices.Equal(names, expected) {
+		t.Errorf("Expected aggregates %v: got %v", expected, names)
+	}
+}
+
+func TestOffsets(t *testing.T) {
+	const test = `struct o { char c; short s; char d; double x; struct { char a; int i; } in;
+	union { char k; long l; } u; char tail[3]; int b1 : 5; int b2 : 30; };`
+
+	structs, err := ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	meta, err := structs.ResolveMeta("struct o")
+	if err != nil {
+		t.Fatalf("Unexpected error when resolving %s: %s", test, err)
+	}
+
+	expected := []struct {
+		offset    int
+		bitOffset int
+	}{
+		{0, 0}, {2, 16}, {4, 32}, {8, 64}, {16, 128},
+		{24, 192}, {32, 256}, {35, 280}, {36, 288},
+	}
+
+	if len(meta.Layout) != len(expected) {
+		t.Fatalf("Expected %d fields: got %d", len(expected), len(meta.Layout))
+	}
+
+	for idx, layout := range meta.Layout {
+		exp := expected[idx]
+		if layout.Offset() != exp.offset || layout.BitOffset() != exp.bitOffset {
+			t.Errorf("Expected %s at %d (bit %d): got %d (bit %d)", layout.Declaration(),
+				exp.offset, exp.bitOffset, layout.Offset(), layout.BitOffset())
+		}
+	}
+
+	nested := meta.Layout[4].subAggregate[1]
+	if nested.Offset() != 4 {
+		t.Errorf("Expected nested field i at 4: got %d", nested.Offset())
+	}
+}
+
+func TestTargetLayouts(t *testing.T) {
+	const test = `struct t { char c; double d; long long ll; long double ld; long l;
+	int_fast16_t f; void *p; enum { A } e; };
+	struct sz { char buf[sizeof(long) * 2]; short s; };`
+
+	testCases := []struct {
+		target  string
+		name    string
+		expSize int
+		expAl   int
+	}{
+		{"x86_64", "struct t", 80, 16},
+		{"i386", "struct t", 48, 4},
+		{"win64", "struct t", 56, 8},
+		{"arm-eabi", "struct t", 48, 8},
+		{"avr", "struct t", 31, 1},
+		{"msp430", "struct t", 36, 2},
+		{"x86_64", "struct sz", 18, 2},
+		{"win64", "struct sz", 10, 2},
+		{"avr", "struct sz", 10, 1},
+	}
+
+	for _, testCase := range testCases {
+		target, err := abi.LookupTarget(testCase.target)
+		if err != nil {
+			t.Fatalf("Unexpected error when looking up %s: %s", testCase.target, err)
+		}
+
+		structs, err := ExtractAggregates("", test, false, target)
+		if err != nil {
+			t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+		}
+
+		meta, err := structs.ResolveMeta(testCase.name)
+		if err != nil {
+			t.Errorf("Unexpected error when resolving %s: %s", testCase.name, err)
+			continue
+		}
+
+		if meta.Size != testCase.expSize || meta.Alignment != testCase.expAl {
+			t.Errorf("Expected size/alignment for %s on %s: %d/%d: got: %d/%d",
+				testCase.name, testCase.target, testCase.expSize, testCase.expAl,
+				meta.Size, meta.Alignment)
+		}
+	}
+}
+
+func TestTargetMacros(t *testing.T) {
+	const test = `struct m { char c;
+	#if __SIZEOF_POINTER__ == 4
+	int narrow;
+	#endif
+	#if defined(__x86_64__) && defined(__LP64__)
+	long wide;
+	#endif
+	#ifdef __arm__
+	short arm;
+	#endif
+	};`
+
+	testCases := []struct {
+		target string
+		fields []string
+	}{
+		{"x86_64", []string{"c", "wide"}},
+		{"i386", []string{"c", "narrow"}},
+		{"arm-none-eabi", []string{"c", "narrow", "arm"}},
+		{"avr", []string{"c"}},
+	}
+
+	for _, testCase := range testCases {
+		target, err := abi.LookupTarget(testCase.target)
+		if err != nil {
+			t.Fatalf("Unexpected error when looking up %s: %s", testCase.target, err)
+		}
+
+		structs, err := ExtractAggregates("", test, false, target)
+		if err != nil {
+			t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+		}
+
+		meta, err := structs.ResolveMeta("struct m")
+		if err != nil {
+			t.Errorf("Unexpected error when resolving struct m: %s", err)
+			continue
+		}
+
+		var fields []string
+		for _, fLayout := range meta.Layout {
+			fields = append(fields, FieldName(fLayout.Field))
+		}
+
+		if !slices.Equal(fields, testCase.fields) {
+			t.Errorf("Expected fields %v on %s: got %v", testCase.fields, testCase.target, fields)
+		}
+	}
+}
+
+func TestTypedefAttributes(t *testing.T) {
+	const test = `typedef int aint __attribute__((aligned(16)));
+	typedef int __attribute__((aligned(2))) lint;
+	typedef aint aint2;
+	typedef int pint __attribute__((packed));
+	typedef int arr_t[3] __attribute__((aligned(8)));
+	struct ta { char c; aint x; };
+	struct tl { char c; lint x; };
+	struct t2 { char c; aint2 x; };
+	struct tp { char c; pint x; };
+	struct tar { char c; arr_t x; };
+	struct tlb { char c; lint x __attribute__((aligned(8))); };
+	#pragma pack(push, 4)
+	struct tpk { char c; aint x; };
+	#pragma pack(pop)
+	struct __attribute__((packed)) tpa { char c; aint x; };
+	struct tsz { char buf[_Alignof(aint)]; };`
+
+	testCases := []struct {
+		name    string
+		expSize int
+		expAl   int
+	}{
+		{"struct ta", 32, 16},
+		{"struct tl", 6, 2},
+		{"struct t2", 32, 16},
+		{"struct tp", 8, 4},
+		{"struct tar", 24, 8},
+		{"struct tlb", 16, 8},
+		{"struct tpk", 8, 4},
+		{"struct tpa", 5, 1},
+		{"struct tsz", 16, 1},
+	}
+
+	structs, err := ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	for _, testCase := range testCases {
+		meta, err := structs.ResolveMeta(testCase.name)
+		if err != nil {
+			t.Errorf("Unexpected error when resolving %s: %s", testCase.name, err)
+			continue
+		}
+
+		if meta.Size != testCase.expSize || meta.Alignment != testCase.expAl {
+			t.Errorf("Expected size/alignment for %s: %d/%d: got: %d/%d", testCase.name,
+				testCase.expSize, testCase.expAl, meta.Size, meta.Alignment)
+		}
+	}
+}
@@ -0,0 +1,1103 @@
+package layout
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"modernc.org/cc/v4"
+)
+
+// FieldKind represents the kind of field in an aggregate
+type FieldKind uint
+
+const (
+	ValueKind FieldKind = iota
+	PointerKind
+	ArrayKind
+	FunctionPointerKind
+	EnumEntryKind
+	BitFieldKind
+	FlexibleArrayKind
+)
+
+// AggregateKind represents the kind of aggregate
+type AggregateKind uint
+
+const (
+	StructKind AggregateKind = iota
+	UnionKind
+	EnumKind
+)
+
+// An Aggregate represents a C aggregate type (struct, union, enum).
+// Packed and Alignment reflect the GCC packed/aligned attributes applied to
+// the aggregate, while Pack holds the value set by an enclosing
+// '#pragma pack' directive, or zero if there is none.
+// Underlying holds the fixed underlying type of a C23 enum, e.g.
+// `enum e : uint8_t`, or is empty if there is none.
+// Directives holds the stropt directives found within the comments next to
+// the fields, e.g. `/* stropt: pin */`, keyed by field name.
+// Definition locates the body of structs and unions within the source, or
+// is nil if it cannot be found, e.g. when it is produced by a macro.
+type Aggregate struct {
+	Name       string
+	Typedef    string
+	Kind       AggregateKind
+	Fields     []Field
+	Packed     bool
+	Alignment  int
+	Pack       int
+	Underlying string
+	Directives map[string][]string
+	Definition *Definition
+}
+
+// IsPacked reports whether the layout of the aggregate is affected by the
+// packed attribute or by a '#pragma pack' directive.
+func (agg *Aggregate) IsPacked() bool {
+	return agg.Packed || agg.Pack != 0
+}
+
+// A Field is an entry that can be found within an aggregate, be it a struct
+// or a union. It defines the Type method, useful to get its type description
+// as a string.
+type Field interface {
+	Type() string
+	Declaration() string
+	UnqualifiedType() string
+}
+
+// A Basic field is a field of either a primitive type, or an aggregate type.
+// It describes a C value type. Packed is set if the field carries the packed
+// attribute, which drops its alignment requirement, while Alignment holds an
+// explicit alignment override coming from `_Alignas` or the aligned
+// attribute, or zero if there is none.
+// If the aggregate type of the field is defined inline within the field
+// declaration, e.g. `struct { int x, y; } pos;`, then Inline holds its
+// definition. Anonymous members, e.g. `union { int i; float f; };`, have an
+// empty name. Declarator holds the derivations applied by the declarator of
+// the field, e.g. the qualifiers of each pointer level, so that it can be
+// declared as written, see Declare.
+type Basic struct {
+	Qualifiers []string
+	TypeName   string
+	Name       string
+	Packed     bool
+	Alignment  int
+	Inline     *Aggregate
+	Declarator Declarator
+}
+
+var (
+	keyQualifiers = map[string]struct{}{
+		"signed":   {},
+		"unsigned": {},
+		"long":     {},
+	}
+)
+
+// Type returns the type of the Basic field.
+func (b Basic) Type() string {
+	var builder strings.Builder
+	for _, qualifier := range b.Qualifiers {
+		builder.WriteString(qualifier)
+		builder.WriteRune(' ')
+	}
+	builder.WriteString(b.TypeName)
+	return builder.String()
+}
+
+// Declaration returns the fully qualified name for the field. For a basic
+// field, that's just its name.
+func (b Basic) Declaration() string {
+	return b.Name
+}
+
+// UnqualifiedType returns the underlying type of the field without qualifiers
+// that only affect access/storage. Signedness and `longness` are kept.
+func (b Basic) UnqualifiedType() string {
+	var unqualType []string
+	for _, qual := range b.Qualifiers {
+		if _, isKey := keyQualifiers[qual]; isKey {
+			unqualType = append(unqualType, qual)
+		}
+	}
+
+	unqualType = append(unqualType, b.TypeName)
+	return strings.Join(unqualType, " ")
+}
+
+// A Pointer is an aggregate field which is a pointer to any Basic type. It
+// describes a C pointer type, as derived by the Declarator of its Basic part.
+type Pointer struct {
+	Basic
+}
+
+// PointerQualifiers returns the qualifiers of the pointer, e.g. `const` for
+// `char *const p`.
+func (p Pointer) PointerQualifiers() []string {
+	return p.Declarator.pointerQualifiers()
+}
+
+// Type returns the type of the Pointer field.
+func (p Pointer) Type() string {
+	var builder strings.Builder
+	for _, qualifier := range p.Qualifiers {
+		builder.WriteString(qualifier)
+		builder.WriteRune(' ')
+	}
+
+	builder.WriteString(p.TypeName)
+	builder.WriteString(" * ")
+
+	for _, pQualifier := range p.PointerQualifiers() {
+		builder.WriteString(pQualifier)
+		builder.WriteRune(' ')
+	}
+
+	return builder.String()
+}
+
+// UnqualifiedType returns the underlying type of the field without qualifiers
+// that only affect access/storage. Signedness and `longness` are kept.
+func (p Pointer) UnqualifiedType() string {
+	return p.Basic.UnqualifiedType()
+}
+
+// Declaration returns the fully qualified name for the field. For a Pointer
+// field, that's just its name.
+func (p Pointer) Declaration() string {
+	return p.Name
+}
+
+// An Array is an aggregate field which is an array to any Basic type. It
+// describes a C array type, with one or more dimensions, e.g. `int m[4][8];`.
+// Its elements can be values, pointers, e.g. `char *names[16];`, or function
+// pointers, e.g. `int (*handlers[4])(int);`, as reported by Element, the
+// return type of function pointers being the Basic type. The dimensions,
+// and the derivations of the elements, are held by the Declarator of the
+// Basic part.
+type Array struct {
+	Basic
+	Element FieldKind
+}
+
+// Dimensions returns the number of elements of each dimension of the array.
+func (a Array) Dimensions() []int {
+	return a.Declarator.dimensions()
+}
+
+// PointerQualifiers returns the qualifiers of the pointer elements of the
+// array, e.g. `const` for `char *const names[4]`.
+func (a Array) PointerQualifiers() []string {
+	return a.Declarator.pointerQualifiers()
+}
+
+// Args returns the type of each argument of the function pointer elements of
+// the array.
+func (a Array) Args() []string {
+	return a.Declarator.parameters()
+}
+
+// Type returns the type of the Array field, which is the type of its elements.
+func (a Array) Type() string {
+	element := a.Declarator[len(a.Dimensions()):]
+	switch a.Element {
+	case PointerKind:
+		return Pointer{Basic{Qualifiers: a.Qualifiers, TypeName: a.TypeName,
+			Declarator: element}}.Type()
+	case FunctionPointerKind:
+		return FuncPointer{ReturnType: a.TypeName, Name: a.Name, Declarator: element}.Type()
+	}
+
+	var builder strings.Builder
+	for _, qualifier := range a.Qualifiers {
+		builder.WriteString(qualifier)
+		builder.WriteRune(' ')
+	}
+	builder.WriteString(a.TypeName)
+	return builder.String()
+}
+
+// UnqualifiedType returns the underlying type of the field without qualifiers
+// that only affect access/storage. Signedness and `longness` are kept.
+func (a Array) UnqualifiedType() string {
+	return a.Basic.UnqualifiedType()
+}
+
+// Declaration returns the fully qualified name for the field. For an Array
+// field, that's its name and dimensions, followed by the argument list for
+// arrays of function pointers.
+func (a Array) Declaration() string {
+	var builder strings.Builder
+
+	builder.WriteString(a.Name)
+	for _, derivation := range a.Declarator[:len(a.Dimensions())] {
+		if derivation.Unsized {
+			builder.WriteString("[]")
+			continue
+		}
+		fmt.Fprintf(&builder, "[%d]", derivation.Length)
+	}
+
+	if a.Element == FunctionPointerKind {
+		builder.WriteRune('(')
+		builder.WriteString(strings.Join(a.Args(), ", "))
+		builder.WriteRune(')')
+	}
+	return builder.String()
+}
+
+// Elements returns the total number of elements in the array, across all of
+// its dimensions.
+func (a Array) Elements() int {
+	elements := 1
+	for _, dim := range a.Dimensions() {
+		elements *= dim
+	}
+	return elements
+}
+
+// A FlexibleArray is an array field whose first dimension is unspecified,
+// i.e. a C99 flexible array member, e.g. `uint8_t data[];`, or a GNU zero
+// length array, e.g. `uint8_t data[0];`, as reported by ZeroLength. It only
+// contributes its alignment to the aggregate, and it must be its last member.
+// Its first dimension is held by the Array as an empty or zero one.
+type FlexibleArray struct {
+	Array
+	ZeroLength bool
+}
+
+// A BitField is an aggregate field of an integral type with an explicit width
+// in bits. Unnamed bit-fields only contribute padding, and a zero width one
+// forces the next field to start at the next storage unit of its type.
+type BitField struct {
+	Basic
+	Width int
+}
+
+// Declaration returns the fully qualified name for the field. For a BitField
+// field, that's its name and width.
+func (bf BitField) Declaration() string {
+	if bf.Name == "" {
+		return fmt.Sprintf(":%d", bf.Width)
+	}
+	return fmt.Sprintf("%s:%d", bf.Name, bf.Width)
+}
+
+// An FuncPointer is an aggregate field which describes a C function pointer.
+// ReturnType and Qualifiers are the specifiers of its declaration, while
+// Declarator holds the complete derivations of its declarator, e.g. for
+// functions returning pointers.
+type FuncPointer struct {
+	Qualifiers []string
+	ReturnType string
+	Name       string
+	Declarator Declarator
+}
+
+// Args returns the type of each argument of the function pointer.
+func (fp FuncPointer) Args() []string {
+	return fp.Declarator.parameters()
+}
+
+// Type returns the type of the FuncPointer field.
+func (fp FuncPointer) Type() string {
+	var builder strings.Builder
+	builder.WriteString(fp.ReturnType)
+	builder.WriteString(" (*) ")
+
+	args := fp.Args()
+	for idx, arg := range args {
+		builder.WriteString(arg)
+		if idx != len(args)-1 {
+			builder.WriteString(", ")
+		}
+	}
+	return builder.String()
+}
+
+// UnqualifiedType returns the underlying type of the field without qualifiers
+// that only affect access/storage. Signedness and `longness` are kept.
+func (fp FuncPointer) UnqualifiedType() string {
+	return fp.Type()
+}
+
+// Declaration returns the fully qualified name for the field. For a
+// FuncPointer field, that's its name and argument list.
+func (f FuncPointer) Declaration() string {
+	var builder strings.Builder
+
+	builder.WriteString(f.Name)
+	builder.WriteRune('(')
+
+	args := f.Args()
+	for idx, arg := range args {
+		builder.WriteString(arg)
+		if idx != len(args)-1 {
+			builder.WriteString(", ")
+		}
+	}
+
+	builder.WriteRune(')')
+	return builder.String()
+}
+
+// An EnumEntry is a representation of one of the symbols defined as part of
+// an enum, alongside with its value.
+type EnumEntry struct {
+	Name  string
+	Value int64
+}
+
+// Type returns the type of the FuncPointer field.
+func (ee EnumEntry) Type() string {
+	return "enum"
+}
+
+// UnqualifiedType returns the underlying type of the field without qualifiers
+// that only affect access/storage. Signedness and `longness` are kept.
+func (ee EnumEntry) UnqualifiedType() string {
+	return ee.Name
+}
+
+// Declaration returns the fully qualified name for the field. For an Enum
+// field, that's just its name.
+func (e EnumEntry) Declaration() string {
+	return e.Name
+}
+
+var (
+	ErrNotAnAggregate = errors.New("not an aggregate")
+)
+
+// ParseAggregate parses a declaration tree in search for an Aggregate.
+// If it does find one, it returns a pointer to it, otherwise fails reporting
+// an error and returning a nil aggregate.
+func (ctx Context) ParseAggregate(decl *cc.Declaration) (*Aggregate, error) {
+	var ret Aggregate
+
+	// if the type was typedef'd, we retrieve the typedef name
+	if decl.InitDeclaratorList != nil {
+		if token := getTypedefToken(decl); token != nil {
+			ret.Typedef = token.SrcStr()
+		}
+	}
+
+	// at this point, a type specifier must be present...
+	typeSpec := getTypeSpecifier(decl)
+	if typeSpec == nil {
+		return nil, ErrNotAnAggregate
+	}
+
+	// ...as it will lead us to the struct/union/enum specifier
+	aggrSpec := typeSpec.StructOrUnionSpecifier
+	enumSpec := typeSpec.EnumSpecifier
+
+	if aggrSpec == nil {
+		if enumSpec != nil {
+			err := ctx.parseEnum(enumSpec, &ret)
+			if err != nil {
+				return nil, err
+			}
+
+			// the attributes of an enum follow its body, among the specifiers
+			attrs, err := ctx.parseSpecifiersAttributes(decl.DeclarationSpecifiers)
+			if err != nil {
+				return nil, err
+			}
+			ret.Packed = attrs.packed
+			return &ret, nil
+		}
+		return nil, ErrNotAnAggregate
+	}
+
+	if err := ctx.parseStructOrUnion(aggrSpec, &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// ParseTypedef parses a typedef declaration, returning the type defined by
+// each of its declarators, keyed by name. Each type is described by a Field
+// named after the typedef, e.g. `typedef unsigned u32, *u32_ptr;` defines a
+// Basic u32 field and a Pointer u32_ptr field. The alignment set by the
+// aligned attribute of a typedef is held by the Alignment of its field.
+func (ctx Context) ParseTypedef(decl *cc.Declaration) (map[string]Field, error) {
+	qualifiers, typeName, inline, err := ctx.parseDeclarationSpecifiers(decl.DeclarationSpecifiers)
+	if err != nil {
+		return nil, err
+	}
+
+	specAttrs, err := ctx.parseSpecifiersAttributes(decl.DeclarationSpecifiers)
+	if err != nil {
+		return nil, err
+	}
+
+	typedefs := make(map[string]Field)
+
+	list := decl.InitDeclaratorList
+	for ; list != nil; list = list.InitDeclaratorList {
+		name, meta, kind, err := ctx.parseDeclarator(list.InitDeclarator.Declarator)
+		if err != nil {
+			return nil, err
+		}
+
+		_, meta.declarator, err = ctx.parseDerivations(list.InitDeclarator.Declarator)
+		if err != nil {
+			return nil, err
+		}
+
+		attrs := specAttrs
+		if err := ctx.parseAttributes(list.InitDeclarator.AttributeSpecifierList, &attrs); err != nil {
+			return nil, err
+		}
+
+		// as for GCC, the packed attribute has no effect on typedefs
+		basic := Basic{Qualifiers: qualifiers, TypeName: typeName, Name: name,
+			Alignment: attrs.alignment, Inline: inline}
+		typedefs[name] = newField(basic, meta, kind)
+	}
+	return typedefs, nil
+}
+
+// IsTypedef checks whether the passed declaration is a typedef.
+func IsTypedef(decl *cc.Declaration) bool {
+	list := decl.InitDeclaratorList
+	return list != nil && list.InitDeclarator.Declarator.IsTypename()
+}
+
+// IsAggregateDefinition checks whether the passed declaration defines a
+// struct, union or enum, rather than just referring to one, as in forward
+// declarations or in `typedef struct foo foo_t;`.
+func IsAggregateDefinition(decl *cc.Declaration) bool {
+	typeSpec := getTypeSpecifier(decl)
+
+	switch {
+	case typeSpec == nil:
+		return false
+	case typeSpec.StructOrUnionSpecifier != nil:
+		return typeSpec.StructOrUnionSpecifier.Case == cc.StructOrUnionSpecifierDef
+	case typeSpec.EnumSpecifier != nil:
+		return typeSpec.EnumSpecifier.Case == cc.EnumSpecifierDef
+	}
+	return false
+}
+
+// parseStructOrUnion parses the definition of a struct or union, be it a
+// top level one, or one defined inline within another aggregate.
+func (ctx Context) parseStructOrUnion(aggrSpec *cc.StructOrUnionSpecifier, ret *Aggregate) error {
+	// check which kind of aggregate this is, and its name
+	var (
+		aggregateId   = aggrSpec.Token.SrcStr()
+		aggregateKind = aggrSpec.StructOrUnion.Token.SrcStr()
+	)
+
+	switch aggregateKind {
+	case "struct":
+		ret.Kind = StructKind
+	case "union":
+		ret.Kind = UnionKind
+	}
+
+	// if this is not a anonymous typedef'd struct, we get the name from here
+	if aggregateId != "" {
+		ret.Name = fmt.Sprintf("%s %s", aggregateKind, aggregateId)
+	}
+
+	// attributes can be found both before and after the aggregate body
+	var attrs attributes
+	if err := ctx.parseAttributes(aggrSpec.AttributeSpecifierList, &attrs); err != nil {
+		return err
+	}
+
+	if err := ctx.parseAttributes(aggrSpec.AttributeSpecifierList2, &attrs); err != nil {
+		return err
+	}
+	ret.Packed, ret.Alignment = attrs.packed, attrs.alignment
+
+	// let us extract the fields and fully qualify them
+	var (
+		decls      []*cc.StructDeclaration
+		declFields [][]Field
+	)
+
+	declList := aggrSpec.StructDeclarationList
+	for ; declList != nil; declList = declList.StructDeclarationList {
+		fields, err := ctx.parseField(declList.StructDeclaration)
+		if err != nil {
+			return err
+		}
+		ret.Fields = append(ret.Fields, fields...)
+
+		decls = append(decls, declList.StructDeclaration)
+		declFields = append(declFields, fields)
+	}
+
+	// comments are not part of the AST, directives are recovered from the
+	// source text
+	ret.Directives = ctx.fieldDirectives(aggrSpec, decls, declFields)
+	ret.Definition = ctx.definition(aggrSpec, decls, declFields)
+	return nil
+}
+
+// InlineAggregates returns the aggregates defined inline within the fields of
+// the passed aggregate, recursively. An inline definition shared by multiple
+// declarators, e.g. `struct { int x; } a, b;`, is only returned once.
+func InlineAggregates(aggregate *Aggregate) []*Aggregate {
+	var inline []*Aggregate
+	for _, field := range aggregate.Fields {
+		basic, isBasic := AsBasic(field)
+		if !isBasic || basic.Inline == nil || slices.Contains(inline, basic.Inline) {
+			continue
+		}
+
+		inline = append(inline, basic.Inline)
+		inline = append(inline, InlineAggregates(basic.Inline)...)
+	}
+	return inline
+}
+
+// GetAggregateNames returns the identifier with which a user can refer to the
+// passed aggregate.
+// If the aggregate is not anonymous, then this function returns both the
+// fully qualified name e.g. `struct foo`, and just the unqualified aggregate
+// name, e.g. `foo`. If this is a typedef'd type, it returns the typedef name
+// for the aggregate. Any combination of the two is possible, but not having
+// any name should not be possible.
+func GetAggregateNames(aggregate *Aggregate) []string {
+	const (
+		maxNames  = 3
+		structTag = "struct "
+	)
+
+	names := make([]string, 0, maxNames)
+
+	if aggregate.Typedef != "" {
+		names = append(names, aggregate.Typedef)
+	}
+
+	if aggregate.Name != "" {
+		structIndex := strings.Index(aggregate.Name, structTag)
+		noStructName := aggregate.Name[structIndex+len(structTag):]
+		names = append(names, aggregate.Name)
+		names = append(names, noStructName)
+	}
+
+	return names
+}
+
+// parseEnum parses the whole enum in one go, since it's the simplest
+// aggregate kind. The value of each enumeration constant is computed and
+// recorded within the context, so that it can be used within the constant
+// expressions that follow.
+func (ctx Context) parseEnum(spec *cc.EnumSpecifier, enum *Aggregate) error {
+	// if this is nil, we just know this cannot be any other kind of aggregate
+	if spec == nil {
+		return ErrNotAnAggregate
+	}
+
+	// extract kind and name: typedef should already be here from earlier
+	enum.Kind = EnumKind
+
+	// Token is always `enum`, Token2 contains the non-anonymous enum name
+	name := spec.Token2.SrcStr()
+	if name != "" {
+		enum.Name = fmt.Sprintf("enum %s", spec.Token2.SrcStr())
+	}
+
+	// the fixed underlying type of C23 enums is stripped before parsing
+	enum.Underlying = ctx.enumBases[positionKey(spec.Token.Position())]
+
+	// add the enum entries one by one: each one has the value of its
+	// expression, if any, or the value of the previous one plus one
+	var (
+		next           = constValue{size: ctx.target.Int.Size}
+		minInt, maxInt = intRange(ctx.target.Int.Size)
+	)
+	for list := spec.EnumeratorList; list != nil; list = list.EnumeratorList {
+		var (
+			enumerator = list.Enumerator
+			entry      = enumerator.Token.SrcStr()
+			value      = next
+		)
+
+		if enumerator.Case == cc.EnumeratorExpr {
+			var err error
+			value, err = ctx.evaluate(enumerator.ConstantExpression)
+			if err != nil {
+				return fmt.Errorf("%s: %w", entry, err)
+			}
+		}
+
+		// enumeration constants are ints, unless their value does not fit one
+		if value.value >= minInt && value.value <= maxInt {
+			value = constValue{value: value.value, size: ctx.target.Int.Size}
+		} else {
+			value = constValue{value: value.value, size: ctx.target.LongLong.Size}
+		}
+
+		ctx.enumerators[entry] = value
+		next = constValue{value: value.value + 1, size: value.size}
+		enum.Fields = append(enum.Fields, EnumEntry{entry, value.value})
+	}
+	return nil
+}
+
+// parseField is a builder for the Field type. It constructs and returns the
+// Fields described by the passed declaration, one for each of its
+// declarators, e.g. `int a, b, *c;` yields three fields. Static assertions
+// declare no field at all.
+func (ctx Context) parseField(fieldDecl *cc.StructDeclaration) ([]Field, error) {
+	if fieldDecl.Case == cc.StructDeclarationAssert {
+		return nil, nil
+	}
+
+	qualifiers, typeName, inline, err := ctx.parseQualifiers(fieldDecl.SpecifierQualifierList)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := ctx.parseFieldAttributes(fieldDecl)
+	if err != nil {
+		return nil, err
+	}
+
+	// anonymous members have no declarator at all
+	if fieldDecl.StructDeclaratorList == nil {
+		return []Field{
+			Basic{qualifiers, typeName, "", attrs.packed, attrs.alignment, inline, nil},
+		}, nil
+	}
+
+	var fields []Field
+
+	list := fieldDecl.StructDeclaratorList
+	for ; list != nil; list = list.StructDeclaratorList {
+		name, meta, kind, err := ctx.parseName(list.StructDeclarator)
+		if err != nil {
+			return nil, err
+		}
+
+		basic := Basic{qualifiers, typeName, name, attrs.packed, attrs.alignment, inline, nil}
+		fields = append(fields, newField(basic, meta, kind))
+	}
+	return fields, nil
+}
+
+// newField builds the Field of the passed kind, starting from its Basic part
+// and the metadata found within its declarator.
+func newField(basic Basic, meta FieldMeta, kind FieldKind) Field {
+	basic.Declarator = meta.declarator
+
+	switch kind {
+	case PointerKind:
+		return Pointer{basic}
+	case ArrayKind:
+		return Array{basic, meta.element}
+	case FunctionPointerKind:
+		return FuncPointer{basic.Qualifiers, basic.TypeName, basic.Name, meta.declarator}
+	case BitFieldKind:
+		return BitField{basic, meta.bitWidth}
+	case FlexibleArrayKind:
+		return FlexibleArray{Array{basic, meta.element}, meta.zeroLength}
+	default:
+		return basic
+	}
+}
+
+// parseQualifiers checks for qualifiers within the passed list, i.e. the one
+// of a field declaration or of a type name, and returns them, alongside with
+// the type of the declaration, which is contained as the last qualifier in
+// the declaration. If the type is an aggregate defined inline, its
+// definition is returned too.
+func (ctx Context) parseQualifiers(list *cc.SpecifierQualifierList) ([]string, string, *Aggregate, error) {
+	var (
+		qualifierId string
+		qualifiers  []string
+		inline      *Aggregate
+		err         error
+	)
+
+	for ; list != nil; list = list.SpecifierQualifierList {
+		switch list.Case {
+		case cc.SpecifierQualifierListTypeQual: // case 1: TypeQualifier present
+			qual := list.TypeQualifier
+			if qual.Case == cc.TypeQualifierAttr {
+				// attributes are not qualifiers, see parseFieldAttributes
+				continue
+			}
+			qualifierId = qual.Token.SrcStr()
+		case cc.SpecifierQualifierListAlignSpec: // case 3: _Alignas present
+			// alignment specifiers are handled by parseFieldAttributes
+			continue
+		case cc.SpecifierQualifierListTypeSpec: // case 2: TypeSpecifier present
+			qual := list.TypeSpecifier
+			switch qual.Case {
+			case cc.TypeSpecifierStructOrUnion:
+				qualifierId = parseStructOrUnionQualifier(qual.StructOrUnionSpecifier)
+				inline, err = ctx.parseInlineStructOrUnion(qual.StructOrUnionSpecifier)
+			case cc.TypeSpecifierEnum:
+				qualifierId = parseEnumQualifier(qual.EnumSpecifier)
+				inline, err = ctx.parseInlineEnum(qual.EnumSpecifier)
+			default:
+				qualifierId = qual.Token.SrcStr()
+			}
+		}
+
+		if err != nil {
+			return nil, "", nil, err
+		}
+		qualifiers = append(qualifiers, qualifierId)
+	}
+
+	// this is the index of the type of the declaration
+	lastIdx := len(qualifiers) - 1
+
+	if len(qualifiers) > 1 {
+		return qualifiers[0:lastIdx], qualifiers[lastIdx], inline, nil
+	}
+
+	return nil, qualifiers[lastIdx], inline, nil
+}
+
+// parseDeclarationSpecifiers is the equivalent of parseQualifiers for the
+// specifiers of a declaration, e.g. a typedef. Storage class and function
+// specifiers are skipped, as well as attributes and alignment specifiers.
+func (ctx Context) parseDeclarationSpecifiers(specs *cc.DeclarationSpecifiers) ([]string, string, *Aggregate, error) {
+	var (
+		qualifiers []string
+		inline     *Aggregate
+		err        error
+	)
+
+	for ; specs != nil; specs = specs.DeclarationSpecifiers {
+		switch specs.Case {
+		case cc.DeclarationSpecifiersTypeQual:
+			if specs.TypeQualifier.Case != cc.TypeQualifierAttr {
+				qualifiers = append(qualifiers, specs.TypeQualifier.Token.SrcStr())
+			}
+		case cc.DeclarationSpecifiersTypeSpec:
+			spec := specs.TypeSpecifier
+			switch spec.Case {
+			case cc.TypeSpecifierStructOrUnion:
+				qualifiers = append(qualifiers, parseStructOrUnionQualifier(spec.StructOrUnionSpecifier))
+				inline, err = ctx.parseInlineStructOrUnion(spec.StructOrUnionSpecifier)
+			case cc.TypeSpecifierEnum:
+				qualifiers = append(qualifiers, parseEnumQualifier(spec.EnumSpecifier))
+				inline, err = ctx.parseInlineEnum(spec.EnumSpecifier)
+			default:
+				qualifiers = append(qualifiers, spec.Token.SrcStr())
+			}
+		}
+
+		if err != nil {
+			return nil, "", nil, err
+		}
+	}
+
+	if len(qualifiers) == 0 {
+		return nil, "", inline, nil
+	}
+
+	lastIdx := len(qualifiers) - 1
+	if lastIdx == 0 {
+		return nil, qualifiers[lastIdx], inline, nil
+	}
+	return qualifiers[:lastIdx], qualifiers[lastIdx], inline, nil
+}
+
+// parseStructOrUnionQualifier parses the aggregate qualifier in case the
+// type is a fully qualified aggregate type. Anonymous aggregates only have
+// their kind as a qualifier.
+func parseStructOrUnionQualifier(spec *cc.StructOrUnionSpecifier) string {
+	qualifierKind := spec.StructOrUnion.Token.SrcStr()
+	qualifierName := spec.Token.SrcStr()
+	if qualifierName == "" {
+		return qualifierKind
+	}
+	return fmt.Sprintf("%s %s", qualifierKind, qualifierName)
+}
+
+// parseEnumQualifier parses the enum qualifier in case it is a fully
+// qualified enum type. Anonymous enums only have their kind as a qualifier.
+func parseEnumQualifier(spec *cc.EnumSpecifier) string {
+	qualifierKind := spec.Token.SrcStr()
+	qualifierName := spec.Token2.SrcStr()
+	if qualifierName == "" {
+		return qualifierKind
+	}
+	return fmt.Sprintf("%s %s", qualifierKind, qualifierName)
+}
+
+// parseInlineStructOrUnion parses a struct or union defined inline within a
+// field declaration. It returns nil if the specifier just refers to a type
+// defined elsewhere.
+func (ctx Context) parseInlineStructOrUnion(spec *cc.StructOrUnionSpecifier) (*Aggregate, error) {
+	if spec.Case != cc.StructOrUnionSpecifierDef {
+		return nil, nil
+	}
+
+	var inline Aggregate
+	if err := ctx.parseStructOrUnion(spec, &inline); err != nil {
+		return nil, err
+	}
+	return &inline, nil
+}
+
+// parseInlineEnum parses an enum defined inline within a field declaration.
+// It returns nil if the specifier just refers to a type defined elsewhere.
+func (ctx Context) parseInlineEnum(spec *cc.EnumSpecifier) (*Aggregate, error) {
+	if spec.Case != cc.EnumSpecifierDef {
+		return nil, nil
+	}
+
+	var inline Aggregate
+	if err := ctx.parseEnum(spec, &inline); err != nil {
+		return nil, err
+	}
+	return &inline, nil
+}
+
+// A FieldMeta struct contains information related to the parsed field. It is
+// populated differently based on which kind of field is encountered.
+type FieldMeta struct {
+	element    FieldKind
+	zeroLength bool
+	bitWidth   int
+	declarator Declarator
+}
+
+// parseName parses a struct declarator in search of the field name. At this
+// point in the parsing, it also extracts the kind for the Field which is
+// being parsed, and any other metadata that may be available within the
+// declarator, i.e. its derivations, the kind of the elements of arrays and
+// bit-field widths.
+func (ctx Context) parseName(structDecl *cc.StructDeclarator) (string, FieldMeta, FieldKind, error) {
+	// bit-fields carry their width after the (optional) declarator
+	if structDecl.Case == cc.StructDeclaratorBitField {
+		name, width, err := ctx.parseBitFieldName(structDecl)
+		return name, FieldMeta{bitWidth: width}, BitFieldKind, err
+	}
+
+	name, meta, kind, err := ctx.parseDeclarator(structDecl.Declarator)
+	if err != nil {
+		return "", FieldMeta{}, ValueKind, err
+	}
+
+	_, meta.declarator, err = ctx.parseDerivations(structDecl.Declarator)
+	return name, meta, kind, err
+}
+
+// parseDeclarator walks the passed declarator in search of the field name,
+// kind and metadata. Array dimensions are nested from the last to the first
+// one, while function pointers, and arrays of them, hold their name within
+// a parenthesized declarator, e.g. `int (*handlers[4])(int)`.
+func (ctx Context) parseDeclarator(decl *cc.Declarator) (string, FieldMeta, FieldKind, error) {
+	var (
+		direct  = decl.DirectDeclarator
+		dims    []int
+		unsized bool
+	)
+
+	for ; isArrayDeclarator(direct); direct = direct.DirectDeclarator {
+		dim := 0
+		unsized = direct.AssignmentExpression == nil
+		if !unsized {
+			var err error
+			if dim, err = ctx.arraySize(direct.AssignmentExpression); err != nil {
+				return "", FieldMeta{}, ValueKind, err
+			}
+		}
+		dims = append([]int{dim}, dims...)
+	}
+
+	isFunction := direct.Case == cc.DirectDeclaratorFuncParam ||
+		direct.Case == cc.DirectDeclaratorFuncIdent
+
+	switch {
+	case isFunction && direct.DirectDeclarator.Declarator == nil:
+		// a function type, only found in typedefs, e.g. `typedef void fn(int);`
+		return direct.DirectDeclarator.Token.SrcStr(), FieldMeta{}, FunctionPointerKind, nil
+	case isFunction:
+		name, meta, kind, err := ctx.parseDeclarator(direct.DirectDeclarator.Declarator)
+		if err != nil {
+			return "", FieldMeta{}, ValueKind, err
+		}
+
+		if kind == ArrayKind || kind == FlexibleArrayKind {
+			meta.element = FunctionPointerKind
+			return name, meta, kind, nil
+		}
+		return name, meta, FunctionPointerKind, nil
+	case direct.Case == cc.DirectDeclaratorDecl:
+		name, meta, kind, err := ctx.parseDeclarator(direct.Declarator)
+
+		// a pointer to an array is just a pointer, e.g. `int (*p)[4]`
+		if len(dims) != 0 {
+			return name, FieldMeta{}, PointerKind, err
+		}
+		return name, meta, kind, err
+	}
+
+	name := direct.Token.SrcStr()
+	meta, kind := arrayMeta(decl.Pointer, dims, unsized)
+	return name, meta, kind, nil
+}
+
+// parseAbstractDeclarator is the equivalent of parseDeclarator for the
+// abstract declarators found within type names, e.g. `int *[4]` or
+// `void (*)(int)` as used within sizeof expressions.
+func (ctx Context) parseAbstractDeclarator(decl *cc.AbstractDeclarator) (FieldMeta, FieldKind, error) {
+	var (
+		direct  = decl.DirectAbstractDeclarator
+		dims    []int
+		unsized bool
+	)
+
+	for ; direct != nil && isArrayAbstractDeclarator(direct); direct = direct.DirectAbstractDeclarator {
+		dim := 0
+		unsized = direct.AssignmentExpression == nil
+		if !unsized {
+			var err error
+			if dim, err = ctx.arraySize(direct.AssignmentExpression); err != nil {
+				return FieldMeta{}, ValueKind, err
+			}
+		}
+		dims = append([]int{dim}, dims...)
+	}
+
+	switch {
+	case direct == nil:
+	case direct.Case == cc.DirectAbstractDeclaratorFunc && direct.DirectAbstractDeclarator != nil:
+		meta, kind, err := ctx.parseAbstractDeclarator(direct.DirectAbstractDeclarator.AbstractDeclarator)
+		if err != nil {
+			return FieldMeta{}, ValueKind, err
+		}
+
+		if kind == ArrayKind || kind == FlexibleArrayKind {
+			meta.element = FunctionPointerKind
+			return meta, kind, nil
+		}
+		return meta, FunctionPointerKind, nil
+	case direct.Case == cc.DirectAbstractDeclaratorFunc:
+		return FieldMeta{}, ValueKind, fmt.Errorf("%w: function type", ErrExpr)
+	case direct.Case == cc.DirectAbstractDeclaratorDecl:
+		meta, kind, err := ctx.parseAbstractDeclarator(direct.AbstractDeclarator)
+
+		// a pointer to an array is just a pointer, e.g. `int (*)[4]`
+		if len(dims) != 0 {
+			return FieldMeta{}, PointerKind, err
+		}
+		return meta, kind, err
+	}
+
+	meta, kind := arrayMeta(decl.Pointer, dims, unsized)
+	return meta, kind, nil
+}
+
+// isArrayAbstractDeclarator checks whether the passed direct abstract
+// declarator declares an array, be it sized or not.
+func isArrayAbstractDeclarator(direct *cc.DirectAbstractDeclarator) bool {
+	switch direct.Case {
+	case cc.DirectAbstractDeclaratorArr, cc.DirectAbstractDeclaratorStaticArr,
+		cc.DirectAbstractDeclaratorArrStatic, cc.DirectAbstractDeclaratorArrStar:
+		return true
+	}
+	return false
+}
+
+// arrayMeta builds the metadata and the kind of a declarator, out of its
+// pointer part, and of its array dimensions, if any.
+func arrayMeta(ptr *cc.Pointer, dims []int, unsized bool) (FieldMeta, FieldKind) {
+	var (
+		meta FieldMeta
+		kind = ValueKind
+	)
+
+	if ptr != nil {
+		kind = PointerKind
+	}
+
+	switch {
+	case len(dims) != 0 && (unsized || dims[0] == 0):
+		meta.zeroLength = !unsized
+		meta.element = kind
+		kind = FlexibleArrayKind
+	case len(dims) != 0:
+		meta.element = kind
+		kind = ArrayKind
+	}
+	return meta, kind
+}
+
+// arraySize evaluates the size of an array dimension, which cannot be
+// negative.
+func (ctx Context) arraySize(expr cc.ExpressionNode) (int, error) {
+	size, err := ctx.evalInt(expr)
+	if err != nil {
+		return 0, err
+	}
+
+	if size < 0 {
+		return 0, fmt.Errorf("%w: negative array size %d", ErrExpr, size)
+	}
+	return size, nil
+}
+
+// isArrayDeclarator checks whether the passed direct declarator declares an
+// array, be it sized or not.
+func isArrayDeclarator(direct *cc.DirectDeclarator) bool {
+	switch direct.Case {
+	case cc.DirectDeclaratorArr, cc.DirectDeclaratorStaticArr,
+		cc.DirectDeclaratorArrStatic, cc.DirectDeclaratorStar:
+		return true
+	}
+	return false
+}
+
+// parseBitFieldName parses the struct declarator for a bit-field and returns
+// its name, alongside with its width. Unnamed bit-fields have an empty name.
+func (ctx Context) parseBitFieldName(structDecl *cc.StructDeclarator) (string, int, error) {
+	var name string
+	if structDecl.Declarator != nil {
+		name = structDecl.Declarator.DirectDeclarator.Token.SrcStr()
+	}
+
+	width, err := ctx.evalInt(structDecl.ConstantExpression)
+	return name, width, err
+}
+
+// getTypeSpecifier retrieves the type specifier of the passed declaration,
+// skipping any storage class specifier, e.g. typedef.
+func getTypeSpecifier(decl *cc.Declaration) *cc.TypeSpecifier {
+	specs := decl.DeclarationSpecifiers
+
+	// check the specifiers list, the type section contains the aggregate one
+	if specs.Case == cc.DeclarationSpecifiersStorage {
+		specs = specs.DeclarationSpecifiers
+	}
+	return specs.TypeSpecifier
+}
+
+// getTypedefToken extracts the typedef token from the passed declaration,
+// or returns nil if the typedef does not name the aggregate itself, but a
+// type derived from it, e.g. `typedef struct { int x; } *ptr_t;`.
+func getTypedefToken(decl *cc.Declaration) *cc.Token {
+	if decl.DeclarationSpecifiers.Case == cc.DeclarationSpecifiersStorage {
+		// anonymous typedef'd enum case: this is found somewhere else
+		var (
+			declList   = decl.InitDeclaratorList
+			initDecl   = declList.InitDeclarator
+			directDecl = initDecl.Declarator.DirectDeclarator
+		)
+
+		if initDecl.Declarator.Pointer != nil || directDecl.Case != cc.DirectDeclaratorIdent {
+			return nil
+		}
+		return &directDecl.Token
+	}
+	return &decl.InitDeclaratorList.Token
+}
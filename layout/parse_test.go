@@ -0,0 +1,1004 @@
+package layout
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/Abathargh/stropt/abi"
+	"modernc.org/cc/v4"
+)
+
+func TestGetName(t *testing.T) {
+	testCases := []struct {
+		test     Aggregate
+		expected []string
+	}{
+		{
+			Aggregate{Name: "struct s1", Typedef: "", Kind: StructKind},
+			[]string{"struct s1", "s1"},
+		},
+		{
+			Aggregate{Name: "", Typedef: "s2_t", Kind: StructKind},
+			[]string{"s2_t"},
+		},
+		{
+			Aggregate{Name: "struct s3", Typedef: "s3_t", Kind: StructKind},
+			[]string{"struct s3", "s3", "s3_t"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		names := GetAggregateNames(&testCase.test)
+		expected := testCase.expected
+
+		slices.Sort(names)
+		slices.Sort(expected)
+
+		if !slices.Equal(names, expected) {
+			t.Errorf("Names not matching, got %v, expected %v", names, expected)
+		}
+	}
+}
+
+func TestStructBasicTypes(t *testing.T) {
+	inlineChar := &Aggregate{
+		Kind:   StructKind,
+		Fields: []Field{Basic{TypeName: "char", Name: "x"}},
+	}
+
+	testCases := []struct {
+		test     string
+		expected map[string]Aggregate
+	}{
+		{
+			"struct test_struct { long a; };",
+			map[string]Aggregate{
+				"struct test_struct": {
+					Name:    "struct test_struct",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Basic{TypeName: "long", Name: "a"},
+					},
+				},
+			},
+		},
+		{
+			"union un { double d; float f; unsigned char uc; };",
+			map[string]Aggregate{
+				"union un": {
+					Name:    "union un",
+					Typedef: "",
+					Kind:    UnionKind,
+					Fields: []Field{
+						Basic{TypeName: "double", Name: "d"},
+						Basic{TypeName: "float", Name: "f"},
+						Basic{Qualifiers: []string{"unsigned"}, TypeName: "char", Name: "uc"},
+					},
+				},
+			},
+		},
+		{
+			"struct test_mul { int a; float b; double d; long long l; };",
+			map[string]Aggregate{
+				"struct test_mul": {
+					Name:    "struct test_mul",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Basic{TypeName: "int", Name: "a"},
+						Basic{TypeName: "float", Name: "b"},
+						Basic{TypeName: "double", Name: "d"},
+						Basic{Qualifiers: []string{"long"}, TypeName: "long", Name: "l"},
+					},
+				},
+			},
+		},
+		{
+			"struct test_ptr { int * a; int arr[100]; };",
+			map[string]Aggregate{
+				"struct test_ptr": {
+					Name:    "struct test_ptr",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Pointer{Basic{TypeName: "int", Name: "a", Declarator: Declarator{pointerTo()}}},
+						Array{Basic: Basic{TypeName: "int", Name: "arr", Declarator: Declarator{arrayOf(100)}}},
+					},
+				},
+			},
+		},
+		{
+			`typedef union {float f; int i; long ui; } un;
+			struct test_ptr { int * a; };`,
+			map[string]Aggregate{
+				"un": {
+					Name:    "",
+					Typedef: "un",
+					Kind:    UnionKind,
+					Fields: []Field{
+						Basic{TypeName: "float", Name: "f"},
+						Basic{TypeName: "int", Name: "i"},
+						Basic{TypeName: "long", Name: "ui"},
+					},
+				},
+				"struct test_ptr": {
+					Name:    "struct test_ptr",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Pointer{Basic{TypeName: "int", Name: "a", Declarator: Declarator{pointerTo()}}},
+					},
+				},
+			},
+		},
+		{
+			"struct test_ptr { const int * a; const int * const b; };",
+			map[string]Aggregate{
+				"struct test_ptr": {
+					Name:    "struct test_ptr",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Pointer{Basic{Qualifiers: []string{"const"}, TypeName: "int", Name: "a", Declarator: Declarator{pointerTo()}}},
+						Pointer{Basic{Qualifiers: []string{"const"}, TypeName: "int", Name: "b", Declarator: Declarator{pointerTo("const")}}},
+					},
+				},
+			},
+		},
+		{
+			`struct inner { int a; };
+			struct test_inner { int a1; volatile struct inner a2; };`,
+			map[string]Aggregate{
+				"struct inner": {
+					Name:    "struct inner",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Basic{TypeName: "int", Name: "a"},
+					},
+				},
+				"struct test_inner": {
+					Name:    "struct test_inner",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Basic{TypeName: "int", Name: "a1"},
+						Basic{Qualifiers: []string{"volatile"}, TypeName: "struct inner", Name: "a2"},
+					}},
+			},
+		},
+		{
+			`enum example { test, prova, ssa };
+			struct test_inner { int a1; enum example ex; };`,
+			map[string]Aggregate{
+				"enum example": {
+					Name:    "enum example",
+					Typedef: "",
+					Kind:    EnumKind,
+					Fields: []Field{
+						EnumEntry{Name: "test", Value: 0},
+						EnumEntry{Name: "prova", Value: 1},
+						EnumEntry{Name: "ssa", Value: 2},
+					},
+				},
+				"struct test_inner": {
+					Name:    "struct test_inner",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Basic{TypeName: "int", Name: "a1"},
+						Basic{TypeName: "enum example", Name: "ex"},
+					},
+				},
+			},
+		},
+		{
+			"enum vals { v1 = 3, v2, v3 = v1 << 4, v4 = -1, v5, v6 = 0x80000000 };",
+			map[string]Aggregate{
+				"enum vals": {
+					Name: "enum vals",
+					Kind: EnumKind,
+					Fields: []Field{
+						EnumEntry{Name: "v1", Value: 3},
+						EnumEntry{Name: "v2", Value: 4},
+						EnumEntry{Name: "v3", Value: 48},
+						EnumEntry{Name: "v4", Value: -1},
+						EnumEntry{Name: "v5", Value: 0},
+						EnumEntry{Name: "v6", Value: 0x80000000},
+					},
+				},
+			},
+		},
+		{
+			"typedef struct exs { float disc; double d; char data[50]; } example_t;",
+			map[string]Aggregate{
+				"example_t": {
+					Name:    "struct exs",
+					Typedef: "example_t",
+					Kind:    StructKind,
+					Fields: []Field{
+						Basic{TypeName: "float", Name: "disc"},
+						Basic{TypeName: "double", Name: "d"},
+						Array{Basic: Basic{TypeName: "char", Name: "data", Declarator: Declarator{arrayOf(50)}}},
+					},
+				},
+			},
+		},
+		{
+			"typedef union { double d; char c; } example_u;",
+			map[string]Aggregate{
+				"example_u": {
+					Name:    "",
+					Typedef: "example_u",
+					Kind:    UnionKind,
+					Fields: []Field{
+						Basic{TypeName: "double", Name: "d"},
+						Basic{TypeName: "char", Name: "c"},
+					},
+				},
+			},
+		},
+		{
+			"typedef union exu { double d; char c; } example_u;",
+			map[string]Aggregate{
+				"example_u": {
+					Name:    "union exu",
+					Typedef: "example_u",
+					Kind:    UnionKind,
+					Fields: []Field{
+						Basic{TypeName: "double", Name: "d"},
+						Basic{TypeName: "char", Name: "c"},
+					},
+				},
+			},
+		},
+		{
+			"typedef enum { test, prova, versuch } example_e;",
+			map[string]Aggregate{
+				"example_e": {
+					Name:    "",
+					Typedef: "example_e",
+					Kind:    EnumKind,
+					Fields: []Field{
+						EnumEntry{Name: "test", Value: 0},
+						EnumEntry{Name: "prova", Value: 1},
+						EnumEntry{Name: "versuch", Value: 2},
+					},
+				},
+			},
+		},
+		{
+			"typedef enum exe { test, prova, versuch } example_e;",
+			map[string]Aggregate{
+				"example_e": {
+					Name:    "enum exe",
+					Typedef: "example_e",
+					Kind:    EnumKind,
+					Fields: []Field{
+						EnumEntry{Name: "test", Value: 0},
+						EnumEntry{Name: "prova", Value: 1},
+						EnumEntry{Name: "versuch", Value: 2},
+					},
+				},
+			},
+		},
+		{
+			"typedef struct { int (*fptr)(int, float); } fptr_t;",
+			map[string]Aggregate{
+				"fptr_t": {
+					Name:    "",
+					Typedef: "fptr_t",
+					Kind:    StructKind,
+					Fields: []Field{
+						FuncPointer{
+							ReturnType: "int",
+							Name:       "fptr",
+							Declarator: Declarator{pointerTo(), functionOf("int", "float")},
+						},
+					},
+				},
+			},
+		},
+		{
+			`struct inner { int a; };
+			struct test_inner { int a1; const struct inner * const a2; };`,
+			map[string]Aggregate{
+				"struct inner": {
+					Name:    "struct inner",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Basic{TypeName: "int", Name: "a"},
+					},
+				},
+				"struct test_inner": {
+					Name:    "struct test_inner",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Basic{TypeName: "int", Name: "a1"},
+						Pointer{Basic{Qualifiers: []string{"const"}, TypeName: "struct inner", Name: "a2", Declarator: Declarator{pointerTo("const")}}},
+					}},
+			},
+		},
+		{
+			`struct sub { const char * foo; };
+			struct aos { struct sub arr[100]; };`,
+			map[string]Aggregate{
+				"struct sub": {
+					Name:    "struct sub",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Pointer{Basic{Qualifiers: []string{"const"}, TypeName: "char", Name: "foo", Declarator: Declarator{pointerTo()}}},
+					},
+				},
+				"struct aos": {
+					Name:    "struct aos",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "struct sub", Name: "arr", Declarator: Declarator{arrayOf(100)}}},
+					},
+				},
+			},
+		},
+		{
+			`#define ARR_SIZE 100
+			struct def { char arr[ARR_SIZE]; };`,
+			map[string]Aggregate{
+				"struct def": {
+					Name:    "struct def",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "char", Name: "arr", Declarator: Declarator{arrayOf(100)}}},
+					},
+				},
+			},
+		},
+		{
+			`#define ARR_SIZE (100)
+			struct def { char arr[ARR_SIZE]; };`,
+			map[string]Aggregate{
+				"struct def": {
+					Name:    "struct def",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "char", Name: "arr", Declarator: Declarator{arrayOf(100)}}},
+					},
+				},
+			},
+		},
+		{
+			`
+			typedef struct int_cont {
+				volatile int a;
+				int * b;
+				const int * const c;
+				} int_cont_t;`,
+			map[string]Aggregate{
+				"int_cont_t": {
+					Name:    "struct int_cont",
+					Typedef: "int_cont_t",
+					Kind:    StructKind,
+					Fields: []Field{
+						Basic{Qualifiers: []string{"volatile"}, TypeName: "int", Name: "a"},
+						Pointer{Basic{TypeName: "int", Name: "b", Declarator: Declarator{pointerTo()}}},
+						Pointer{Basic{Qualifiers: []string{"const"}, TypeName: "int", Name: "c", Declarator: Declarator{pointerTo("const")}}},
+					},
+				},
+			},
+		},
+		{
+			"struct expr1 { char arr[sizeof(double)]};",
+			map[string]Aggregate{
+				"struct expr1": {
+					Name:    "struct expr1",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "char", Name: "arr", Declarator: Declarator{arrayOf(8)}}},
+					},
+				},
+			},
+		},
+		{
+			"struct expr2 { char arr[1 + 1]};",
+			map[string]Aggregate{
+				"struct expr2": {
+					Name:    "struct expr2",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "char", Name: "arr", Declarator: Declarator{arrayOf(2)}}},
+					},
+				},
+			},
+		},
+		{
+			"struct expr3 { char arr[1 + 1 + 9]};",
+			map[string]Aggregate{
+				"struct expr3": {
+					Name:    "struct expr3",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "char", Name: "arr", Declarator: Declarator{arrayOf(11)}}},
+					},
+				},
+			},
+		},
+		{
+			"struct expr4 { char arr[6 - 1]};",
+			map[string]Aggregate{
+				"struct expr4": {
+					Name:    "struct expr4",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "char", Name: "arr", Declarator: Declarator{arrayOf(5)}}},
+					},
+				},
+			},
+		},
+		{
+			"struct expr5 { char arr[6 - 1 + sizeof(double) - sizeof(signed int)]};",
+			map[string]Aggregate{
+				"struct expr5": {
+					Name:    "struct expr5",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "char", Name: "arr", Declarator: Declarator{arrayOf(9)}}},
+					},
+				},
+			},
+		},
+		{
+			"struct expr6 { char arr[sizeof(double) + 1]};",
+			map[string]Aggregate{
+				"struct expr6": {
+					Name:    "struct expr6",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "char", Name: "arr", Declarator: Declarator{arrayOf(9)}}},
+					},
+				},
+			},
+		},
+		{
+			"struct expr7 { char arr[sizeof(double) - 1]};",
+			map[string]Aggregate{
+				"struct expr7": {
+					Name:    "struct expr7",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "char", Name: "arr", Declarator: Declarator{arrayOf(7)}}},
+					},
+				},
+			},
+		},
+		{
+			"struct expr8 { char arr[2 * 3]};",
+			map[string]Aggregate{
+				"struct expr8": {
+					Name:    "struct expr8",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "char", Name: "arr", Declarator: Declarator{arrayOf(6)}}},
+					},
+				},
+			},
+		},
+		{
+			"struct expr9 { char arr[15 / 3]};",
+			map[string]Aggregate{
+				"struct expr9": {
+					Name:    "struct expr9",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "char", Name: "arr", Declarator: Declarator{arrayOf(5)}}},
+					},
+				},
+			},
+		},
+		{
+			"struct expr10 { char arr[6 % 5]};",
+			map[string]Aggregate{
+				"struct expr10": {
+					Name:    "struct expr10",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "char", Name: "arr", Declarator: Declarator{arrayOf(1)}}},
+					},
+				},
+			},
+		},
+		{
+			"struct expr11 { char arr[(7 % 4) * sizeof(double) - 2 + (sizeof(int) / 4)]};",
+			map[string]Aggregate{
+				"struct expr11": {
+					Name:    "struct expr11",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{Basic: Basic{TypeName: "char", Name: "arr", Declarator: Declarator{arrayOf(23)}}},
+					},
+				},
+			},
+		},
+		{
+			"struct bf1 { unsigned flags : 3; unsigned : 2; int : 0; _Bool on : 1; };",
+			map[string]Aggregate{
+				"struct bf1": {
+					Name:    "struct bf1",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						BitField{Basic{TypeName: "unsigned", Name: "flags"}, 3},
+						BitField{Basic{TypeName: "unsigned", Name: ""}, 2},
+						BitField{Basic{TypeName: "int", Name: ""}, 0},
+						BitField{Basic{TypeName: "_Bool", Name: "on"}, 1},
+					},
+				},
+			},
+		},
+		{
+			"struct bf2 { unsigned char mode : sizeof(short) * 2; };",
+			map[string]Aggregate{
+				"struct bf2": {
+					Name:    "struct bf2",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						BitField{Basic{Qualifiers: []string{"unsigned"}, TypeName: "char", Name: "mode"}, 4},
+					},
+				},
+			},
+		},
+		{
+			`struct pk1 { char c; int i __attribute__((packed)); }
+			__attribute__((packed, aligned(4)));`,
+			map[string]Aggregate{
+				"struct pk1": {
+					Name:      "struct pk1",
+					Typedef:   "",
+					Kind:      StructKind,
+					Packed:    true,
+					Alignment: 4,
+					Fields: []Field{
+						Basic{TypeName: "char", Name: "c"},
+						Basic{TypeName: "int", Name: "i", Packed: true},
+					},
+				},
+			},
+		},
+		{
+			`typedef struct { __attribute__((packed)) const int x; char c; }
+			__attribute__((__packed__)) pk2_t;`,
+			map[string]Aggregate{
+				"pk2_t": {
+					Name:    "",
+					Typedef: "pk2_t",
+					Kind:    StructKind,
+					Packed:  true,
+					Fields: []Field{
+						Basic{Qualifiers: []string{"const"}, TypeName: "int", Name: "x",
+							Packed: true},
+						Basic{TypeName: "char", Name: "c"},
+					},
+				},
+			},
+		},
+		{
+			`struct al1 { char c; _Alignas(64) int x; _Alignas(double) char d;
+			int a[3] __attribute__((aligned(16))); char * p __attribute__((aligned)); };`,
+			map[string]Aggregate{
+				"struct al1": {
+					Name:    "struct al1",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Basic{TypeName: "char", Name: "c"},
+						Basic{TypeName: "int", Name: "x", Alignment: 64},
+						Basic{TypeName: "char", Name: "d", Alignment: 8},
+						Array{Basic: Basic{TypeName: "int", Name: "a", Alignment: 16, Declarator: Declarator{arrayOf(3)}}},
+						Pointer{Basic{TypeName: "char", Name: "p", Alignment: 16, Declarator: Declarator{pointerTo()}}},
+					},
+				},
+			},
+		},
+		{
+			`struct ar1 { int m[4][8]; char *names[16]; const char * const cp[2];
+			int (*handlers[4])(int, char); int (*pa)[4]; };`,
+			map[string]Aggregate{
+				"struct ar1": {
+					Name:    "struct ar1",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Array{
+							Basic: Basic{TypeName: "int", Name: "m", Declarator: Declarator{arrayOf(4), arrayOf(8)}},
+						},
+						Array{
+							Basic:   Basic{TypeName: "char", Name: "names", Declarator: Declarator{arrayOf(16), pointerTo()}},
+							Element: PointerKind,
+						},
+						Array{
+							Basic: Basic{
+								Qualifiers: []string{"const"}, TypeName: "char", Name: "cp",
+								Declarator: Declarator{arrayOf(2), pointerTo("const")},
+							},
+							Element: PointerKind,
+						},
+						Array{
+							Basic: Basic{
+								TypeName: "int", Name: "handlers",
+								Declarator: Declarator{arrayOf(4), pointerTo(), functionOf("int", "char")},
+							},
+							Element: FunctionPointerKind,
+						},
+						Pointer{Basic{TypeName: "int", Name: "pa", Declarator: Declarator{pointerTo(), arrayOf(4)}}},
+					},
+				},
+			},
+		},
+		{
+			`struct fl1 { int n; char *p[0]; unsigned char data[][4]; };`,
+			map[string]Aggregate{
+				"struct fl1": {
+					Name:    "struct fl1",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Basic{TypeName: "int", Name: "n"},
+						FlexibleArray{Array{
+							Basic:   Basic{TypeName: "char", Name: "p", Declarator: Declarator{arrayOf(0), pointerTo()}},
+							Element: PointerKind,
+						}, true},
+						FlexibleArray{Array{
+							Basic: Basic{
+								Qualifiers: []string{"unsigned"}, TypeName: "char", Name: "data",
+								Declarator: Declarator{unsized(), arrayOf(4)},
+							},
+						}, false},
+					},
+				},
+			},
+		},
+		{
+			`struct md1 { int a, b, *c; const char *s, t[4];
+			unsigned f1 : 3, : 0, f2 : 5; int (*cb)(int), d; struct { char x; } p, q; };`,
+			map[string]Aggregate{
+				"struct md1": {
+					Name:    "struct md1",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Basic{TypeName: "int", Name: "a"},
+						Basic{TypeName: "int", Name: "b"},
+						Pointer{Basic{TypeName: "int", Name: "c", Declarator: Declarator{pointerTo()}}},
+						Pointer{Basic{Qualifiers: []string{"const"}, TypeName: "char", Name: "s", Declarator: Declarator{pointerTo()}}},
+						Array{Basic: Basic{Qualifiers: []string{"const"}, TypeName: "char", Name: "t", Declarator: Declarator{arrayOf(4)}}},
+						BitField{Basic{TypeName: "unsigned", Name: "f1"}, 3},
+						BitField{Basic{TypeName: "unsigned"}, 0},
+						BitField{Basic{TypeName: "unsigned", Name: "f2"}, 5},
+						FuncPointer{
+							ReturnType: "int",
+							Name:       "cb",
+							Declarator: Declarator{pointerTo(), functionOf("int")},
+						},
+						Basic{TypeName: "int", Name: "d"},
+						Basic{TypeName: "struct", Name: "p", Inline: inlineChar},
+						Basic{TypeName: "struct", Name: "q", Inline: inlineChar},
+					},
+				},
+			},
+		},
+		{
+			`struct in1 { char tag; union { int i; float f; };
+			struct { int x; int y; } pos; struct in2 { char c; } named; };`,
+			map[string]Aggregate{
+				"struct in1": {
+					Name:    "struct in1",
+					Typedef: "",
+					Kind:    StructKind,
+					Fields: []Field{
+						Basic{TypeName: "char", Name: "tag"},
+						Basic{TypeName: "union", Inline: &Aggregate{
+							Kind: UnionKind,
+							Fields: []Field{
+								Basic{TypeName: "int", Name: "i"},
+								Basic{TypeName: "float", Name: "f"},
+							},
+						}},
+						Basic{TypeName: "struct", Name: "pos", Inline: &Aggregate{
+							Kind: StructKind,
+							Fields: []Field{
+								Basic{TypeName: "int", Name: "x"},
+								Basic{TypeName: "int", Name: "y"},
+							},
+						}},
+						Basic{TypeName: "struct in2", Name: "named", Inline: &Aggregate{
+							Name:   "struct in2",
+							Kind:   StructKind,
+							Fields: []Field{Basic{TypeName: "char", Name: "c"}},
+						}},
+					},
+				},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		var (
+			aggregates []Aggregate
+			ctx        = NewContext(abi.DefaultTarget())
+			ast        = initAst(testCase.test)
+		)
+
+		for l := ast.TranslationUnit; l != nil; l = l.TranslationUnit {
+			ed := l.ExternalDeclaration
+			switch ed.Case {
+			case cc.ExternalDeclarationDecl:
+				switch ed.Declaration.DeclarationSpecifiers.Type().(type) {
+				case *cc.StructType, *cc.UnionType, *cc.EnumType:
+					agg, err := ctx.ParseAggregate(ed.Declaration)
+					if err != nil {
+						continue
+					}
+					aggregates = append(aggregates, *agg)
+				}
+			}
+		}
+
+		count := 0
+		for _, aggregate := range aggregates {
+			name := getAggregateName(aggregate)
+
+			aggCase, ok := testCase.expected[name]
+			if !ok {
+				continue
+			}
+
+			if msg, equal := aggregatesEqual(aggregate, aggCase); !equal {
+				t.Errorf("test: %s\n%s", testCase.test, msg)
+				continue
+			}
+			count++
+		}
+
+		if count != len(testCase.expected) {
+			t.Errorf("test: %s\nexpected %d aggregates, got %d\n complete list %+v",
+				testCase.test, len(testCase.expected), count, aggregates)
+		}
+	}
+}
+
+func TestParseTypedef(t *testing.T) {
+	const test = `typedef unsigned int u32, *u32_ptr; typedef const u32 cu32;
+	typedef char name_t[12]; typedef int (*cb_t)(int); typedef void fn_t(char);
+	typedef struct node node_t;`
+
+	expected := map[string]Field{
+		"u32":     Basic{Qualifiers: []string{"unsigned"}, TypeName: "int", Name: "u32"},
+		"u32_ptr": Pointer{Basic{Qualifiers: []string{"unsigned"}, TypeName: "int", Name: "u32_ptr", Declarator: Declarator{pointerTo()}}},
+		"cu32":    Basic{Qualifiers: []string{"const"}, TypeName: "u32", Name: "cu32"},
+		"name_t":  Array{Basic: Basic{TypeName: "char", Name: "name_t", Declarator: Declarator{arrayOf(12)}}},
+		"cb_t": FuncPointer{
+			ReturnType: "int", Name: "cb_t",
+			Declarator: Declarator{pointerTo(), functionOf("int")},
+		},
+		"fn_t": FuncPointer{
+			ReturnType: "void", Name: "fn_t",
+			Declarator: Declarator{functionOf("char")},
+		},
+		"node_t": Basic{TypeName: "struct node", Name: "node_t"},
+	}
+
+	var (
+		typedefs = make(map[string]Field)
+		ctx      = NewContext(abi.DefaultTarget())
+		ast      = initAst(test)
+	)
+
+	for l := ast.TranslationUnit; l != nil; l = l.TranslationUnit {
+		ed := l.ExternalDeclaration
+		if ed.Case != cc.ExternalDeclarationDecl || !IsTypedef(ed.Declaration) {
+			continue
+		}
+
+		if IsAggregateDefinition(ed.Declaration) {
+			t.Errorf("unexpected aggregate definition: %s", ed.Declaration.Position())
+		}
+
+		parsed, err := ctx.ParseTypedef(ed.Declaration)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// skip the typedefs predefined by the parser
+		for name, typedef := range parsed {
+			if _, isExpected := expected[name]; isExpected {
+				typedefs[name] = typedef
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(typedefs, expected) {
+		t.Errorf("different typedefs, got %+v - %+v", typedefs, expected)
+	}
+}
+
+func TestDirectives(t *testing.T) {
+	const test = `struct d1 {
+		unsigned magic;   /* stropt: pin */
+		// stropt: group hot, before len
+		char flags;
+		int len;          // not a directive
+		char kind;        // stropt: group hot
+		struct {
+			int x;        /* stropt: pin 0 */
+		} in;             // stropt: after flags
+		char a, b;        // stropt: prefix
+	};`
+
+	expected := map[string][]string{
+		"magic": {"pin"},
+		"flags": {"group hot", "before len"},
+		"kind":  {"group hot"},
+		"in":    {"after flags"},
+		"a":     {"prefix"},
+		"b":     {"prefix"},
+	}
+
+	structs, err := ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	agg, _ := structs.Lookup("struct d1")
+	if !reflect.DeepEqual(agg.Directives, expected) {
+		t.Errorf("Expected directives %v: got %v", expected, agg.Directives)
+	}
+
+	inner := InlineAggregates(agg)[0]
+	if !reflect.DeepEqual(inner.Directives, map[string][]string{"x": {"pin 0"}}) {
+		t.Errorf("Expected the directives of the inner aggregate: got %v", inner.Directives)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	const test = "struct s1 { char a, *b; union { int i; } ; int :3; };"
+
+	structs, err := ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	agg, _ := structs.Lookup("struct s1")
+	if agg.Definition == nil {
+		t.Fatalf("Expected the definition of %s to be found", test)
+	}
+
+	text := func(span Span) string { return test[span.Start:span.End] }
+	if body := text(agg.Definition.Body); body != " char a, *b; union { int i; } ; int :3; " {
+		t.Errorf("Unexpected body: %q", body)
+	}
+
+	expected := []struct {
+		decl        string
+		specifiers  string
+		declarators []string
+		fields      []int
+	}{
+		{"char a, *b;", "char ", []string{"a", "*b"}, []int{0, 1}},
+		{"union { int i; } ;", "union { int i; } ", nil, []int{2}},
+		{"int :3;", "int ", []string{":3"}, []int{3}},
+	}
+
+	if len(agg.Definition.Declarations) != len(expected) {
+		t.Fatalf("Expected %d declarations: got %d", len(expected), len(agg.Definition.Declarations))
+	}
+
+	for idx, decl := range agg.Definition.Declarations {
+		var declarators []string
+		for _, span := range decl.Declarators {
+			declarators = append(declarators, text(span))
+		}
+
+		exp := expected[idx]
+		if text(decl.Span) != exp.decl || text(decl.Specifiers) != exp.specifiers ||
+			!slices.Equal(declarators, exp.declarators) || !slices.Equal(decl.Fields, exp.fields) {
+			t.Errorf("Expected %q %q %q %v: got %q %q %q %v", exp.decl, exp.specifiers,
+				exp.declarators, exp.fields, text(decl.Span), text(decl.Specifiers),
+				declarators, decl.Fields)
+		}
+	}
+}
+
+func initAst(data string) *cc.AST {
+	config, _ := cc.NewConfig(runtime.GOOS, runtime.GOARCH)
+
+	srcs := []cc.Source{
+		{Name: "<predefined>", Value: config.Predefined},
+		{Name: "<builtin>", Value: cc.Builtin},
+		{Name: "", Value: data},
+	}
+
+	ast, _ := cc.Translate(config, srcs)
+	return ast
+}
+
+func getAggregateName(agg Aggregate) string {
+	if agg.Typedef != "" {
+		return agg.Typedef
+	}
+	return agg.Name
+}
+
+func aggregatesEqual(a1, a2 Aggregate) (string, bool) {
+	if a1.Name != a2.Name {
+		return fmt.Sprintf("different names, got %q - %q", a1.Name, a2.Name), false
+	}
+
+	if a1.Typedef != a2.Typedef {
+		return fmt.Sprintf("different typedefs, got %q - %q",
+			a1.Typedef, a2.Typedef), false
+	}
+
+	if a1.Kind != a2.Kind {
+		return fmt.Sprintf("different names, got %d - %d", a1.Kind, a2.Kind), false
+	}
+
+	if a1.Packed != a2.Packed || a1.Alignment != a2.Alignment {
+		return fmt.Sprintf("different attributes, got %t/%d - %t/%d", a1.Packed,
+			a1.Alignment, a2.Packed, a2.Alignment), false
+	}
+
+	if len(a1.Fields) != len(a2.Fields) {
+		return fmt.Sprintf("different field num, got %+v - %+v", a1.Fields,
+			a2.Fields), false
+	}
+
+	for idx := range len(a1.Fields) {
+		if !reflect.DeepEqual(a1.Fields[idx], a2.Fields[idx]) {
+			return fmt.Sprintf("different fields at index %d, got %+v - %+v",
+				idx, a1.Fields[idx], a2.Fields[idx]), false
+		}
+	}
+	return "", true
+}
+
+// pointerTo, arrayOf, unsized and functionOf build the derivations of the
+// declarators expected within the tests.
+func pointerTo(qualifiers ...string) Derivation {
+	return Derivation{Kind: PointerDerivation, Qualifiers: qualifiers}
+}
+
+func arrayOf(length int) Derivation {
+	return Derivation{Kind: ArrayDerivation, Length: length}
+}
+
+func unsized() Derivation {
+	return Derivation{Kind: ArrayDerivation, Unsized: true}
+}
+
+func functionOf(args ...string) Derivation {
+	var params []Parameter
+	for _, arg := range args {
+		params = append(params, Parameter{TypeName: arg})
+	}
+	return Derivation{Kind: FunctionDerivation, Parameters: params}
+}
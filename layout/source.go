@@ -0,0 +1,140 @@
+package layout
+
+import (
+	"strings"
+
+	"modernc.org/cc/v4"
+)
+
+// A Span locates a piece of text within a source file, as the offsets in
+// bytes of its first character and of the one following its last.
+type Span struct {
+	File  string
+	Start int
+	End   int
+}
+
+// Contains reports whether the passed span lies within s.
+func (s Span) Contains(other Span) bool {
+	return s.File == other.File && s.Start <= other.Start && other.End <= s.End
+}
+
+// A Definition locates the body of a struct or union within its source, so
+// that it can be rewritten: Body spans the text between its braces, while
+// Declarations locates each of its field declarations, in order.
+type Definition struct {
+	Body         Span
+	Declarations []Declaration
+}
+
+// A Declaration locates a field declaration, which may declare multiple
+// fields sharing the same type, e.g. `char a, *b;`. Span covers the whole
+// declaration, up to its semicolon, Specifiers the type shared by its fields,
+// e.g. `char `, and Declarators each of them, e.g. `*b`. Anonymous members
+// have no declarator. Fields holds the indexes of the fields declared, within
+// the ones of the aggregate.
+type Declaration struct {
+	Span        Span
+	Specifiers  Span
+	Declarators []Span
+	Fields      []int
+}
+
+// definition locates the passed struct or union body, and the passed field
+// declarations within it, each of them declaring the passed amount of
+// fields. Nothing is returned if any of them cannot be found within the
+// source text, e.g. when produced by a macro expansion.
+func (ctx Context) definition(spec *cc.StructOrUnionSpecifier,
+	decls []*cc.StructDeclaration, fields [][]Field) *Definition {
+	if ctx.text == nil {
+		return nil
+	}
+
+	var (
+		lbrace = spec.Token2.Position()
+		rbrace = spec.Token3.Position()
+	)
+
+	text, ok := ctx.text.file(lbrace.Filename)
+	if !ok || !ctx.text.holds(spec.Token2) || !ctx.text.holds(spec.Token3) ||
+		lbrace.Filename != rbrace.Filename || lbrace.Offset >= rbrace.Offset {
+		return nil
+	}
+
+	var (
+		def  = &Definition{Body: Span{lbrace.Filename, lbrace.Offset + 1, rbrace.Offset}}
+		prev = def.Body.Start
+		idx  = 0
+	)
+
+	for declIdx, decl := range decls {
+		if len(fields[declIdx]) == 0 {
+			continue
+		}
+
+		var (
+			start = decl.Position()
+			semi  = decl.Token.Position()
+		)
+
+		if start.Filename != lbrace.Filename || semi.Filename != lbrace.Filename ||
+			start.Offset < prev || semi.Offset >= def.Body.End || !ctx.text.holds(decl.Token) {
+			return nil
+		}
+
+		declaration := Declaration{Span: Span{lbrace.Filename, start.Offset, semi.Offset + 1}}
+		for range fields[declIdx] {
+			declaration.Fields = append(declaration.Fields, idx)
+			idx++
+		}
+
+		var starts []int
+		for list := decl.StructDeclaratorList; list != nil; list = list.StructDeclaratorList {
+			pos := list.StructDeclarator.Position()
+			if pos.Filename != lbrace.Filename || pos.Offset < start.Offset || pos.Offset > semi.Offset {
+				return nil
+			}
+			starts = append(starts, pos.Offset)
+		}
+
+		// each declarator ends where the next one starts, before the comma
+		declaration.Specifiers = Span{lbrace.Filename, start.Offset, semi.Offset}
+		for pos, begin := range starts {
+			end := semi.Offset
+			if pos+1 < len(starts) {
+				end = strings.LastIndexByte(text[:starts[pos+1]], ',')
+			}
+
+			if end < begin {
+				return nil
+			}
+
+			end = begin + len(strings.TrimRight(text[begin:end], " \t\r\n"))
+			declaration.Declarators = append(declaration.Declarators,
+				Span{lbrace.Filename, begin, end})
+		}
+
+		if len(starts) != 0 {
+			if len(starts) != len(fields[declIdx]) {
+				return nil
+			}
+			declaration.Specifiers.End = starts[0]
+		}
+
+		def.Declarations = append(def.Declarations, declaration)
+		prev = semi.Offset + 1
+	}
+	return def
+}
+
+// holds checks whether the passed token can be found within the source text
+// at its position.
+func (set sourceSet) holds(tok cc.Token) bool {
+	pos := tok.Position()
+
+	text, ok := set.file(pos.Filename)
+	if !ok || pos.Offset < 0 || pos.Offset+len(tok.Src()) > len(text) {
+		return false
+	}
+	return text[pos.Offset:pos.Offset+len(tok.Src())] == tok.SrcStr()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Abathargh/stropt/layout"
+	"github.com/Abathargh/stropt/report"
+)
+
+var (
+	ErrLock  = errors.New("cannot use the lockfile")
+	ErrDrift = errors.New("the layout drifted from the lockfile")
+)
+
+// writeLock writes the layout of the aggregates identified by the passed
+// names within the passed source, or of every aggregate if no name is
+// passed, to the lockfile passed with -lock, skipping the ones that cannot
+// be resolved with a warning. The lockfile is a stropt-layout document,
+// encoded as JSON if its name ends with .json, or as YAML otherwise.
+func writeLock(fname, cont string, names []string, opts options) {
+	aggregates, err := layout.ExtractAggregates(fname, cont, opts.compiler, opts.target)
+	if err != nil {
+		logError(err)
+	}
+
+	skipErrors := names == nil
+	if skipErrors {
+		for _, aggregate := range aggregates.Aggregates() {
+			names = append(names, layout.GetAggregateNames(aggregate)[0])
+		}
+	}
+
+	doc := report.New()
+	for _, name := range names {
+		aggReport, err := newReportEntry(aggregates, name, opts)
+		if err != nil && skipErrors {
+			logWarning(err)
+			continue
+		} else if err != nil {
+			logError(err)
+		}
+		doc.Aggregates = append(doc.Aggregates, aggReport)
+	}
+
+	lockfile, err := os.Create(opts.lock)
+	if err != nil {
+		logError(fmt.Errorf("%w: %w", ErrLock, err))
+	}
+
+	encode := report.EncodeYAML
+	if strings.EqualFold(filepath.Ext(opts.lock), ".json") {
+		encode = report.EncodeJSON
+	}
+
+	err = encode(lockfile, doc)
+	if closeErr := lockfile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		logError(fmt.Errorf("%w: %s: %w", ErrLock, opts.lock, err))
+	}
+}
+
+// checkLock re-resolves the aggregates identified by the passed names within
+// the passed source, or every aggregate within the lockfile passed with
+// -check if no name is passed, and compares their layout with the locked
+// one. The changes are reported as with -compare, and the program exits
+// with an error if the layout of any of them drifted.
+func checkLock(fname, cont string, names []string, opts options) {
+	locked, err := readLock(opts.check)
+	if err != nil {
+		logError(err)
+	}
+
+	if names == nil {
+		for _, entry := range locked.Aggregates {
+			names = append(names, entry.Name)
+		}
+	}
+
+	aggregates, err := layout.ExtractAggregates(fname, cont, opts.compiler, opts.target)
+	if err != nil {
+		logError(err)
+	}
+
+	var (
+		diffs   = make([]report.Diff, 0, len(names))
+		drifted []string
+	)
+
+	for _, name := range names {
+		diff, err := checkAggregate(aggregates, locked, name)
+		if err != nil {
+			logError(err)
+		}
+
+		if diff.Status != report.StatusUnchanged {
+			drifted = append(drifted, name)
+		}
+		diffs = append(diffs, diff)
+	}
+
+	switch {
+	case opts.format != formatTable:
+		doc := report.New()
+		doc.Diffs = diffs
+		if err := encodeReport(doc, opts.format); err != nil {
+			logError(err)
+		}
+	case opts.bare:
+		for _, diff := range diffs {
+			printDiff(diff, true)
+		}
+	default:
+		for _, diff := range diffs {
+			if diff.Status != report.StatusUnchanged {
+				printDiff(diff, false)
+			}
+		}
+		fmt.Printf("%d of %d aggregates match the lockfile %s\n",
+			len(diffs)-len(drifted), len(diffs), opts.check)
+	}
+
+	if len(drifted) != 0 {
+		logError(fmt.Errorf("%w: %s", ErrDrift, strings.Join(drifted, ", ")))
+	}
+}
+
+// readLock reads and decodes the passed lockfile.
+func readLock(path string) (report.Report, error) {
+	lockfile, err := os.Open(path)
+	if err != nil {
+		return report.Report{}, fmt.Errorf("%w: %w", ErrLock, err)
+	}
+	defer lockfile.Close()
+
+	locked, err := report.Decode(lockfile)
+	if err != nil {
+		return report.Report{}, fmt.Errorf("%w: %s: %w", ErrLock, path, err)
+	}
+	return locked, nil
+}
+
+// checkAggregate compares the locked layout of the aggregate identified by
+// name with the current one, which is resolved for the same target. An
+// aggregate which cannot be found anymore is reported as removed.
+func checkAggregate(aggregates layout.Context, locked report.Report, name string) (report.Diff, error) {
+	idx := slices.IndexFunc(locked.Aggregates, func(entry report.Aggregate) bool {
+		return entry.Name == name
+	})
+	if idx == -1 {
+		return report.Diff{}, fmt.Errorf("%w: %s is not locked", ErrLock, name)
+	}
+
+	entry := locked.Aggregates[idx]
+	if target := aggregates.Target().Name; entry.Target != target {
+		return report.Diff{}, fmt.Errorf("%w: %s is locked for target %s, not %s",
+			ErrLock, name, entry.Target, target)
+	}
+
+	current, err := resolveReport(aggregates, name)
+	if err != nil {
+		return report.Diff{}, err
+	}
+	return report.Compare(&entry, current), nil
+}
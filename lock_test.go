@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Abathargh/stropt/abi"
+	"github.com/Abathargh/stropt/layout"
+	"github.com/Abathargh/stropt/report"
+)
+
+func TestCheckAggregate(t *testing.T) {
+	const (
+		before = "struct l1 { char a; int b; }; struct l2 { int a; }; struct l3 { int a; };"
+		after  = "struct l1 { char a; int b; }; struct l2 { long a; };"
+	)
+
+	lock := func(target abi.Target) report.Report {
+		aggregates, err := layout.ExtractAggregates("", before, false, target)
+		if err != nil {
+			t.Fatalf("Unexpected error when parsing %s: %s", before, err)
+		}
+
+		locked := report.New()
+		for _, name := range []string{"struct l1", "struct l2", "struct l3"} {
+			entry, err := newReportEntry(aggregates, name, options{})
+			if err != nil {
+				t.Fatalf("Unexpected error when locking %s: %s", name, err)
+			}
+			locked.Aggregates = append(locked.Aggregates, entry)
+		}
+		return locked
+	}
+
+	aggregates, err := layout.ExtractAggregates("", after, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", after, err)
+	}
+
+	locked := lock(abi.DefaultTarget())
+	expected := map[string]string{
+		"struct l1": report.StatusUnchanged,
+		"struct l2": report.StatusChanged,
+		"struct l3": report.StatusRemoved,
+	}
+
+	for name, status := range expected {
+		diff, err := checkAggregate(aggregates, locked, name)
+		if err != nil || diff.Status != status {
+			t.Errorf("Expected %s to be %s: got %s, %v", name, status, diff.Status, err)
+		}
+	}
+
+	if _, err := checkAggregate(aggregates, locked, "struct l4"); !errors.Is(err, ErrLock) {
+		t.Errorf("Expected an error for an aggregate which is not locked: got %v", err)
+	}
+
+	avr, err := abi.LookupTarget("avr")
+	if err != nil {
+		t.Fatalf("Unexpected error when looking up the target: %s", err)
+	}
+
+	if _, err := checkAggregate(aggregates, lock(avr), "struct l1"); !errors.Is(err, ErrLock) {
+		t.Errorf("Expected an error for an aggregate locked for another target: got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Abathargh/stropt/abi"
+	"github.com/Abathargh/stropt/layout"
+	"github.com/Abathargh/stropt/report"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+const divergentColorHex = "#ff875f"
+
+var (
+	ErrNoTargets = errors.New("no targets to compare")
+
+	divergentColor = lipgloss.Color(divergentColorHex)
+
+	matrixStyle = lipgloss.NewStyle().
+			Foreground(entryColor).
+			Padding(0, 1).
+			Align(lipgloss.Center)
+
+	divergentStyle = matrixStyle.
+			Bold(true).
+			Foreground(divergentColor)
+)
+
+// A targetLayout holds the layout of an aggregate, resolved for a target.
+type targetLayout struct {
+	target abi.Target
+	meta   layout.AggregateMeta
+}
+
+// lookupTargets retrieves the built-in targets identified by the passed
+// comma-separated list of names or triples.
+func lookupTargets(list string) ([]abi.Target, error) {
+	var targets []abi.Target
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		target, err := abi.LookupTarget(name)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+
+	if len(targets) == 0 {
+		return nil, ErrNoTargets
+	}
+	return targets, nil
+}
+
+// stroptTargets compares the layout of the aggregates identified by the
+// passed names across the passed targets, or of every aggregate within the
+// source if no name is passed. The source is parsed once for each target,
+// since constant expressions may depend on it.
+func stroptTargets(fname, cont string, names []string, targets []abi.Target, opts options) {
+	contexts := make([]layout.Context, 0, len(targets))
+	for _, target := range targets {
+		aggregates, err := layout.ExtractAggregates(fname, cont, opts.compiler, target)
+		if err != nil {
+			logError(fmt.Errorf("target %s: %w", target.Name, err))
+		}
+		contexts = append(contexts, aggregates)
+	}
+
+	skipErrors := len(names) == 0
+	if skipErrors {
+		for _, aggregate := range contexts[0].Aggregates() {
+			names = append(names, layout.GetAggregateNames(aggregate)[0])
+		}
+	}
+
+	if opts.format != formatTable {
+		doc := report.New()
+		for _, name := range names {
+			for _, aggregates := range contexts {
+				aggReport, err := newReportEntry(aggregates, name, opts)
+				if err != nil && skipErrors {
+					logWarning(err)
+					continue
+				} else if err != nil {
+					logError(err)
+				}
+				doc.Aggregates = append(doc.Aggregates, aggReport)
+			}
+		}
+
+		if err := encodeReport(doc, opts.format); err != nil {
+			logError(err)
+		}
+		return
+	}
+
+	for _, name := range names {
+		layouts, err := resolveTargets(contexts, name)
+		if err != nil && skipErrors {
+			logWarning(err)
+			continue
+		} else if err != nil {
+			logError(err)
+		}
+		printMatrix(name, layouts, opts.bare, opts.verbose)
+	}
+}
+
+// resolveTargets resolves the layout of the aggregate identified by name
+// within each of the passed contexts, one for each target.
+func resolveTargets(contexts []layout.Context, name string) ([]targetLayout, error) {
+	layouts := make([]targetLayout, 0, len(contexts))
+	for _, aggregates := range contexts {
+		meta, err := aggregates.ResolveMeta(name)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", aggregates.Target().Name, err)
+		}
+		layouts = append(layouts, targetLayout{aggregates.Target(), meta})
+	}
+	return layouts, nil
+}
+
+// printMatrix prints the layout of an aggregate side by side for each of the
+// passed targets, with a row for the aggregate itself and one for each of its
+// fields, showing their offset, size and alignment. The rows of the fields
+// whose layout differs between the targets are highlighted.
+func printMatrix(name string, layouts []targetLayout, bare, verbose bool) {
+	var (
+		headers                  = []string{"Field"}
+		names, matrix, divergent = buildMatrix(name, layouts, verbose)
+		count                    = 0
+	)
+
+	for _, tLayout := range layouts {
+		headers = append(headers, tLayout.target.Name)
+	}
+
+	for _, differs := range divergent {
+		if differs {
+			count++
+		}
+	}
+
+	if bare {
+		fmt.Fprintf(os.Stdout, "(cmp) ")
+		for idx, cells := range matrix {
+			var line strings.Builder
+			line.WriteString(names[idx])
+			for col, cell := range cells {
+				if cell != "" {
+					fmt.Fprintf(&line, ", %s: %s", headers[col+1], cell)
+				}
+			}
+
+			if divergent[idx] {
+				line.WriteString(", differs")
+			}
+			fmt.Fprintln(os.Stdout, line.String())
+		}
+		return
+	}
+
+	t := table.New().
+		Border(lipgloss.RoundedBorder()).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			switch {
+			case row == -1:
+				return headerStyle.Width(0).Padding(0, 1)
+			case divergent[row] && col > 0:
+				return divergentStyle
+			}
+			return matrixStyle
+		}).
+		Headers(headers...)
+
+	for idx, cells := range matrix {
+		t.Row(append([]string{names[idx]}, cells...)...)
+	}
+
+	fmt.Println(titleBox.Render(fmt.Sprintf("stropt - %s across targets", name)))
+	fmt.Println(t)
+	fmt.Println("Cells show offset / size / alignment")
+
+	if count == 0 {
+		fmt.Println("The layout is the same on every target")
+	} else {
+		fmt.Printf("The layout differs between targets in %d rows\n", count)
+	}
+}
+
+// buildMatrix formats the layout of an aggregate for each of the passed
+// targets, returning the name of each row, i.e. the one of the aggregate
+// followed by the ones of its fields, their cells, one for each target, and
+// whether the cells of each row differ. Fields are matched across targets by
+// their key, since the source may declare some of them only for specific
+// targets, and the cells of the targets missing a field are left blank.
+func buildMatrix(name string, layouts []targetLayout, verbose bool) ([]string, [][]string, []bool) {
+	var (
+		names  = []string{name}
+		keys   = []string{""}
+		matrix = [][]string{make([]string, len(layouts))}
+	)
+
+	for col, tLayout := range layouts {
+		matrix[0][col] = matrixCell("", fmt.Sprint(tLayout.meta.Size),
+			fmt.Sprint(tLayout.meta.Alignment))
+
+		var (
+			rows    = layoutRows(tLayout.meta, verbose)
+			rowKeys = make(map[string]bool, len(rows))
+			count   = make(map[string]int)
+		)
+
+		// fields sharing the same key, e.g. unnamed bit-fields of the same
+		// type, are matched by their occurrence
+		for idx, row := range rows {
+			rows[idx].key = fmt.Sprintf("%s#%d", row.key, count[row.key])
+			rowKeys[rows[idx].key] = true
+			count[row.key]++
+		}
+
+		prev := 0
+		for _, row := range rows {
+			// fields missing from the previous targets follow the row
+			// preceding them within this one, and the rows of the fields
+			// this target does not have
+			idx := slices.Index(keys, row.key)
+			if idx == -1 {
+				idx = prev + 1
+				for idx < len(keys) && !rowKeys[keys[idx]] {
+					idx++
+				}
+
+				names = slices.Insert(names, idx, row.name)
+				keys = slices.Insert(keys, idx, row.key)
+				matrix = slices.Insert(matrix, idx, make([]string, len(layouts)))
+			}
+
+			matrix[idx][col] = matrixCell(row.offset, row.size, row.align)
+			prev = idx
+		}
+	}
+
+	divergent := make([]bool, len(matrix))
+	for idx, cells := range matrix {
+		divergent[idx] = slices.ContainsFunc(cells, func(cell string) bool {
+			return cell != cells[0]
+		})
+	}
+	return names, matrix, divergent
+}
+
+// matrixCell formats the layout of a field for a target, as shown in the
+// cells of the matrix. Enumerators and aggregates have no offset.
+func matrixCell(offset, size, align string) string {
+	if offset == "" {
+		offset = "-"
+	}
+	return fmt.Sprintf("%s / %s / %s", offset, size, align)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Abathargh/stropt/layout"
+)
+
+func TestBuildMatrix(t *testing.T) {
+	const test = `struct w { char tag; int seq; char buf[4]; void *next; };`
+
+	targets, err := lookupTargets("x86_64, i686-linux-gnu,avr")
+	if err != nil {
+		t.Fatalf("Unexpected error when looking up the targets: %s", err)
+	}
+
+	var contexts []layout.Context
+	for _, target := range targets {
+		structs, err := layout.ExtractAggregates("", test, false, target)
+		if err != nil {
+			t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+		}
+		contexts = append(contexts, structs)
+	}
+
+	layouts, err := resolveTargets(contexts, "struct w")
+	if err != nil {
+		t.Fatalf("Unexpected error when resolving: %s", err)
+	}
+
+	names, matrix, divergent := buildMatrix("struct w", layouts, false)
+
+	expNames := []string{"struct w", "tag", "seq", "buf[4]", "next"}
+	if !slices.Equal(names, expNames) {
+		t.Errorf("Expected rows %v: got %v", expNames, names)
+	}
+
+	expected := [][]string{
+		{"- / 24 / 8", "- / 16 / 4", "- / 9 / 1"},
+		{"0 / 1 / 1", "0 / 1 / 1", "0 / 1 / 1"},
+		{"4 / 4 / 4", "4 / 4 / 4", "1 / 2 / 1"},
+		{"8 / 4 / 1", "8 / 4 / 1", "3 / 4 / 1"},
+		{"16 / 8 / 8", "12 / 4 / 4", "7 / 2 / 1"},
+	}
+	expDivergent := []bool{true, false, true, true, true}
+
+	for idx, cells := range matrix {
+		if !slices.Equal(cells, expected[idx]) || divergent[idx] != expDivergent[idx] {
+			t.Errorf("Expected row %s: %v (differs %t): got %v (differs %t)", names[idx],
+				expected[idx], expDivergent[idx], cells, divergent[idx])
+		}
+	}
+
+	if _, err := lookupTargets(" , "); err == nil {
+		t.Errorf("Expected an error for an empty list of targets")
+	}
+}
+
+func TestBuildMatrixConditionalFields(t *testing.T) {
+	const test = `struct c { char tag;
+	#ifdef __x86_64__
+	long wide;
+	#endif
+	#if __SIZEOF_POINTER__ == 4
+	short narrow;
+	#endif
+	int seq; };`
+
+	targets, err := lookupTargets("x86_64,i386")
+	if err != nil {
+		t.Fatalf("Unexpected error when looking up the targets: %s", err)
+	}
+
+	var contexts []layout.Context
+	for _, target := range targets {
+		structs, err := layout.ExtractAggregates("", test, false, target)
+		if err != nil {
+			t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+		}
+		contexts = append(contexts, structs)
+	}
+
+	layouts, err := resolveTargets(contexts, "struct c")
+	if err != nil {
+		t.Fatalf("Unexpected error when resolving: %s", err)
+	}
+
+	names, matrix, divergent := buildMatrix("struct c", layouts, false)
+
+	expNames := []string{"struct c", "tag", "wide", "narrow", "seq"}
+	if !slices.Equal(names, expNames) {
+		t.Errorf("Expected rows %v: got %v", expNames, names)
+	}
+
+	expected := [][]string{
+		{"- / 24 / 8", "- / 8 / 4"},
+		{"0 / 1 / 1", "0 / 1 / 1"},
+		{"8 / 8 / 8", ""},
+		{"", "2 / 2 / 2"},
+		{"16 / 4 / 4", "4 / 4 / 4"},
+	}
+	expDivergent := []bool{true, false, true, true, true}
+
+	for idx, cells := range matrix {
+		if !slices.Equal(cells, expected[idx]) || divergent[idx] != expDivergent[idx] {
+			t.Errorf("Expected row %s: %v (differs %t): got %v (differs %t)", names[idx],
+				expected[idx], expDivergent[idx], cells, divergent[idx])
+		}
+	}
+}
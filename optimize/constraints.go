@@ -0,0 +1,353 @@
+package optimize
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Abathargh/stropt/layout"
+)
+
+var (
+	ErrConstraint   = errors.New("cannot satisfy the constraints")
+	ErrUnknownField = errors.New("unknown field")
+	ErrDirective    = errors.New("unknown stropt directive")
+)
+
+// Constraints restrict the orderings considered when optimizing a struct,
+// referring to its fields by name. Flexible array members are always kept
+// last, and unnamed bit-fields in place, regardless of them.
+type Constraints struct {
+	// Pins maps the fields which must be placed at a given index, or keep
+	// their original one if the index is negative.
+	Pins map[string]int
+
+	// Prefix is the number of leading fields which keep their place.
+	Prefix int
+
+	// Groups lists the sets of fields to be kept next to each other, in
+	// their original order.
+	Groups [][]string
+
+	// Orders lists the sequences of fields to be kept in the given order,
+	// e.g. {"a", "b"} places a before b, without them being next to each
+	// other.
+	Orders [][]string
+}
+
+// Merge returns the constraints holding both the passed ones and c.
+func (c Constraints) Merge(other Constraints) Constraints {
+	merged := Constraints{
+		Pins:   maps.Clone(c.Pins),
+		Prefix: max(c.Prefix, other.Prefix),
+		Groups: slices.Concat(c.Groups, other.Groups),
+		Orders: slices.Concat(c.Orders, other.Orders),
+	}
+
+	if merged.Pins == nil && len(other.Pins) != 0 {
+		merged.Pins = make(map[string]int)
+	}
+	maps.Copy(merged.Pins, other.Pins)
+	return merged
+}
+
+// Directives builds the constraints described by the stropt directives
+// found within the comments next to the fields of the passed aggregate:
+//
+//   - `pin` keeps the field at its index, while `pin N` places it at N.
+//   - `prefix` keeps the field, and every field before it, in place.
+//   - `group NAME` keeps the fields of the NAME group next to each other.
+//   - `before FIELD` and `after FIELD` keep the field before or after FIELD.
+func Directives(agg *layout.Aggregate) (Constraints, error) {
+	var (
+		constraints Constraints
+		groups      = make(map[string]int)
+	)
+
+	for idx, field := range agg.Fields {
+		name := layout.FieldName(field)
+		for _, directive := range agg.Directives[name] {
+			keyword, arg, _ := strings.Cut(directive, " ")
+
+			switch {
+			case keyword == "pin" && arg == "":
+				constraints.addPin(name, -1)
+			case keyword == "pin":
+				pos, err := strconv.Atoi(arg)
+				if err != nil || pos < 0 {
+					return Constraints{}, fmt.Errorf("%w: %s: %s", ErrDirective, name, directive)
+				}
+				constraints.addPin(name, pos)
+			case keyword == "prefix" && arg == "":
+				constraints.Prefix = max(constraints.Prefix, idx+1)
+			case keyword == "group" && arg != "":
+				group, ok := groups[arg]
+				if !ok {
+					group = len(constraints.Groups)
+					groups[arg] = group
+					constraints.Groups = append(constraints.Groups, nil)
+				}
+				constraints.Groups[group] = append(constraints.Groups[group], name)
+			case keyword == "before" && arg != "":
+				constraints.Orders = append(constraints.Orders, []string{name, arg})
+			case keyword == "after" && arg != "":
+				constraints.Orders = append(constraints.Orders, []string{arg, name})
+			default:
+				return Constraints{}, fmt.Errorf("%w: %s: %s", ErrDirective, name, directive)
+			}
+		}
+	}
+	return constraints, nil
+}
+
+// addPin pins the passed field at the passed index.
+func (c *Constraints) addPin(name string, pos int) {
+	if c.Pins == nil {
+		c.Pins = make(map[string]int)
+	}
+	c.Pins[name] = pos
+}
+
+// resolve turns the constraints into units, i.e. the sets of members which
+// are placed next to each other, and the rules they are placed with, for the
+// passed members, in their original order. The members are indexes within
+// the fields of the aggregate.
+func (c Constraints) resolve(agg *layout.Aggregate, members []int) ([]unit, rules, error) {
+	var (
+		byName    = make(map[string]int)
+		positions = make(map[int]int) // original index of the members
+	)
+
+	for pos, idx := range members {
+		positions[idx] = pos
+		if name := layout.FieldName(agg.Fields[idx]); name != "" {
+			byName[name] = idx
+		}
+	}
+
+	lookup := func(name string) (int, error) {
+		if idx, ok := byName[name]; ok {
+			return idx, nil
+		}
+
+		// the fields which are not members are always kept last
+		if slices.ContainsFunc(agg.Fields, func(field layout.Field) bool {
+			return layout.FieldName(field) == name
+		}) {
+			return -1, fmt.Errorf("%w: %s is always kept last", ErrConstraint, name)
+		}
+		return -1, fmt.Errorf("%w: %s", ErrUnknownField, name)
+	}
+
+	// members grouped together share the same unit
+	groupOf := make(map[int]int)
+	join := func(idx, group int) {
+		// overlapping groups are merged
+		if other, ok := groupOf[idx]; ok && other != group {
+			for member, curr := range groupOf {
+				if curr == other {
+					groupOf[member] = group
+				}
+			}
+		}
+		groupOf[idx] = group
+	}
+
+	for group, names := range c.Groups {
+		for _, name := range names {
+			idx, err := lookup(name)
+			if err != nil {
+				return nil, rules{}, err
+			}
+			join(idx, group)
+		}
+	}
+
+	// the declarators sharing an anonymous aggregate defined inline, e.g.
+	// `struct { int x; } a, b;`, cannot be declared apart
+	shared := make(map[*layout.Aggregate]int)
+	for _, idx := range members {
+		basic, isBasic := layout.AsBasic(agg.Fields[idx])
+		if !isBasic || basic.Inline == nil || basic.Inline.Name != "" {
+			continue
+		}
+
+		group, ok := shared[basic.Inline]
+		if !ok {
+			group = len(c.Groups) + len(shared)
+			shared[basic.Inline] = group
+		}
+		join(idx, group)
+	}
+
+	var (
+		units  []unit
+		unitOf = make(map[int]int)
+		seen   = make(map[int]int)
+	)
+
+	for _, idx := range members {
+		group, grouped := groupOf[idx]
+		if id, ok := seen[group]; grouped && ok {
+			units[id] = append(units[id], idx)
+			unitOf[idx] = id
+			continue
+		}
+
+		if grouped {
+			seen[group] = len(units)
+		}
+		unitOf[idx] = len(units)
+		units = append(units, unit{idx})
+	}
+
+	r := rules{
+		start: slices.Repeat([]int{-1}, len(units)),
+		after: make([][]int, len(units)),
+	}
+
+	pin := func(idx, pos int) error {
+		if pos >= len(members) {
+			return fmt.Errorf("%w: %s at %d", ErrConstraint, agg.Fields[idx].Declaration(), pos)
+		}
+
+		id := unitOf[idx]
+		start := pos - slices.Index(units[id], idx)
+		if start < 0 || (r.start[id] != -1 && r.start[id] != start) {
+			return fmt.Errorf("%w: %s at %d", ErrConstraint, agg.Fields[idx].Declaration(), pos)
+		}
+		r.start[id] = start
+		return nil
+	}
+
+	for pos := range min(c.Prefix, len(members)) {
+		if err := pin(members[pos], pos); err != nil {
+			return nil, rules{}, err
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.Pins)) {
+		idx, err := lookup(name)
+		if err != nil {
+			return nil, rules{}, err
+		}
+
+		pos := c.Pins[name]
+		if pos < 0 {
+			pos = positions[idx]
+		}
+
+		if err := pin(idx, pos); err != nil {
+			return nil, rules{}, err
+		}
+	}
+
+	for _, names := range c.Orders {
+		for pos := 1; pos < len(names); pos++ {
+			first, err := lookup(names[pos-1])
+			if err != nil {
+				return nil, rules{}, err
+			}
+
+			second, err := lookup(names[pos])
+			if err != nil {
+				return nil, rules{}, err
+			}
+
+			firstUnit, secondUnit := unitOf[first], unitOf[second]
+			if firstUnit != secondUnit {
+				r.after[secondUnit] = append(r.after[secondUnit], firstUnit)
+				continue
+			}
+
+			// the members of a group keep their original order
+			if positions[first] > positions[second] {
+				return nil, rules{}, fmt.Errorf("%w: %s before %s", ErrConstraint,
+					names[pos-1], names[pos])
+			}
+		}
+	}
+
+	// unnamed bit-fields are explicit padding or separators, e.g. `int : 0`,
+	// so the members never cross them, and they keep their place
+	var (
+		separator = -1
+		segment   []int // the units found since the last separator
+	)
+
+	for _, idx := range members {
+		id := unitOf[idx]
+		if isSeparator(agg.Fields[idx]) {
+			r.after[id] = append(r.after[id], segment...)
+			separator, segment = id, []int{id}
+			continue
+		}
+
+		if separator != -1 && id != separator {
+			r.after[id] = append(r.after[id], separator)
+		}
+		segment = append(segment, id)
+	}
+
+	for id, start := range r.start {
+		if start != -1 {
+			r.pinned = append(r.pinned, id)
+		}
+	}
+	return units, r, nil
+}
+
+// isSeparator checks whether the passed field is an unnamed bit-field.
+func isSeparator(field layout.Field) bool {
+	bitField, isBitField := field.(layout.BitField)
+	return isBitField && bitField.Name == ""
+}
+
+// rules describe how the units of a struct may be placed: each of them may
+// have to start at a given index, or come after some other units.
+type rules struct {
+	start  []int   // index each unit starts at, or -1
+	after  [][]int // units placed before each unit
+	pinned []int   // units with a start index
+}
+
+// constrained reports whether the passed unit is subject to any rule, either
+// directly or because another unit has to come after it.
+func (r rules) constrained(id int) bool {
+	if r.start[id] != -1 || len(r.after[id]) != 0 {
+		return true
+	}
+
+	for _, after := range r.after {
+		if slices.Contains(after, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows checks whether the passed unit, made of size members, can be placed
+// at the passed index, given which units are placed already.
+func (r rules) allows(id, size, pos int, placed func(int) bool) bool {
+	if r.start[id] != -1 && r.start[id] != pos {
+		return false
+	}
+
+	// the units which must start within this one could not be placed anymore
+	for _, other := range r.pinned {
+		start := r.start[other]
+		if other != id && !placed(other) && start >= pos && start < pos+size {
+			return false
+		}
+	}
+
+	for _, other := range r.after[id] {
+		if !placed(other) {
+			return false
+		}
+	}
+	return true
+}
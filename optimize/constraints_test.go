@@ -0,0 +1,123 @@
+package optimize
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/Abathargh/stropt/abi"
+	"github.com/Abathargh/stropt/layout"
+)
+
+func TestConstraints(t *testing.T) {
+	const c1 = "struct c1 { char a; double d; char b; int i; };"
+
+	testCases := []struct {
+		test        string
+		name        string
+		constraints Constraints
+		expSize     int
+		expOrder    []string
+		expErr      error
+	}{
+		{c1, "struct c1", Constraints{}, 16, []string{"d", "a", "b", "i"}, nil},
+		{c1, "struct c1", Constraints{Pins: map[string]int{"a": -1}}, 16, []string{"a", "b", "i", "d"}, nil},
+		{c1, "struct c1", Constraints{Pins: map[string]int{"i": 0}}, 16, []string{"i", "a", "b", "d"}, nil},
+		{c1, "struct c1", Constraints{Prefix: 2}, 24, []string{"a", "d", "b", "i"}, nil},
+		{c1, "struct c1", Constraints{Groups: [][]string{{"a", "i"}}}, 16, []string{"d", "b", "a", "i"}, nil},
+		{c1, "struct c1", Constraints{Orders: [][]string{{"b", "a"}}}, 16, []string{"d", "b", "a", "i"}, nil},
+		{
+			"struct c2 { char a; /* stropt: pin */ double d; char b; int i; };",
+			"struct c2",
+			Constraints{},
+			16,
+			[]string{"a", "b", "i", "d"},
+			nil,
+		},
+		{
+			"struct c3 { char a; double d; char b; // stropt: group g\n int i; char c; // stropt: group g\n };",
+			"struct c3",
+			Constraints{},
+			16,
+			[]string{"d", "a", "b", "c", "i"},
+			nil,
+		},
+		{
+			"struct c6 { char a; double d; char b; int : 0; char c; short s; char e; };",
+			"struct c6",
+			Constraints{},
+			16,
+			[]string{"d", "a", "b", ":0", "s", "c", "e"},
+			nil,
+		},
+		{
+			"struct c7 { char c; struct { char x; } a, b; short s; /* stropt: before b */ };",
+			"struct c7",
+			Constraints{},
+			6,
+			[]string{"c", "s", "a", "b"},
+			nil,
+		},
+		{c1, "struct c1", Constraints{Pins: map[string]int{"x": -1}}, 0, nil, ErrUnknownField},
+		{c1, "struct c1", Constraints{Pins: map[string]int{"a": 0, "d": 0}}, 0, nil, ErrConstraint},
+		{c1, "struct c1", Constraints{Pins: map[string]int{"a": 4}}, 0, nil, ErrConstraint},
+		{c1, "struct c1", Constraints{Orders: [][]string{{"a", "b", "a"}}}, 0, nil, ErrConstraint},
+		{"struct c4 { int n; char data[]; };", "struct c4", Constraints{Pins: map[string]int{"data": 0}}, 0, nil, ErrConstraint},
+		{"struct c5 { char a; /* stropt: nail */ int i; };", "struct c5", Constraints{}, 0, nil, ErrDirective},
+	}
+
+	for _, testCase := range testCases {
+		structs, err := layout.ExtractAggregates("", testCase.test, false, abi.DefaultTarget())
+		if err != nil {
+			t.Errorf("Unexpected error when parsing %s: %s", testCase.test, err)
+			continue
+		}
+
+		meta, err := structs.ResolveMeta(testCase.name)
+		if err != nil {
+			t.Errorf("Unexpected error when resolving %s: %s", testCase.test, err)
+			continue
+		}
+
+		optMeta, err := Optimize(structs, testCase.name, meta, testCase.constraints)
+		if testCase.expErr != nil {
+			if !errors.Is(err, testCase.expErr) {
+				t.Errorf("Expected error %v: got: %v for '%s' with %+v", testCase.expErr,
+					err, testCase.test, testCase.constraints)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error when optimizing %s: %s", testCase.test, err)
+			continue
+		}
+
+		var order []string
+		for _, fLayout := range optMeta.Layout {
+			order = append(order, fLayout.Declaration())
+		}
+
+		if optMeta.Size != testCase.expSize || !slices.Equal(order, testCase.expOrder) {
+			t.Errorf("Expected size %d with order %v: got: %d with order %v for '%s' with %+v",
+				testCase.expSize, testCase.expOrder, optMeta.Size, order, testCase.test,
+				testCase.constraints)
+		}
+	}
+}
+
+func TestConstraintsMerge(t *testing.T) {
+	var (
+		first  = Constraints{Pins: map[string]int{"a": -1, "b": 1}, Prefix: 1}
+		second = Constraints{Pins: map[string]int{"b": 2}, Groups: [][]string{{"c", "d"}}}
+		merged = first.Merge(second)
+	)
+
+	if merged.Pins["a"] != -1 || merged.Pins["b"] != 2 || merged.Prefix != 1 || len(merged.Groups) != 1 {
+		t.Errorf("Unexpected merged constraints: %+v", merged)
+	}
+
+	if first.Pins["b"] != 1 {
+		t.Errorf("Expected the merged constraints not to be modified: got %+v", first)
+	}
+}
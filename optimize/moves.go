@@ -0,0 +1,131 @@
+package optimize
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/Abathargh/stropt/layout"
+)
+
+// A Move describes how to move a field of an aggregate in order to get to
+// its optimized layout: the field is placed right after the After one, or
+// first if After is nil.
+type Move struct {
+	Field layout.Field
+	After layout.Field
+}
+
+// String describes the move, e.g. "move `flag` after `iptr`".
+func (m Move) String() string {
+	if m.After == nil {
+		return fmt.Sprintf("move `%s` first", Label(m.Field))
+	}
+	return fmt.Sprintf("move `%s` after `%s`", Label(m.Field), Label(m.After))
+}
+
+// Moves computes the shortest list of moves which turns the original layout
+// of an aggregate into the optimized one, to be applied in order. The fields
+// that keep their relative order, i.e. the longest sequence of them which
+// appears in the same order within both layouts, are left in place.
+func Moves(original, optimized []layout.Layout) []Move {
+	var (
+		positions = matchFields(original, optimized)
+		kept      = longestIncreasing(positions)
+		moves     []Move
+	)
+
+	for idx, fLayout := range optimized {
+		if kept[idx] {
+			continue
+		}
+
+		move := Move{Field: fLayout.Field}
+		if idx > 0 {
+			move.After = optimized[idx-1].Field
+		}
+		moves = append(moves, move)
+	}
+	return moves
+}
+
+// Order returns the position of each of the optimized fields within the
+// original layout of an aggregate, i.e. the order its fields are placed in,
+// as indexes within the original ones.
+func Order(original, optimized []layout.Layout) []int {
+	return matchFields(original, optimized)
+}
+
+// matchFields returns the position of each of the optimized fields within
+// the original layout. Fields that look the same, e.g. unnamed bit-fields of
+// the same width, are matched in order.
+func matchFields(original, optimized []layout.Layout) []int {
+	var (
+		positions = make([]int, len(optimized))
+		used      = make([]bool, len(original))
+	)
+
+	for idx, fLayout := range optimized {
+		positions[idx] = -1
+		for pos, other := range original {
+			if !used[pos] && sameField(fLayout, other) {
+				positions[idx], used[pos] = pos, true
+				break
+			}
+		}
+	}
+	return positions
+}
+
+// sameField checks whether the passed layouts belong to the same field.
+func sameField(a, b layout.Layout) bool {
+	return a.Declaration() == b.Declaration() && a.Type() == b.Type()
+}
+
+// longestIncreasing marks the elements belonging to one of the longest
+// strictly increasing subsequences of the passed values.
+func longestIncreasing(values []int) []bool {
+	var (
+		tails = []int{} // index of the smallest tail for each length
+		prev  = make([]int, len(values))
+		kept  = make([]bool, len(values))
+	)
+
+	for idx, value := range values {
+		length, _ := slices.BinarySearchFunc(tails, value, func(tail, value int) int {
+			return values[tail] - value
+		})
+
+		prev[idx] = -1
+		if length > 0 {
+			prev[idx] = tails[length-1]
+		}
+
+		if length == len(tails) {
+			tails = append(tails, idx)
+		} else {
+			tails[length] = idx
+		}
+	}
+
+	if len(tails) == 0 {
+		return kept
+	}
+
+	for idx := tails[len(tails)-1]; idx != -1; idx = prev[idx] {
+		kept[idx] = true
+	}
+	return kept
+}
+
+// Label returns how a field is referred to within a move: its name, or its
+// declaration or type for unnamed bit-fields and anonymous members.
+func Label(field layout.Field) string {
+	if name := layout.FieldName(field); name != "" {
+		return name
+	}
+
+	if decl := field.Declaration(); decl != "" {
+		return decl
+	}
+	return field.Type()
+}
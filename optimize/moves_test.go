@@ -0,0 +1,145 @@
+package optimize
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/Abathargh/stropt/abi"
+	"github.com/Abathargh/stropt/layout"
+)
+
+func TestMoves(t *testing.T) {
+	testCases := []struct {
+		test     string
+		name     string
+		expMoves []string
+	}{
+		{
+			"struct v1 { char flag; int *iptr; short s; char c; double d; };",
+			"struct v1",
+			[]string{"move `iptr` first"},
+		},
+		{
+			"struct v2 { char a; double b; char c; double d; char e; };",
+			"struct v2",
+			[]string{"move `b` first", "move `e` after `c`"},
+		},
+		{
+			"struct v3 { int :3; char c; long long l : 60; struct { char x; } in; };",
+			"struct v3",
+			[]string{"move `l` after `:3`"},
+		},
+		{
+			"struct v5 { double x; char a; long : 0; char b; double d; char c; };",
+			"struct v5",
+			[]string{"move `c` after `b`"},
+		},
+		{
+			"struct v4 { double d; int i; };",
+			"struct v4",
+			nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		structs, err := layout.ExtractAggregates("", testCase.test, false, abi.DefaultTarget())
+		if err != nil {
+			t.Errorf("Unexpected error when parsing %s: %s", testCase.test, err)
+			continue
+		}
+
+		meta, err := structs.ResolveMeta(testCase.name)
+		if err != nil {
+			t.Errorf("Unexpected error when resolving %s: %s", testCase.test, err)
+			continue
+		}
+
+		optMeta, err := Optimize(structs, testCase.name, meta, Constraints{})
+		if err != nil {
+			t.Errorf("Unexpected error when optimizing %s: %s", testCase.test, err)
+			continue
+		}
+
+		var moves []string
+		for _, move := range Moves(meta.Layout, optMeta.Layout) {
+			moves = append(moves, move.String())
+		}
+
+		if !slices.Equal(moves, testCase.expMoves) {
+			t.Errorf("Expected moves %q: got: %q for '%s'", testCase.expMoves, moves,
+				testCase.test)
+		}
+	}
+}
+
+func TestMovesApply(t *testing.T) {
+	var original []layout.Layout
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		original = append(original, layout.Layout{Field: layout.Basic{TypeName: "int", Name: name}})
+	}
+
+	rng := rand.New(rand.NewPCG(3, 5))
+	for range 200 {
+		optimized := slices.Clone(original)
+		rng.Shuffle(len(optimized), func(i, j int) {
+			optimized[i], optimized[j] = optimized[j], optimized[i]
+		})
+
+		var (
+			moves    = Moves(original, optimized)
+			applied  = names(original)
+			expected = names(optimized)
+		)
+
+		for _, move := range moves {
+			applied = slices.DeleteFunc(applied, func(name string) bool {
+				return name == Label(move.Field)
+			})
+
+			pos := 0
+			if move.After != nil {
+				pos = slices.Index(applied, Label(move.After)) + 1
+			}
+			applied = slices.Insert(applied, pos, Label(move.Field))
+		}
+
+		if !slices.Equal(applied, expected) {
+			t.Errorf("Expected %v after applying %v: got: %v", expected, moves, applied)
+		}
+
+		kept := len(original) - len(moves)
+		if lis := longestCommon(names(original), expected); kept != lis {
+			t.Errorf("Expected %d moves for %v: got: %d", len(original)-lis, expected,
+				len(moves))
+		}
+	}
+}
+
+func names(layouts []layout.Layout) []string {
+	var names []string
+	for _, fLayout := range layouts {
+		names = append(names, Label(fLayout.Field))
+	}
+	return names
+}
+
+// longestCommon computes the length of the longest common subsequence of two
+// sequences.
+func longestCommon(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+
+	for i := range a {
+		for j := range b {
+			if a[i] == b[j] {
+				lengths[i+1][j+1] = lengths[i][j] + 1
+			} else {
+				lengths[i+1][j+1] = max(lengths[i][j+1], lengths[i+1][j])
+			}
+		}
+	}
+	return lengths[len(a)][len(b)]
+}
@@ -0,0 +1,203 @@
+package optimize
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/Abathargh/stropt/layout"
+)
+
+// A Nested result describes the optimization of an aggregate alongside with
+// the ones nested within it, either defined inline or elsewhere: Original
+// and Optimized are the layouts of the outer aggregate before and after
+// re-ordering the fields of every aggregate involved, while Changes lists
+// the nested aggregates whose fields have to be moved, the innermost first.
+type Nested struct {
+	Original  layout.AggregateMeta
+	Optimized layout.AggregateMeta
+	Changes   []Change
+}
+
+// A Change describes a nested aggregate whose fields are re-ordered. Name
+// is the name of its type, or the path of the field it is defined inline
+// within for anonymous ones, e.g. `struct outer::pos`, while Aggregate is
+// its definition within the passed context. Original and Optimized are its
+// layouts, the latter taking into account the changes to the aggregates
+// nested within it, if any.
+type Change struct {
+	Name      string
+	Aggregate *layout.Aggregate
+	Original  layout.AggregateMeta
+	Optimized layout.AggregateMeta
+	Moves     []Move
+}
+
+// Savings returns the amount of bytes saved by optimizing the outer
+// aggregate together with the nested ones.
+func (n Nested) Savings() int {
+	return n.Original.Size - n.Optimized.Size
+}
+
+// OptimizeRecursive optimizes the aggregate identified by name, as Optimize
+// does, after optimizing every aggregate nested within it by value, so that
+// the outer layout takes their new size and alignment into account. The
+// passed constraints only apply to the outer aggregate, while the nested
+// ones follow their own directives. A nested aggregate used in multiple
+// places is only optimized once, and the aggregates within the passed
+// context are left untouched.
+func OptimizeRecursive(ctx layout.Context, name string, meta layout.AggregateMeta,
+	constraints Constraints) (Nested, error) {
+	agg, ok := ctx.Lookup(name)
+	if !ok {
+		return Nested{}, fmt.Errorf("%w: %v", layout.ErrSymbol, name)
+	}
+
+	r := recursion{
+		original: ctx,
+		curr:     ctx,
+		visited:  make(map[*layout.Aggregate]*layout.Aggregate),
+	}
+
+	reordered, err := r.nested(name, agg)
+	if err != nil {
+		return Nested{}, err
+	}
+
+	optMeta, err := r.curr.Resolve(name, reordered)
+	if err != nil {
+		return Nested{}, err
+	}
+
+	optMeta, err = optimize(r.curr, name, reordered, optMeta, constraints)
+	if err != nil {
+		return Nested{}, err
+	}
+	return Nested{Original: meta, Optimized: optMeta, Changes: r.changes}, nil
+}
+
+// A recursion keeps track of the nested aggregates optimized so far: curr
+// is the original context, where each of them is replaced by its optimized
+// copy.
+type recursion struct {
+	original layout.Context
+	curr     layout.Context
+	visited  map[*layout.Aggregate]*layout.Aggregate
+	changes  []Change
+}
+
+// nested returns a copy of the passed aggregate, whose fields refer to the
+// optimized copies of the aggregates nested within it, without re-ordering
+// its own fields.
+func (r *recursion) nested(name string, agg *layout.Aggregate) (*layout.Aggregate, error) {
+	copied := *agg
+	copied.Fields = slices.Clone(agg.Fields)
+
+	for idx, field := range copied.Fields {
+		basic, isValue := valueBasic(field)
+		if !isValue {
+			continue
+		}
+
+		if basic.Inline != nil {
+			inline, err := r.visit(inlineName(name, basic.Inline, field), basic.Inline)
+			if err != nil {
+				return nil, err
+			}
+			copied.Fields[idx] = withInline(field, inline)
+			continue
+		}
+
+		inner, isAggregate := r.original.Lookup(field.UnqualifiedType())
+		if !isAggregate {
+			continue
+		}
+
+		if _, err := r.visit(layout.GetAggregateNames(inner)[0], inner); err != nil {
+			return nil, err
+		}
+	}
+	return &copied, nil
+}
+
+// visit optimizes the passed nested aggregate, unless already done, and
+// replaces it with its optimized copy within the current context, recording
+// the change if any of its fields moved.
+func (r *recursion) visit(name string, agg *layout.Aggregate) (*layout.Aggregate, error) {
+	if reordered, isVisited := r.visited[agg]; isVisited {
+		return reordered, nil
+	}
+
+	if agg.Kind == layout.EnumKind {
+		r.visited[agg] = agg
+		return agg, nil
+	}
+
+	reordered, err := r.nested(name, agg)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := r.original.Resolve(name, agg)
+	if err != nil {
+		return nil, err
+	}
+
+	optMeta, err := r.curr.Resolve(name, reordered)
+	if err != nil {
+		return nil, err
+	}
+
+	if optMeta, err = optimize(r.curr, name, reordered, optMeta, Constraints{}); err != nil {
+		return nil, err
+	}
+
+	if moves := Moves(meta.Layout, optMeta.Layout); len(moves) != 0 {
+		r.changes = append(r.changes, Change{name, agg, meta, optMeta, moves})
+	}
+
+	r.visited[agg] = reordered
+	r.curr = r.curr.Replace(agg, reordered)
+	return reordered, nil
+}
+
+// valueBasic returns the Basic part of the passed field if it holds a value
+// of its type, i.e. if it is neither a pointer nor an array of pointers,
+// and thus its layout depends on the one of the type.
+func valueBasic(field layout.Field) (layout.Basic, bool) {
+	switch field := field.(type) {
+	case layout.Basic:
+		return field, true
+	case layout.Array:
+		return field.Basic, field.Element == layout.ValueKind
+	case layout.FlexibleArray:
+		return field.Basic, field.Element == layout.ValueKind
+	}
+	return layout.Basic{}, false
+}
+
+// withInline returns a copy of the passed field, whose type is defined by
+// the passed inline aggregate.
+func withInline(field layout.Field, inline *layout.Aggregate) layout.Field {
+	switch field := field.(type) {
+	case layout.Basic:
+		field.Inline = inline
+		return field
+	case layout.Array:
+		field.Inline = inline
+		return field
+	case layout.FlexibleArray:
+		field.Inline = inline
+		return field
+	}
+	return field
+}
+
+// inlineName returns the name an aggregate defined inline within the passed
+// field is referred to with: the name of its type, if it has one, or else
+// the path of the field within the enclosing aggregate.
+func inlineName(parent string, inline *layout.Aggregate, field layout.Field) string {
+	if names := layout.GetAggregateNames(inline); len(names) != 0 {
+		return names[0]
+	}
+	return fmt.Sprintf("%s::%s", parent, Label(field))
+}
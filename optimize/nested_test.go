@@ -0,0 +1,107 @@
+package optimize
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Abathargh/stropt/abi"
+	"github.com/Abathargh/stropt/layout"
+)
+
+func TestOptimizeRecursive(t *testing.T) {
+	const test = `struct point { char tag; double x; char kind; };
+	typedef struct point point_t;
+	struct shape {
+		char id;
+		point_t origin;
+		struct { char a; int b; char c; } box;
+		struct point pts[2];
+		struct point *next;
+		union { struct { char p; long q; char r; } s; int i; } u;
+		char last;
+	};`
+
+	structs, err := layout.ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	meta, err := structs.ResolveMeta("struct shape")
+	if err != nil {
+		t.Fatalf("Unexpected error when resolving %s: %s", test, err)
+	}
+
+	nested, err := OptimizeRecursive(structs, "struct shape", meta, Constraints{})
+	if err != nil {
+		t.Fatalf("Unexpected error when optimizing %s: %s", test, err)
+	}
+
+	if nested.Original.Size != 136 || nested.Optimized.Size != 88 || nested.Savings() != 48 {
+		t.Errorf("Expected 136 -> 88 bytes: got: %d -> %d, saving %d", nested.Original.Size,
+			nested.Optimized.Size, nested.Savings())
+	}
+
+	expected := []struct {
+		name      string
+		size      int
+		optimized int
+		moves     []string
+	}{
+		{"struct point", 24, 16, []string{"move `kind` after `tag`"}},
+		{"struct shape::box", 12, 8, []string{"move `c` after `a`"}},
+		{"struct shape::u::s", 24, 16, []string{"move `r` after `p`"}},
+	}
+
+	if len(nested.Changes) != len(expected) {
+		t.Fatalf("Expected %d changes: got: %d", len(expected), len(nested.Changes))
+	}
+
+	for idx, change := range nested.Changes {
+		var moves []string
+		for _, move := range change.Moves {
+			moves = append(moves, move.String())
+		}
+
+		exp := expected[idx]
+		if change.Name != exp.name || change.Original.Size != exp.size ||
+			change.Optimized.Size != exp.optimized || !slices.Equal(moves, exp.moves) {
+			t.Errorf("Expected change %s (%d -> %d) %v: got: %s (%d -> %d) %v", exp.name,
+				exp.size, exp.optimized, exp.moves, change.Name, change.Original.Size,
+				change.Optimized.Size, moves)
+		}
+	}
+
+	// the nested aggregates within the context are left untouched
+	point, err := structs.ResolveMeta("struct point")
+	if err != nil || point.Size != 24 {
+		t.Errorf("Expected struct point to be left untouched: got: %d, %v", point.Size, err)
+	}
+
+	if again, _ := structs.ResolveMeta("struct shape"); again.Size != meta.Size {
+		t.Errorf("Expected struct shape to be left untouched: got: %d", again.Size)
+	}
+}
+
+func TestOptimizeRecursiveMinimal(t *testing.T) {
+	const test = `struct inner { double d; char c; };
+	struct outer { struct inner in; int i; enum { A, B } e; };`
+
+	structs, err := layout.ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	meta, err := structs.ResolveMeta("struct outer")
+	if err != nil {
+		t.Fatalf("Unexpected error when resolving %s: %s", test, err)
+	}
+
+	nested, err := OptimizeRecursive(structs, "struct outer", meta, Constraints{})
+	if err != nil {
+		t.Fatalf("Unexpected error when optimizing %s: %s", test, err)
+	}
+
+	if nested.Savings() != 0 || len(nested.Changes) != 0 {
+		t.Errorf("Expected no changes: got: %d bytes saved, %v", nested.Savings(), nested.Changes)
+	}
+}
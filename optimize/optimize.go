@@ -0,0 +1,117 @@
+// Package optimize suggests how to re-order the fields of C aggregates, so
+// that their padding, and thus their size, is minimized.
+package optimize
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/Abathargh/stropt/layout"
+)
+
+// Optimize applies the optimization algorithm for minimizing the padding in
+// C aggregates on the passed AggregateMeta, returning a new copy where its
+// field may have been re-ordered. The aggregate identified by name within
+// the passed context is left untouched. The new layout follows the passed
+// constraints, alongside with the ones set through directives within the
+// source, and is the best one among those that do.
+func Optimize(ctx layout.Context, name string, meta layout.AggregateMeta,
+	constraints Constraints) (layout.AggregateMeta, error) {
+	agg, ok := ctx.Lookup(name)
+	if !ok {
+		return layout.AggregateMeta{}, fmt.Errorf("%w: %v", layout.ErrSymbol, name)
+	}
+
+	reordered := *agg
+	reordered.Fields = slices.Clone(agg.Fields)
+	return optimize(ctx, name, &reordered, meta, constraints)
+}
+
+// DropPacking checks whether the packed aggregate identified by name could
+// drop its packing, i.e. the packed attribute and any '#pragma pack' region,
+// without growing in size, once its fields are re-ordered. The unpacked
+// layout is compared with the optimized packed one, as re-ordering may
+// shrink the packed aggregate as well. It returns the metadata for the
+// optimized unpacked layout, and whether that is the case. Both layouts
+// follow the passed constraints, as for Optimize.
+func DropPacking(ctx layout.Context, name string, meta layout.AggregateMeta,
+	constraints Constraints) (layout.AggregateMeta, bool, error) {
+	agg, ok := ctx.Lookup(name)
+	if !ok {
+		return layout.AggregateMeta{}, false, fmt.Errorf("%w: %v", layout.ErrSymbol, name)
+	}
+
+	if !agg.IsPacked() {
+		return meta, false, nil
+	}
+
+	// work on a copy, the natural alignment of each field is needed to find
+	// the best layout
+	unpacked := *agg
+	unpacked.Packed, unpacked.Pack = false, 0
+	unpacked.Fields = slices.Clone(agg.Fields)
+
+	natural, err := ctx.Resolve(name, &unpacked)
+	if err != nil {
+		return layout.AggregateMeta{}, false, err
+	}
+
+	optMeta, err := optimize(ctx, name, &unpacked, natural, constraints)
+	if err != nil {
+		return layout.AggregateMeta{}, false, err
+	}
+
+	packedMeta, err := Optimize(ctx, name, meta, constraints)
+	if err != nil {
+		return layout.AggregateMeta{}, false, err
+	}
+	return optMeta, optMeta.Size <= packedMeta.Size, nil
+}
+
+// optimize re-orders the fields of the passed struct so that its size is
+// minimized, keeping them as close as possible to their original order among
+// the orderings with the same size, and resolves its metadata once again.
+// The best ordering is searched exhaustively for small structs, and through
+// a bounded heuristic for larger ones. Flexible array members are always
+// kept last, and unnamed bit-fields keep their place.
+func optimize(ctx layout.Context, name string, agg *layout.Aggregate,
+	meta layout.AggregateMeta, constraints Constraints) (layout.AggregateMeta, error) {
+	if agg.Kind != layout.StructKind {
+		return meta, nil
+	}
+
+	directives, err := Directives(agg)
+	if err != nil {
+		return layout.AggregateMeta{}, fmt.Errorf("name %s: %w", name, err)
+	}
+
+	searcher, err := newSearcher(ctx, agg)
+	if err != nil {
+		return layout.AggregateMeta{}, fmt.Errorf("name %s: %w", name, err)
+	}
+
+	var movable, flexible []int
+	for idx, field := range agg.Fields {
+		if _, isFlex := field.(layout.FlexibleArray); isFlex {
+			flexible = append(flexible, idx)
+			continue
+		}
+		movable = append(movable, idx)
+	}
+
+	units, rules, err := constraints.Merge(directives).resolve(agg, movable)
+	if err != nil {
+		return layout.AggregateMeta{}, fmt.Errorf("name %s: %w", name, err)
+	}
+
+	order, err := searcher.search(units, rules)
+	if err != nil {
+		return layout.AggregateMeta{}, fmt.Errorf("name %s: %w", name, err)
+	}
+
+	fields := slices.Clone(agg.Fields)
+	for pos, idx := range append(order, flexible...) {
+		agg.Fields[pos] = fields[idx]
+	}
+	return ctx.Resolve(name, agg)
+}
@@ -0,0 +1,378 @@
+package optimize
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Abathargh/stropt/abi"
+	"github.com/Abathargh/stropt/layout"
+)
+
+func TestDropPacking(t *testing.T) {
+	testCases := []struct {
+		test    string
+		name    string
+		canDrop bool
+		expSize int
+	}{
+		{
+			"struct __attribute__((packed)) d1 { char c; int a; short b; char d; };",
+			"struct d1",
+			true,
+			8,
+		},
+		{
+			"struct __attribute__((packed)) d2 { char c; int a; long long l; };",
+			"struct d2",
+			false,
+			16,
+		},
+		{
+			"#pragma pack(2)\nstruct d4 { char c; int a; char d; };\n#pragma pack()",
+			"struct d4",
+			false,
+			8,
+		},
+		{
+			"struct d3 { char c; int a; };",
+			"struct d3",
+			false,
+			8,
+		},
+	}
+
+	for _, testCase := range testCases {
+		structs, err := layout.ExtractAggregates("", testCase.test, false, abi.DefaultTarget())
+		if err != nil {
+			t.Errorf("Unexpected error when parsing %s: %s", testCase.test, err)
+			continue
+		}
+
+		meta, err := structs.ResolveMeta(testCase.name)
+		if err != nil {
+			t.Errorf("Unexpected error when resolving %s: %s", testCase.test, err)
+			continue
+		}
+
+		unpacked, canDrop, err := DropPacking(structs, testCase.name, meta, Constraints{})
+		if err != nil {
+			t.Errorf("Unexpected error when unpacking %s: %s", testCase.test, err)
+			continue
+		}
+
+		if canDrop != testCase.canDrop {
+			t.Errorf("Expected drop: %t: got: %t for '%s'", testCase.canDrop,
+				canDrop, testCase.test)
+		}
+
+		if unpacked.Size != testCase.expSize {
+			t.Errorf("Expected size: %d: got: %d for '%s'", testCase.expSize,
+				unpacked.Size, testCase.test)
+		}
+	}
+}
+
+func TestOptimizeKeepsAlignment(t *testing.T) {
+	const test = "struct o1 { char c; short s; _Alignas(16) char d; int i; };"
+
+	structs, err := layout.ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	meta, err := structs.ResolveMeta("struct o1")
+	if err != nil {
+		t.Fatalf("Unexpected error when resolving %s: %s", test, err)
+	}
+
+	optMeta, err := Optimize(structs, "struct o1", meta, Constraints{})
+	if err != nil {
+		t.Fatalf("Unexpected error when optimizing %s: %s", test, err)
+	}
+
+	if optMeta.Size != 16 || optMeta.Alignment != 16 {
+		t.Errorf("Expected size/alignment: 16/16: got: %d/%d", optMeta.Size,
+			optMeta.Alignment)
+	}
+
+	first := optMeta.Layout[0]
+	if first.Declaration() != "d" || first.Alignment() != 16 {
+		t.Errorf("Expected over-aligned field first, got %s with alignment %d",
+			first.Declaration(), first.Alignment())
+	}
+
+	// the aggregate within the context keeps its order
+	meta, err = structs.ResolveMeta("struct o1")
+	if err != nil || meta.Layout[0].Declaration() != "c" {
+		t.Errorf("Expected the original layout to be unchanged: got %v, %v", meta.Layout, err)
+	}
+}
+
+func TestOptimizeFlexibleArray(t *testing.T) {
+	testCases := []struct {
+		test       string
+		name       string
+		expOptSize int
+		expOptLast string
+	}{
+		{"struct f1 { int n; char c; double d[]; };", "struct f1", 8, "d[]"},
+		{"struct f2 { char c; int m[][3]; };", "struct f2", 4, "m[][3]"},
+		{"struct f3 { char c; double *p[0]; };", "struct f3", 8, "p[0]"},
+		{"struct f4 { char c; int z[0]; double d; };", "struct f4", 16, "z[0]"},
+		{"struct f5 { char c; double d; short s[]; };", "struct f5", 16, "s[]"},
+	}
+
+	for _, testCase := range testCases {
+		structs, err := layout.ExtractAggregates("", testCase.test, false, abi.DefaultTarget())
+		if err != nil {
+			t.Errorf("Unexpected error when parsing %s: %s", testCase.test, err)
+			continue
+		}
+
+		meta, err := structs.ResolveMeta(testCase.name)
+		if err != nil {
+			t.Errorf("Unexpected error when resolving %s: %s", testCase.test, err)
+			continue
+		}
+
+		optMeta, err := Optimize(structs, testCase.name, meta, Constraints{})
+		if err != nil {
+			t.Errorf("Unexpected error when optimizing %s: %s", testCase.test, err)
+			continue
+		}
+
+		last := optMeta.Layout[len(optMeta.Layout)-1]
+		if optMeta.Size != testCase.expOptSize || last.Declaration() != testCase.expOptLast {
+			t.Errorf("Expected optimized size %d ending with %s: got: %d ending with %s",
+				testCase.expOptSize, testCase.expOptLast, optMeta.Size, last.Declaration())
+		}
+
+		if len(optMeta.Diagnostics) != 0 {
+			t.Errorf("Expected no diagnostics after optimizing '%s': got: %v",
+				testCase.test, optMeta.Diagnostics)
+		}
+	}
+}
+
+func TestOptimizeMinimal(t *testing.T) {
+	testCases := []struct {
+		test     string
+		name     string
+		expSize  int
+		expOrder []string
+	}{
+		// sorting by alignment would take 24 bytes
+		{
+			"struct m1 { _Alignas(8) char a; _Alignas(8) char b; int i; int j; };",
+			"struct m1",
+			16,
+			[]string{"a", "i", "b", "j"},
+		},
+		// equal sizes keep the order closest to the original one
+		{
+			"struct m2 { char c; double d; char e; };",
+			"struct m2",
+			16,
+			[]string{"c", "e", "d"},
+		},
+		{
+			"struct m3 { int a:20; char c; int b:20; char d; };",
+			"struct m3",
+			8,
+			[]string{"a:20", "c", "b:20", "d"},
+		},
+		{
+			"struct m4 { char c; int a:20; char d; int b:20; };",
+			"struct m4",
+			8,
+			[]string{"c", "a:20", "d", "b:20"},
+		},
+		{
+			"struct m5 { struct __attribute__((packed)) { char x; int y; } p; int i; char c; char d; };",
+			"struct m5",
+			12,
+			[]string{"i", "p", "c", "d"},
+		},
+		{
+			"union m6 { char c; double d; };",
+			"union m6",
+			8,
+			[]string{"c", "d"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		structs, err := layout.ExtractAggregates("", testCase.test, false, abi.DefaultTarget())
+		if err != nil {
+			t.Errorf("Unexpected error when parsing %s: %s", testCase.test, err)
+			continue
+		}
+
+		meta, err := structs.ResolveMeta(testCase.name)
+		if err != nil {
+			t.Errorf("Unexpected error when resolving %s: %s", testCase.test, err)
+			continue
+		}
+
+		optMeta, err := Optimize(structs, testCase.name, meta, Constraints{})
+		if err != nil {
+			t.Errorf("Unexpected error when optimizing %s: %s", testCase.test, err)
+			continue
+		}
+
+		var order []string
+		for _, fLayout := range optMeta.Layout {
+			order = append(order, fLayout.Declaration())
+		}
+
+		if optMeta.Size != testCase.expSize || !slices.Equal(order, testCase.expOrder) {
+			t.Errorf("Expected size %d with order %v: got: %d with order %v for '%s'",
+				testCase.expSize, testCase.expOrder, optMeta.Size, order, testCase.test)
+		}
+	}
+}
+
+func TestOptimizeExact(t *testing.T) {
+	declarations := []string{
+		"char c%d;", "short s%d;", "int i%d;", "double d%d;", "char a%d[3];",
+		"_Alignas(8) char x%d;", "int b%d:5;", "short h%d:9;", "char :3;",
+		"long long l%d:40;", "struct __attribute__((packed)) { char x; int y; } p%d;",
+	}
+
+	rng := rand.New(rand.NewPCG(17, 42))
+	for iteration := range 200 {
+		var source strings.Builder
+		fmt.Fprintf(&source, "struct e%d { ", iteration)
+		for idx := range 2 + rng.IntN(5) {
+			decl := declarations[rng.IntN(len(declarations))]
+			if strings.Contains(decl, "%d") {
+				decl = fmt.Sprintf(decl, idx)
+			}
+			source.WriteString(decl + " ")
+		}
+		source.WriteString("};")
+
+		var (
+			test = source.String()
+			name = fmt.Sprintf("struct e%d", iteration)
+		)
+
+		structs, err := layout.ExtractAggregates("", test, false, abi.DefaultTarget())
+		if err != nil {
+			t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+		}
+
+		agg, _ := structs.Lookup(name)
+		searcher, err := newSearcher(structs, agg)
+		if err != nil {
+			t.Fatalf("Unexpected error when resolving %s: %s", test, err)
+		}
+
+		indexes := make([]int, len(agg.Fields))
+		for idx := range indexes {
+			indexes[idx] = idx
+		}
+
+		searcher.units, searcher.rules, err = Constraints{}.resolve(agg, indexes)
+		if err != nil {
+			t.Fatalf("Unexpected error when resolving %s: %s", test, err)
+		}
+
+		order, ok, err := searcher.exact()
+		if err != nil || !ok {
+			t.Fatalf("Expected an exact search for %s: got: %t, %v", test, ok, err)
+		}
+
+		size, _ := searcher.size(order)
+		found := cost{size, inversions(order)}
+
+		// compare against every possible ordering that follows the rules, i.e.
+		// keeps the unnamed bit-fields in place
+		var best *cost
+		for perm := range permutations(indexes) {
+			if !searcher.feasible(perm) {
+				continue
+			}
+
+			size, _ := searcher.size(perm)
+			curr := cost{size, inversions(perm)}
+			if best == nil || curr.compare(*best) < 0 {
+				best = &curr
+			}
+		}
+
+		if found != *best {
+			t.Errorf("Expected size/distance %d/%d: got: %d/%d for '%s'", best.size,
+				best.distance, found.size, found.distance, test)
+		}
+	}
+}
+
+func TestOptimizeHeuristic(t *testing.T) {
+	const test = `struct h1 { char a1[1]; short s1; char a2[2]; int i1; char a3[3];
+	long long l1; char a4[4]; _Alignas(16) char x; char a5[5]; short s3[3];
+	char a6[6]; int i3[3]; char a7[7]; long long l3[3]; char a8[8];
+	_Alignas(4) char y; char a9[9]; short s5[5]; };`
+
+	structs, err := layout.ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	meta, err := structs.ResolveMeta("struct h1")
+	if err != nil {
+		t.Fatalf("Unexpected error when resolving %s: %s", test, err)
+	}
+
+	agg, _ := structs.Lookup("struct h1")
+	searcher, err := newSearcher(structs, agg)
+	if err != nil {
+		t.Fatalf("Unexpected error when resolving %s: %s", test, err)
+	}
+
+	searcher.units, searcher.rules, err = Constraints{}.resolve(agg, []int{0, 1,
+		2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17})
+	if err != nil {
+		t.Fatalf("Unexpected error when resolving %s: %s", test, err)
+	}
+
+	if _, ok, _ := searcher.exact(); ok {
+		t.Errorf("Expected the exact search to be skipped for %s", test)
+	}
+
+	optMeta, err := Optimize(structs, "struct h1", meta, Constraints{})
+	if err != nil {
+		t.Fatalf("Unexpected error when optimizing %s: %s", test, err)
+	}
+
+	// 113 bytes of fields, with a 16 bytes alignment
+	if optMeta.Size != 128 {
+		t.Errorf("Expected optimized size 128: got: %d (from %d)", optMeta.Size, meta.Size)
+	}
+}
+
+// permutations yields every permutation of the passed elements.
+func permutations(elems []int) func(func([]int) bool) {
+	return func(yield func([]int) bool) {
+		var permute func(int) bool
+		permute = func(k int) bool {
+			if k == len(elems) {
+				return yield(slices.Clone(elems))
+			}
+
+			for idx := k; idx < len(elems); idx++ {
+				elems[k], elems[idx] = elems[idx], elems[k]
+				ok := permute(k + 1)
+				elems[k], elems[idx] = elems[idx], elems[k]
+				if !ok {
+					return false
+				}
+			}
+			return true
+		}
+		permute(0)
+	}
+}
@@ -0,0 +1,551 @@
+package optimize
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+
+	"github.com/Abathargh/stropt/layout"
+)
+
+const (
+	// maxStates bounds the amount of orderings the exact search may consider,
+	// counting the fields that can be swapped without changing the layout as
+	// one; beyond it, the heuristic search is used.
+	maxStates = 1 << 16
+
+	// maxPasses bounds the amount of times the heuristic search goes through
+	// every field looking for a better position for it.
+	maxPasses = 16
+)
+
+// A member is a field of the struct being optimized, alongside with its
+// metadata within the aggregate.
+type member struct {
+	field layout.Field
+	meta  layout.AggregateMeta
+}
+
+// A unit is a sequence of members which are always placed next to each
+// other, in order, i.e. a single member or a group of them. Members are
+// indexes within the fields of the aggregate.
+type unit []int
+
+// A memberKey identifies the members that can be swapped without changing
+// the layout of the aggregate, i.e. those that are placed in the same way.
+type memberKey struct {
+	size      int
+	alignment int
+	width     int
+	bitField  bool
+	packed    bool
+}
+
+// A cost measures an ordering of the fields: its size in bits and its
+// distance from the original one, i.e. the number of pairs of fields that
+// were swapped. Lower costs are better, comparing sizes first.
+type cost struct {
+	size     int
+	distance int
+}
+
+func (c cost) compare(other cost) int {
+	if c.size != other.size {
+		return cmp.Compare(c.size, other.size)
+	}
+	return cmp.Compare(c.distance, other.distance)
+}
+
+// A searcher looks for the ordering of the members of a struct with the
+// smallest size, and among those, for the one closest to the original order,
+// placing them as units which follow the passed rules.
+type searcher struct {
+	agg       *layout.Aggregate
+	members   []member
+	alignBits int
+
+	units []unit
+	rules rules
+}
+
+// newSearcher resolves the members of the passed struct, in order to search
+// for its best ordering.
+func newSearcher(ctx layout.Context, agg *layout.Aggregate) (*searcher, error) {
+	metas, maxAlign, err := ctx.Members(agg)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]member, len(agg.Fields))
+	for idx, field := range agg.Fields {
+		members[idx] = member{field, metas[idx]}
+	}
+	return &searcher{agg: agg, members: members, alignBits: maxAlign * 8}, nil
+}
+
+// search returns the best ordering for the passed units, following the
+// passed rules, as indexes within the fields of the aggregate. The exact
+// search is used if the amount of orderings to consider is small enough,
+// otherwise the heuristic one.
+func (s *searcher) search(units []unit, r rules) ([]int, error) {
+	s.units, s.rules = units, r
+
+	order, ok, err := s.exact()
+	if !ok && err == nil {
+		order, err = s.heuristic()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return s.flatten(order), nil
+}
+
+// flatten returns the members of the passed units, in order.
+func (s *searcher) flatten(order []int) []int {
+	var members []int
+	for _, id := range order {
+		members = append(members, s.units[id]...)
+	}
+	return members
+}
+
+// place places the passed unit right after the passed bit position,
+// returning the position of its end.
+func (s *searcher) place(pos, id int) (int, error) {
+	for _, idx := range s.units[id] {
+		start, width, err := s.agg.Place(pos, s.members[idx].field, s.members[idx].meta)
+		if err != nil {
+			return -1, err
+		}
+		pos = start + width
+	}
+	return pos, nil
+}
+
+// size computes the size in bits of the aggregate, if its units were placed
+// in the passed order.
+func (s *searcher) size(order []int) (int, error) {
+	pos := 0
+	for _, id := range order {
+		end, err := s.place(pos, id)
+		if err != nil {
+			return -1, err
+		}
+		pos = end
+	}
+	return alignUp(pos, s.alignBits), nil
+}
+
+// feasible checks whether the passed ordering of the units follows the
+// rules.
+func (s *searcher) feasible(order []int) bool {
+	var (
+		pos    = 0
+		placed = make([]bool, len(s.units))
+	)
+
+	isPlaced := func(id int) bool { return placed[id] }
+	for _, id := range order {
+		if !s.rules.allows(id, len(s.units[id]), pos, isPlaced) {
+			return false
+		}
+		placed[id], pos = true, pos+len(s.units[id])
+	}
+	return true
+}
+
+// period returns a number of bits such that moving any member forward by a
+// multiple of it moves its placement by the same amount, i.e. a multiple of
+// the alignment of every member and of the aggregate.
+func (s *searcher) period() int {
+	period := s.alignBits
+	for _, member := range s.members {
+		period = lcm(period, max(member.meta.Alignment, 1)*8)
+	}
+	return period
+}
+
+// key returns the key shared by the members that can be swapped with the
+// passed one without changing the layout of the aggregate.
+func (s *searcher) key(idx int) memberKey {
+	var (
+		meta = s.members[idx].meta
+		key  = memberKey{size: meta.Size, alignment: meta.Alignment}
+	)
+
+	if bitField, isBitField := s.members[idx].field.(layout.BitField); isBitField {
+		key.width, key.bitField, key.packed = bitField.Width, true, bitField.Packed
+	}
+	return key
+}
+
+// A step is the best way found by the exact search to place a set of
+// units, ending at a given position within the period.
+type step struct {
+	pos      int
+	distance int
+	class    int // the class of the last unit placed
+	residue  int // the residue of the previous step
+}
+
+// exact searches for the best ordering of the units, by placing them one at
+// a time. Single members that can be swapped without changing the layout,
+// and are not subject to any rule, are grouped into classes, and always
+// placed in their original order, while every other unit makes up a class
+// on its own, so that the set of placed units is described by how many of
+// each class have been placed. Two ways of placing the same units that end
+// at the same position within the period are then equivalent, except for the
+// one ending first, which is always better, and the closest to the original
+// order. It returns false if there are too many sets of units to consider.
+func (s *searcher) exact() ([]int, bool, error) {
+	var (
+		classes [][]int
+		byKey   = map[memberKey]int{}
+	)
+
+	for id, members := range s.units {
+		if len(members) != 1 || s.rules.constrained(id) {
+			classes = append(classes, []int{id})
+			continue
+		}
+
+		key := s.key(members[0])
+		class, ok := byKey[key]
+		if !ok {
+			class = len(classes)
+			byKey[key] = class
+			classes = append(classes, nil)
+		}
+		classes[class] = append(classes[class], id)
+	}
+
+	// each set of placed units is identified by a mixed-radix number, whose
+	// digits are the amount of placed units for each class
+	var (
+		strides = make([]int, len(classes))
+		classOf = make([]int, len(s.units))
+		rankOf  = make([]int, len(s.units))
+		states  = 1
+	)
+
+	for class, units := range classes {
+		strides[class] = states
+		states *= len(units) + 1
+		if states > maxStates {
+			return nil, false, nil
+		}
+
+		for rank, id := range units {
+			classOf[id], rankOf[id] = class, rank
+		}
+	}
+
+	var (
+		period = s.period()
+		steps  = make([]map[int]step, states)
+		counts = make([]int, len(classes))
+	)
+
+	isPlaced := func(id int) bool { return counts[classOf[id]] > rankOf[id] }
+
+	steps[0] = map[int]step{0: {class: -1}}
+	for state := range steps {
+		// placing a unit always moves to a greater state, so that every state
+		// is complete once it is reached
+		placed := 0
+		for class, stride := range strides {
+			counts[class] = state / stride % (len(classes[class]) + 1)
+			for _, id := range classes[class][:counts[class]] {
+				placed += len(s.units[id])
+			}
+		}
+
+		for _, residue := range sortedKeys(steps[state]) {
+			curr := steps[state][residue]
+			for class, units := range classes {
+				if counts[class] == len(units) {
+					continue
+				}
+
+				id := units[counts[class]]
+				if !s.rules.allows(id, len(s.units[id]), placed, isPlaced) {
+					continue
+				}
+
+				end, err := s.place(curr.pos, id)
+				if err != nil {
+					return nil, false, err
+				}
+
+				next := step{
+					pos:      end,
+					distance: curr.distance + s.displaced(classes, counts, id),
+					class:    class,
+					residue:  residue,
+				}
+
+				nextState := state + strides[class]
+				if steps[nextState] == nil {
+					steps[nextState] = map[int]step{}
+				}
+
+				prev, ok := steps[nextState][end%period]
+				if !ok || next.pos < prev.pos ||
+					(next.pos == prev.pos && next.distance < prev.distance) {
+					steps[nextState][end%period] = next
+				}
+			}
+		}
+	}
+
+	var (
+		last    = states - 1
+		best    cost
+		residue = -1
+	)
+
+	for _, curr := range sortedKeys(steps[last]) {
+		candidate := cost{
+			size:     alignUp(steps[last][curr].pos, s.alignBits),
+			distance: steps[last][curr].distance,
+		}
+
+		if residue == -1 || candidate.compare(best) < 0 {
+			best, residue = candidate, curr
+		}
+	}
+
+	if residue == -1 {
+		return nil, false, ErrConstraint
+	}
+
+	// walk back from the last state to rebuild the ordering
+	var (
+		order = make([]int, len(s.units))
+		state = last
+	)
+
+	for pos := len(order) - 1; pos >= 0; pos-- {
+		curr := steps[state][residue]
+		for class, stride := range strides {
+			counts[class] = state / stride % (len(classes[class]) + 1)
+		}
+
+		order[pos] = classes[curr.class][counts[curr.class]-1]
+		state, residue = state-strides[curr.class], curr.residue
+	}
+	return order, true, nil
+}
+
+// sortedKeys returns the residues of the passed steps in ascending order, so
+// that the search is deterministic.
+func sortedKeys(steps map[int]step) []int {
+	return slices.Sorted(maps.Keys(steps))
+}
+
+// displaced counts the pairs made of a member of the passed unit and one of
+// the units already placed, as described by counts, that came after it in
+// the original order.
+func (s *searcher) displaced(classes [][]int, counts []int, id int) int {
+	displaced := 0
+	for class, units := range classes {
+		for _, other := range units[:counts[class]] {
+			for _, placed := range s.units[other] {
+				for _, idx := range s.units[id] {
+					if placed > idx {
+						displaced++
+					}
+				}
+			}
+		}
+	}
+	return displaced
+}
+
+// heuristic searches for a good ordering of the units, starting from the
+// best between the original one and the one sorted by descending alignment,
+// each adjusted to follow the rules, and then moving one unit at a time to
+// the position which improves the ordering the most, until no move improves
+// it or the amount of passes is exhausted.
+func (s *searcher) heuristic() ([]int, error) {
+	var (
+		original = make([]int, len(s.units))
+		aligns   = make([]int, len(s.units))
+	)
+
+	for id, members := range s.units {
+		original[id] = id
+		for _, idx := range members {
+			aligns[id] = max(aligns[id], s.members[idx].meta.Alignment)
+		}
+	}
+
+	sorted := slices.Clone(original)
+	slices.SortStableFunc(sorted, func(i, j int) int {
+		return cmp.Compare(aligns[j], aligns[i])
+	})
+
+	var candidates [][]int
+	for _, preference := range [][]int{original, sorted} {
+		if order, ok := s.arrange(preference); ok {
+			candidates = append(candidates, order)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, ErrConstraint
+	}
+
+	order, best, err := s.best(candidates...)
+	if err != nil {
+		return nil, err
+	}
+
+	for range maxPasses {
+		improved := false
+		for from := range order {
+			for to := range order {
+				if from == to {
+					continue
+				}
+
+				candidate := move(order, from, to)
+				if !s.feasible(candidate) {
+					continue
+				}
+
+				size, err := s.size(candidate)
+				if err != nil {
+					return nil, err
+				}
+
+				curr := cost{size, best.distance + s.moveDistance(order, from, to)}
+				if curr.compare(best) < 0 {
+					order, best, improved = candidate, curr, true
+				}
+			}
+		}
+
+		if !improved {
+			break
+		}
+	}
+	return order, nil
+}
+
+// arrange builds an ordering of the units which follows the rules, picking
+// each time the first unit in the passed order of preference that can be
+// placed. It returns false if it gets stuck.
+func (s *searcher) arrange(preference []int) ([]int, bool) {
+	var (
+		order  []int
+		pos    = 0
+		placed = make([]bool, len(s.units))
+	)
+
+	isPlaced := func(id int) bool { return placed[id] }
+	for len(order) != len(s.units) {
+		next := slices.IndexFunc(preference, func(id int) bool {
+			return !placed[id] && s.rules.allows(id, len(s.units[id]), pos, isPlaced)
+		})
+
+		if next == -1 {
+			return nil, false
+		}
+
+		id := preference[next]
+		order, placed[id], pos = append(order, id), true, pos+len(s.units[id])
+	}
+	return order, true
+}
+
+// best returns the best of the passed orderings, alongside with its cost.
+func (s *searcher) best(orders ...[]int) ([]int, cost, error) {
+	var (
+		bestOrder []int
+		bestCost  cost
+	)
+
+	for _, order := range orders {
+		size, err := s.size(order)
+		if err != nil {
+			return nil, cost{}, err
+		}
+
+		curr := cost{size, inversions(s.flatten(order))}
+		if bestOrder == nil || curr.compare(bestCost) < 0 {
+			bestOrder, bestCost = order, curr
+		}
+	}
+	return bestOrder, bestCost, nil
+}
+
+// move returns a copy of the passed ordering, with the element at from moved
+// to the position to.
+func move(order []int, from, to int) []int {
+	moved := slices.Delete(slices.Clone(order), from, from+1)
+	return slices.Insert(moved, to, order[from])
+}
+
+// moveDistance computes how much the distance from the original order, i.e.
+// the amount of inversions, changes when moving the unit at from to the
+// position to. Only the pairs made of a member of the moved unit and one of
+// the units it skips over are affected.
+func (s *searcher) moveDistance(order []int, from, to int) int {
+	var (
+		delta   = 0
+		lo, hi  = from + 1, to + 1
+		forward = true
+	)
+
+	if to < from {
+		lo, hi, forward = to, from, false
+	}
+
+	for _, other := range order[lo:hi] {
+		for _, moved := range s.units[order[from]] {
+			for _, skipped := range s.units[other] {
+				// moving forward, the moved member ends up after the skipped
+				// ones, which fixes the pairs where it was greater, and
+				// breaks the others
+				if (moved > skipped) == forward {
+					delta--
+				} else {
+					delta++
+				}
+			}
+		}
+	}
+	return delta
+}
+
+// inversions counts the pairs of elements that are not in ascending order.
+func inversions(order []int) int {
+	count := 0
+	for i := range order {
+		for j := i + 1; j < len(order); j++ {
+			if order[i] > order[j] {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// alignUp rounds the passed value up to the next multiple of alignment.
+func alignUp(value, alignment int) int {
+	if alignment <= 1 {
+		return value
+	}
+	return (value + alignment - 1) / alignment * alignment
+}
+
+// lcm computes the least common multiple of two positive numbers.
+func lcm(a, b int) int {
+	x, y := a, b
+	for y != 0 {
+		x, y = y, x%y
+	}
+	return a / x * b
+}
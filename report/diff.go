@@ -0,0 +1,263 @@
+package report
+
+// The status of an aggregate or of a field within a Diff.
+const (
+	StatusAdded     = "added"
+	StatusRemoved   = "removed"
+	StatusChanged   = "changed"
+	StatusUnchanged = "unchanged"
+)
+
+// The changes that can be reported for a field found within both versions
+// of an aggregate: a moved field changes place with respect to the others,
+// while its offset may shift even if it keeps its place.
+const (
+	ChangeMoved     = "moved"
+	ChangeOffset    = "offset"
+	ChangeSize      = "size"
+	ChangeAlignment = "alignment"
+	ChangeType      = "type"
+	ChangeValue     = "value"
+)
+
+// A Diff describes how the layout of an aggregate changed between an old
+// and a new version of it, e.g. before and after editing a header. Sizes,
+// alignments and paddings are expressed in bytes. Fields only lists the
+// fields which changed, including the ones of the sub-aggregates, which are
+// named after their path, e.g. `pos::x`.
+type Diff struct {
+	Name      string      `json:"name" yaml:"name"`
+	Kind      string      `json:"kind" yaml:"kind"`
+	Status    string      `json:"status" yaml:"status"`
+	Size      Change      `json:"size" yaml:"size"`
+	Alignment Change      `json:"alignment" yaml:"alignment"`
+	Padding   Change      `json:"padding" yaml:"padding"`
+	Fields    []FieldDiff `json:"fields" yaml:"fields"`
+}
+
+// A Change holds the old and new value of a property of an aggregate.
+type Change struct {
+	Old int `json:"old" yaml:"old"`
+	New int `json:"new" yaml:"new"`
+}
+
+// A FieldDiff describes how a field changed: whether it was added, removed,
+// or changed, in which case Changes lists what changed about it, e.g. its
+// offset. Old and New describe the field within each version, without their
+// sub-fields, and are missing for removed and added fields respectively.
+type FieldDiff struct {
+	Name    string   `json:"name" yaml:"name"`
+	Status  string   `json:"status" yaml:"status"`
+	Changes []string `json:"changes,omitempty" yaml:"changes,omitempty"`
+	Old     *Field   `json:"old,omitempty" yaml:"old,omitempty"`
+	New     *Field   `json:"new,omitempty" yaml:"new,omitempty"`
+}
+
+// Changed reports whether the value changed.
+func (c Change) Changed() bool {
+	return c.Old != c.New
+}
+
+// Compare describes how the layout of an aggregate changed from the report
+// of its old version, before, to the one of its new version, after. Either
+// of them may be nil, for aggregates which were added or removed. Fields are
+// matched by name, or by declaration or type if they have none, in order.
+func Compare(before, after *Aggregate) Diff {
+	switch {
+	case before == nil && after == nil:
+		return Diff{Status: StatusUnchanged, Fields: []FieldDiff{}}
+	case before == nil:
+		return Diff{
+			Name:      after.Name,
+			Kind:      after.Kind,
+			Status:    StatusAdded,
+			Size:      Change{New: after.Size},
+			Alignment: Change{New: after.Alignment},
+			Padding:   Change{New: after.Padding},
+			Fields:    []FieldDiff{},
+		}
+	case after == nil:
+		return Diff{
+			Name:      before.Name,
+			Kind:      before.Kind,
+			Status:    StatusRemoved,
+			Size:      Change{Old: before.Size},
+			Alignment: Change{Old: before.Alignment},
+			Padding:   Change{Old: before.Padding},
+			Fields:    []FieldDiff{},
+		}
+	}
+
+	diff := Diff{
+		Name:      after.Name,
+		Kind:      after.Kind,
+		Status:    StatusUnchanged,
+		Size:      Change{before.Size, after.Size},
+		Alignment: Change{before.Alignment, after.Alignment},
+		Padding:   Change{before.Padding, after.Padding},
+		Fields:    compareFields("", before.Fields, after.Fields),
+	}
+
+	if diff.Size.Changed() || diff.Alignment.Changed() || diff.Padding.Changed() ||
+		len(diff.Fields) != 0 || before.Kind != after.Kind {
+		diff.Status = StatusChanged
+	}
+	return diff
+}
+
+// compareFields returns the changes between the old and new version of a
+// list of fields, whose names are prefixed by the passed path. Removed
+// fields are listed first, followed by the others, in their new order.
+func compareFields(path string, before, after []Field) []FieldDiff {
+	var (
+		positions = matchFields(before, after)
+		kept      = keptInOrder(positions)
+		matched   = make([]bool, len(before))
+		diffs     = []FieldDiff{}
+	)
+
+	for _, pos := range positions {
+		if pos != -1 {
+			matched[pos] = true
+		}
+	}
+
+	for pos, field := range before {
+		if !matched[pos] {
+			diffs = append(diffs, FieldDiff{
+				Name:   path + fieldLabel(field),
+				Status: StatusRemoved,
+				Old:    withoutFields(field),
+			})
+		}
+	}
+
+	for idx, field := range after {
+		name := path + fieldLabel(field)
+		if positions[idx] == -1 {
+			diffs = append(diffs, FieldDiff{
+				Name:   name,
+				Status: StatusAdded,
+				New:    withoutFields(field),
+			})
+			continue
+		}
+
+		var (
+			prev    = before[positions[idx]]
+			changes []string
+		)
+
+		if !kept[idx] {
+			changes = append(changes, ChangeMoved)
+		}
+
+		if prev.BitOffset != field.BitOffset {
+			changes = append(changes, ChangeOffset)
+		}
+
+		if prev.BitSize != field.BitSize {
+			changes = append(changes, ChangeSize)
+		}
+
+		if prev.Alignment != field.Alignment {
+			changes = append(changes, ChangeAlignment)
+		}
+
+		if prev.Type != field.Type || prev.Declaration != field.Declaration {
+			changes = append(changes, ChangeType)
+		}
+
+		if (prev.Value == nil) != (field.Value == nil) ||
+			prev.Value != nil && *prev.Value != *field.Value {
+			changes = append(changes, ChangeValue)
+		}
+
+		if len(changes) != 0 {
+			diffs = append(diffs, FieldDiff{
+				Name:    name,
+				Status:  StatusChanged,
+				Changes: changes,
+				Old:     withoutFields(prev),
+				New:     withoutFields(field),
+			})
+		}
+		diffs = append(diffs, compareFields(name+"::", prev.Fields, field.Fields)...)
+	}
+	return diffs
+}
+
+// matchFields returns the position of each of the new fields within the old
+// ones, or -1 for the added ones. Fields with the same label, e.g. unnamed
+// bit-fields, are matched in order.
+func matchFields(before, after []Field) []int {
+	var (
+		positions = make([]int, len(after))
+		used      = make([]bool, len(before))
+	)
+
+	for idx, field := range after {
+		positions[idx] = -1
+		for pos, other := range before {
+			if !used[pos] && fieldLabel(other) == fieldLabel(field) {
+				positions[idx], used[pos] = pos, true
+				break
+			}
+		}
+	}
+	return positions
+}
+
+// keptInOrder marks the matched fields which keep their relative order, i.e.
+// the longest sequence of them found in the same order within both versions,
+// so that the other ones are reported as moved. On ties, the later fields are
+// kept, so that a field moved to the front is the one reported as moved.
+func keptInOrder(positions []int) []bool {
+	var (
+		lengths = make([]int, len(positions))
+		prev    = make([]int, len(positions))
+		last    = -1
+	)
+
+	for idx, pos := range positions {
+		prev[idx] = -1
+		if pos == -1 {
+			continue
+		}
+
+		lengths[idx] = 1
+		for other := range idx {
+			if positions[other] != -1 && positions[other] < pos && lengths[other]+1 >= lengths[idx] {
+				lengths[idx], prev[idx] = lengths[other]+1, other
+			}
+		}
+
+		if last == -1 || lengths[idx] >= lengths[last] {
+			last = idx
+		}
+	}
+
+	kept := make([]bool, len(positions))
+	for idx := last; idx != -1; idx = prev[idx] {
+		kept[idx] = true
+	}
+	return kept
+}
+
+// fieldLabel returns how a field is referred to within a diff: its name, or
+// its declaration or type for unnamed bit-fields and anonymous members.
+func fieldLabel(field Field) string {
+	switch {
+	case field.Name != "":
+		return field.Name
+	case field.Declaration != "":
+		return field.Declaration
+	}
+	return field.Type
+}
+
+// withoutFields returns a copy of the passed field, without its sub-fields.
+func withoutFields(field Field) *Field {
+	field.Fields = nil
+	return &field
+}
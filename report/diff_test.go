@@ -0,0 +1,112 @@
+package report
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Abathargh/stropt/abi"
+	"github.com/Abathargh/stropt/layout"
+)
+
+func TestCompare(t *testing.T) {
+	const (
+		before = `struct c1 { char a; int b; char c; struct { char x; short y; } pos; };
+		struct c2 { int a; };
+		struct c3 { char a; };`
+		after = `struct c1 { char c; int b; long d; struct { short y; char x; } pos; };
+		struct c2 { int a; };
+		struct c4 { short a; };`
+	)
+
+	type fieldChange struct {
+		name    string
+		status  string
+		changes []string
+	}
+
+	testCases := []struct {
+		name    string
+		status  string
+		size    Change
+		changes []fieldChange
+	}{
+		{
+			"struct c1", StatusChanged, Change{16, 24},
+			[]fieldChange{
+				{"a", StatusRemoved, nil},
+				{"c", StatusChanged, []string{ChangeMoved, ChangeOffset}},
+				{"d", StatusAdded, nil},
+				{"pos", StatusChanged, []string{ChangeOffset}},
+				{"pos::y", StatusChanged, []string{ChangeMoved, ChangeOffset}},
+				{"pos::x", StatusChanged, []string{ChangeOffset}},
+			},
+		},
+		{"struct c2", StatusUnchanged, Change{4, 4}, nil},
+		{"struct c3", StatusRemoved, Change{1, 0}, nil},
+		{"struct c4", StatusAdded, Change{0, 2}, nil},
+	}
+
+	oldStructs, err := layout.ExtractAggregates("", before, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", before, err)
+	}
+
+	newStructs, err := layout.ExtractAggregates("", after, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", after, err)
+	}
+
+	resolve := func(structs layout.Context, name string) *Aggregate {
+		if _, ok := structs.Lookup(name); !ok {
+			return nil
+		}
+
+		meta, err := structs.ResolveMeta(name)
+		if err != nil {
+			t.Fatalf("Unexpected error when resolving %s: %s", name, err)
+		}
+
+		aggReport, err := NewAggregate(structs, name, meta, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error when building the report: %s", err)
+		}
+		return &aggReport
+	}
+
+	for _, testCase := range testCases {
+		diff := Compare(resolve(oldStructs, testCase.name), resolve(newStructs, testCase.name))
+		if diff.Name != testCase.name || diff.Status != testCase.status || diff.Size != testCase.size {
+			t.Errorf("Expected %s to be %s with size %+v: got: %s %s with size %+v",
+				testCase.name, testCase.status, testCase.size, diff.Name, diff.Status, diff.Size)
+		}
+
+		var changes []fieldChange
+		for _, field := range diff.Fields {
+			changes = append(changes, fieldChange{field.Name, field.Status, field.Changes})
+		}
+
+		if !reflect.DeepEqual(changes, testCase.changes) {
+			t.Errorf("%s: expected field changes %+v: got: %+v", testCase.name, testCase.changes, changes)
+		}
+	}
+}
+
+func TestKeptInOrder(t *testing.T) {
+	testCases := []struct {
+		positions []int
+		expected  []bool
+	}{
+		{[]int{}, []bool{}},
+		{[]int{0, 1, 2}, []bool{true, true, true}},
+		{[]int{2, 0, 1}, []bool{false, true, true}},
+		{[]int{1, -1, 0, 2}, []bool{false, false, true, true}},
+		{[]int{1, 0}, []bool{false, true}},
+		{[]int{-1, -1}, []bool{false, false}},
+	}
+
+	for _, testCase := range testCases {
+		if kept := keptInOrder(testCase.positions); !reflect.DeepEqual(kept, testCase.expected) {
+			t.Errorf("Expected %v to keep %v: got: %v", testCase.positions, testCase.expected, kept)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Package report builds the machine-readable description of the layout of
+// C aggregates, which can be encoded as JSON or YAML documents.
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Abathargh/stropt/layout"
+	"github.com/Abathargh/stropt/optimize"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// SchemaName identifies the documents produced by the JSON and YAML
+	// emitters.
+	SchemaName = "stropt-layout"
+
+	// SchemaVersion is the version of the schema of the JSON and YAML
+	// documents. It is increased whenever a change which is not backward
+	// compatible is made to it, while new fields may be added at any time.
+	SchemaVersion = 1
+)
+
+// A Report is the machine-readable description of the layout of a set of
+// aggregates, which can be encoded as JSON or YAML. Diffs describes how
+// their layout changed with respect to an older version, if compared.
+type Report struct {
+	Schema     string      `json:"schema" yaml:"schema"`
+	Version    int         `json:"version" yaml:"version"`
+	Aggregates []Aggregate `json:"aggregates" yaml:"aggregates"`
+	Diffs      []Diff      `json:"diffs,omitempty" yaml:"diffs,omitempty"`
+}
+
+// An Aggregate describes the layout of a single aggregate. Sizes,
+// alignments and paddings are expressed in bytes, and PaddingBits holds the
+// exact amount of padding, which may not be a whole number of bytes when
+// bit-fields are involved. Optimized holds the layout suggested when
+// optimizing the aggregate, if requested, with the Moves turning the
+// original layout into it, and the Nested aggregates to be re-ordered as
+// well when optimizing recursively. Target is the name of the target the
+// layout has been resolved for.
+type Aggregate struct {
+	Name        string     `json:"name" yaml:"name"`
+	Kind        string     `json:"kind" yaml:"kind"`
+	Target      string     `json:"target,omitempty" yaml:"target,omitempty"`
+	Underlying  string     `json:"underlying,omitempty" yaml:"underlying,omitempty"`
+	Size        int        `json:"size" yaml:"size"`
+	Alignment   int        `json:"alignment" yaml:"alignment"`
+	Padding     int        `json:"padding" yaml:"padding"`
+	PaddingBits int        `json:"padding_bits" yaml:"padding_bits"`
+	Fields      []Field    `json:"fields" yaml:"fields"`
+	Diagnostics []string   `json:"diagnostics,omitempty" yaml:"diagnostics,omitempty"`
+	Optimized   *Aggregate `json:"optimized,omitempty" yaml:"optimized,omitempty"`
+	Moves       []Move     `json:"moves,omitempty" yaml:"moves,omitempty"`
+	Nested      []Nested   `json:"nested,omitempty" yaml:"nested,omitempty"`
+}
+
+// A Field describes the layout of a field. Offset, Size and Padding
+// are expressed in bytes, rounded down, while their exact amount in bits is
+// held by BitOffset, BitSize and BitPadding, which only differ when
+// bit-fields are involved. Offsets are relative to the start of the
+// enclosing aggregate, i.e. the parent field for the nested Fields of a
+// field with an aggregate type. Enumerators carry their Value.
+type Field struct {
+	Name        string  `json:"name" yaml:"name"`
+	Declaration string  `json:"declaration" yaml:"declaration"`
+	Type        string  `json:"type" yaml:"type"`
+	Offset      int     `json:"offset" yaml:"offset"`
+	Size        int     `json:"size" yaml:"size"`
+	Alignment   int     `json:"alignment" yaml:"alignment"`
+	Padding     int     `json:"padding" yaml:"padding"`
+	BitOffset   int     `json:"bit_offset" yaml:"bit_offset"`
+	BitSize     int     `json:"bit_size" yaml:"bit_size"`
+	BitPadding  int     `json:"bit_padding" yaml:"bit_padding"`
+	Value       *int64  `json:"value,omitempty" yaml:"value,omitempty"`
+	Fields      []Field `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+// A Move describes how to move a field to get to the optimized layout: the
+// field is placed right after the After one, or first if After is empty.
+// Fields are referred to by name, or by their declaration or type if they
+// have none.
+type Move struct {
+	Field string `json:"field" yaml:"field"`
+	After string `json:"after,omitempty" yaml:"after,omitempty"`
+}
+
+// A Nested aggregate is an aggregate used within the reported one, whose
+// fields are re-ordered when optimizing recursively. Name is its type name,
+// or the path of the field it is defined inline within, and sizes are
+// expressed in bytes.
+type Nested struct {
+	Name          string `json:"name" yaml:"name"`
+	Size          int    `json:"size" yaml:"size"`
+	OptimizedSize int    `json:"optimized_size" yaml:"optimized_size"`
+	Moves         []Move `json:"moves" yaml:"moves"`
+}
+
+var (
+	ErrSchema = errors.New("unsupported document")
+
+	aggregateKinds = map[layout.AggregateKind]string{
+		layout.StructKind: "struct",
+		layout.UnionKind:  "union",
+		layout.EnumKind:   "enum",
+	}
+)
+
+// New builds an empty report using the current schema version.
+func New() Report {
+	return Report{
+		Schema:     SchemaName,
+		Version:    SchemaVersion,
+		Aggregates: []Aggregate{},
+	}
+}
+
+// NewAggregate builds the report for the layout of the aggregate identified
+// by name within ctx, with the passed metadata, and the optimized one if not
+// nil.
+func NewAggregate(ctx layout.Context, name string, meta layout.AggregateMeta,
+	optMeta *layout.AggregateMeta) (Aggregate, error) {
+	agg, ok := ctx.Lookup(name)
+	if !ok {
+		return Aggregate{}, fmt.Errorf("%w: %v", layout.ErrSymbol, name)
+	}
+
+	report := newAggregate(name, agg.Kind, meta)
+	report.Target = ctx.Target().Name
+	if optMeta != nil {
+		optReport := newAggregate(name, agg.Kind, *optMeta)
+		report.Optimized = &optReport
+		report.Moves = newMoves(optimize.Moves(meta.Layout, optMeta.Layout))
+	}
+	return report, nil
+}
+
+// NewNested builds the reports for the nested aggregates changed when
+// optimizing an aggregate recursively.
+func NewNested(changes []optimize.Change) []Nested {
+	var reports []Nested
+	for _, change := range changes {
+		reports = append(reports, Nested{
+			Name:          change.Name,
+			Size:          change.Original.Size,
+			OptimizedSize: change.Optimized.Size,
+			Moves:         newMoves(change.Moves),
+		})
+	}
+	return reports
+}
+
+// newMoves builds the reports for the passed moves.
+func newMoves(moves []optimize.Move) []Move {
+	var reports []Move
+	for _, move := range moves {
+		report := Move{Field: optimize.Label(move.Field)}
+		if move.After != nil {
+			report.After = optimize.Label(move.After)
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// newAggregate builds the report for an aggregate out of its metadata.
+func newAggregate(name string, kind layout.AggregateKind, meta layout.AggregateMeta) Aggregate {
+	report := Aggregate{
+		Name:        name,
+		Kind:        aggregateKinds[kind],
+		Underlying:  meta.Underlying,
+		Size:        meta.Size,
+		Alignment:   meta.Alignment,
+		Padding:     meta.Padding() / 8,
+		PaddingBits: meta.Padding(),
+		Fields:      newFields(meta.Layout),
+	}
+
+	for _, diagnostic := range meta.Diagnostics {
+		report.Diagnostics = append(report.Diagnostics, diagnostic.Error())
+	}
+	return report
+}
+
+// newFields builds the reports for the passed field layouts, recursively
+// including the ones of their sub-aggregates.
+func newFields(layouts []layout.Layout) []Field {
+	reports := make([]Field, 0, len(layouts))
+	for _, fLayout := range layouts {
+		report := Field{
+			Name:        layout.FieldName(fLayout.Field),
+			Declaration: fLayout.Declaration(),
+			Type:        strings.TrimSpace(fLayout.Type()),
+			Offset:      fLayout.Offset(),
+			Size:        fLayout.Size(),
+			Alignment:   fLayout.Alignment(),
+			Padding:     fLayout.Padding(),
+			BitOffset:   fLayout.BitOffset(),
+			BitSize:     fLayout.BitSize(),
+			BitPadding:  fLayout.BitPadding(),
+		}
+
+		if entry, isEntry := fLayout.Field.(layout.EnumEntry); isEntry {
+			report.Value = &entry.Value
+		}
+
+		if fields := fLayout.Fields(); fields != nil {
+			report.Fields = newFields(fields)
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// EncodeJSON writes the passed report to w as an indented JSON document.
+func EncodeJSON(w io.Writer, report Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// EncodeYAML writes the passed report to w as a YAML document.
+func EncodeYAML(w io.Writer, report Report) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// Decode reads a report from r, encoded either as JSON or YAML, e.g. one
+// previously written as a lockfile. Documents following another schema, or
+// another version of it, are rejected.
+func Decode(r io.Reader) (Report, error) {
+	var report Report
+	if err := yaml.NewDecoder(r).Decode(&report); err != nil {
+		return Report{}, fmt.Errorf("%w: %w", ErrSchema, err)
+	}
+
+	if report.Schema != SchemaName || report.Version != SchemaVersion {
+		return Report{}, fmt.Errorf("%w: expected %s version %d, got %q version %d",
+			ErrSchema, SchemaName, SchemaVersion, report.Schema, report.Version)
+	}
+	return report, nil
+}
@@ -0,0 +1,168 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Abathargh/stropt/abi"
+	"github.com/Abathargh/stropt/layout"
+	"github.com/Abathargh/stropt/optimize"
+	"gopkg.in/yaml.v3"
+)
+
+func TestReport(t *testing.T) {
+	const test = `struct r1 { char c; int b : 3; double d; enum { A = 2 } e;
+	struct { char x; short y; } in; };`
+
+	structs, err := layout.ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	meta, err := structs.ResolveMeta("struct r1")
+	if err != nil {
+		t.Fatalf("Unexpected error when resolving %s: %s", test, err)
+	}
+
+	optMeta, err := optimize.Optimize(structs, "struct r1", meta, optimize.Constraints{})
+	if err != nil {
+		t.Fatalf("Unexpected error when optimizing %s: %s", test, err)
+	}
+
+	aggReport, err := NewAggregate(structs, "struct r1", meta, &optMeta)
+	if err != nil {
+		t.Fatalf("Unexpected error when building the report: %s", err)
+	}
+
+	report := New()
+	report.Aggregates = append(report.Aggregates, aggReport)
+
+	if aggReport.Kind != "struct" || aggReport.Size != 24 || aggReport.PaddingBits != 53 {
+		t.Errorf("Expected struct of size 24 with 53 bits of padding: got: %s/%d/%d",
+			aggReport.Kind, aggReport.Size, aggReport.PaddingBits)
+	}
+
+	expected := []struct {
+		name      string
+		offset    int
+		bitOffset int
+		bitSize   int
+	}{
+		{"c", 0, 0, 8},
+		{"b", 1, 8, 3},
+		{"d", 8, 64, 64},
+		{"e", 16, 128, 32},
+		{"in", 20, 160, 32},
+	}
+
+	for idx, field := range aggReport.Fields {
+		exp := expected[idx]
+		if field.Name != exp.name || field.Offset != exp.offset ||
+			field.BitOffset != exp.bitOffset || field.BitSize != exp.bitSize {
+			t.Errorf("Expected field %s at %d (bit %d) of %d bits: got: %s at %d (bit %d) of %d bits",
+				exp.name, exp.offset, exp.bitOffset, exp.bitSize, field.Name,
+				field.Offset, field.BitOffset, field.BitSize)
+		}
+	}
+
+	enumerator := aggReport.Fields[3].Fields[0]
+	if enumerator.Value == nil || *enumerator.Value != 2 {
+		t.Errorf("Expected enumerator A with value 2: got: %+v", enumerator)
+	}
+
+	nested := aggReport.Fields[4].Fields[1]
+	if nested.Name != "y" || nested.Offset != 2 {
+		t.Errorf("Expected nested field y at 2: got: %s at %d", nested.Name, nested.Offset)
+	}
+
+	// the layout is already minimal, so the optimized one keeps its order
+	if aggReport.Optimized == nil || aggReport.Optimized.Fields[0].Name != "c" {
+		t.Errorf("Expected an optimized layout starting with c: got: %+v", aggReport.Optimized)
+	}
+
+	if len(aggReport.Moves) != 0 {
+		t.Errorf("Expected no moves for a minimal layout: got: %v", aggReport.Moves)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeJSON(&buf, report); err != nil {
+		t.Fatalf("Unexpected error when encoding JSON: %s", err)
+	}
+
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Unexpected error when decoding JSON: %s", err)
+	}
+
+	if !reflect.DeepEqual(decoded, report) {
+		t.Errorf("Different JSON report after decoding, got %+v - %+v", decoded, report)
+	}
+
+	buf.Reset()
+	if err := EncodeYAML(&buf, report); err != nil {
+		t.Fatalf("Unexpected error when encoding YAML: %s", err)
+	}
+
+	decoded = Report{}
+	if err := yaml.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Unexpected error when decoding YAML: %s", err)
+	}
+
+	if decoded.Schema != SchemaName || decoded.Version != SchemaVersion ||
+		!reflect.DeepEqual(decoded.Aggregates, report.Aggregates) {
+		t.Errorf("Different YAML report after decoding, got %+v - %+v", decoded, report)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	const test = `struct d1 { char c; int i; enum { A = 2 } e; };`
+
+	structs, err := layout.ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	meta, err := structs.ResolveMeta("struct d1")
+	if err != nil {
+		t.Fatalf("Unexpected error when resolving %s: %s", test, err)
+	}
+
+	aggReport, err := NewAggregate(structs, "struct d1", meta, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error when building the report: %s", err)
+	}
+
+	report := New()
+	report.Aggregates = append(report.Aggregates, aggReport)
+
+	encoders := map[string]func(*bytes.Buffer, Report) error{
+		"JSON": func(buf *bytes.Buffer, report Report) error { return EncodeJSON(buf, report) },
+		"YAML": func(buf *bytes.Buffer, report Report) error { return EncodeYAML(buf, report) },
+	}
+
+	for format, encode := range encoders {
+		var buf bytes.Buffer
+		if err := encode(&buf, report); err != nil {
+			t.Fatalf("Unexpected error when encoding %s: %s", format, err)
+		}
+
+		decoded, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("Unexpected error when decoding %s: %s", format, err)
+		}
+
+		if !reflect.DeepEqual(decoded, report) {
+			t.Errorf("Different %s report after decoding, got %+v - %+v", format, decoded, report)
+		}
+	}
+
+	for _, doc := range []string{"", "schema: other\nversion: 1", `{"schema": "stropt-layout", "version": 2}`} {
+		if _, err := Decode(strings.NewReader(doc)); !errors.Is(err, ErrSchema) {
+			t.Errorf("Expected an error when decoding %q: got %v", doc, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package rewrite
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// contextLines is the amount of unchanged lines shown around each change
+// within a unified diff.
+const contextLines = 3
+
+// A line of a diff, which is either kept, removed or added.
+type line struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+// Diff returns the unified diff turning the original text of the passed
+// file into the rewritten one, or an empty string if they are the same. The
+// file is labeled with the `a/` and `b/` prefixes, as git does.
+func Diff(file, original, rewritten string) string {
+	if original == rewritten {
+		return ""
+	}
+
+	var (
+		lines   = diffLines(splitLines(original), splitLines(rewritten))
+		builder strings.Builder
+	)
+
+	file = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(file)), "/")
+	fmt.Fprintf(&builder, "--- a/%s\n+++ b/%s\n", file, file)
+	for _, hunk := range hunks(lines) {
+		writeHunk(&builder, lines, hunk[0], hunk[1])
+	}
+	return builder.String()
+}
+
+// splitLines splits the passed text into lines, each of them keeping its
+// newline, if any.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes the shortest edit script turning a into b, through the
+// Myers algorithm.
+func diffLines(a, b []string) []line {
+	var (
+		n, m   = len(a), len(b)
+		offset = n + m + 1
+		v      = make([]int, 2*offset+1)
+		trace  [][]int
+	)
+
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, slices.Clone(v))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// walk the trace backwards, from the end of both texts
+	var (
+		lines []line
+		x, y  = n, m
+	)
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		var (
+			v     = trace[d]
+			k     = x - y
+			prevK = k - 1
+		)
+
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			lines = append(lines, line{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+
+		if d == 0 {
+			break
+		}
+
+		if x == prevX {
+			lines = append(lines, line{'+', b[y-1]})
+		} else {
+			lines = append(lines, line{'-', a[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+
+	slices.Reverse(lines)
+	return lines
+}
+
+// hunks groups the changed lines into hunks, each of them surrounded by
+// the unchanged lines providing its context, returning the index of the
+// first line of each hunk and of the one following its last.
+func hunks(lines []line) [][2]int {
+	var ranges [][2]int
+	for idx, curr := range lines {
+		if curr.kind == ' ' {
+			continue
+		}
+
+		start := max(idx-contextLines, 0)
+		end := min(idx+contextLines+1, len(lines))
+		if last := len(ranges) - 1; last >= 0 && start <= ranges[last][1] {
+			ranges[last][1] = end
+			continue
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges
+}
+
+// writeHunk writes the hunk made of the lines between the passed indexes,
+// preceded by its header.
+func writeHunk(builder *strings.Builder, lines []line, start, end int) {
+	var oldStart, newStart, oldLen, newLen int
+	for _, curr := range lines[:start] {
+		if curr.kind != '+' {
+			oldStart++
+		}
+		if curr.kind != '-' {
+			newStart++
+		}
+	}
+
+	for _, curr := range lines[start:end] {
+		if curr.kind != '+' {
+			oldLen++
+		}
+		if curr.kind != '-' {
+			newLen++
+		}
+	}
+
+	fmt.Fprintf(builder, "@@ -%s +%s @@\n", hunkRange(oldStart, oldLen),
+		hunkRange(newStart, newLen))
+	for _, curr := range lines[start:end] {
+		builder.WriteByte(curr.kind)
+		builder.WriteString(curr.text)
+		if !strings.HasSuffix(curr.text, "\n") {
+			builder.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the range of lines of a hunk, starting after the passed
+// amount of lines: empty ranges refer to the line preceding them.
+func hunkRange(before, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+
+	if length == 1 {
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, length)
+}
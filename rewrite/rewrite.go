@@ -0,0 +1,329 @@
+// Package rewrite applies the optimized layouts of C aggregates back to their
+// source code, moving the field declarations within their definitions while
+// keeping comments, attributes and formatting, and describes the changes as
+// unified diffs.
+package rewrite
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Abathargh/stropt/layout"
+)
+
+var (
+	ErrDefinition   = errors.New("cannot locate the definition within the source")
+	ErrOrder        = errors.New("invalid field order")
+	ErrPreprocessor = errors.New("cannot move fields across preprocessor conditionals")
+	ErrSplit        = errors.New("cannot split a declaration defining a type")
+)
+
+// A Rewriter re-orders the fields of the aggregates defined within a source
+// file, identified by the name used within the token positions of the
+// parsed aggregates, which is the one passed when extracting them.
+type Rewriter struct {
+	file     string
+	src      string
+	reorders []reorder
+}
+
+// A reorder holds the new order of the fields of an aggregate, as indexes
+// within its original fields.
+type reorder struct {
+	name  string
+	def   *layout.Definition
+	order []int
+}
+
+// An edit replaces the text within a span of the source.
+type edit struct {
+	span layout.Span
+	text string
+}
+
+// New returns a Rewriter for the passed source file and its content.
+func New(file, src string) *Rewriter {
+	return &Rewriter{file: file, src: src}
+}
+
+// Reorder schedules the rewrite of the aggregate identified by name, so that
+// its fields follow the passed order, holding the indexes of its fields,
+// e.g. as returned by optimize.Order. Aggregates which are scheduled more
+// than once are only rewritten the first time, while the ones keeping the
+// order of their fields are left as they are.
+func (r *Rewriter) Reorder(name string, agg *layout.Aggregate, order []int) error {
+	sorted := slices.Sorted(slices.Values(order))
+	for idx, field := range sorted {
+		if field != idx {
+			return fmt.Errorf("%w: %s: %v", ErrOrder, name, order)
+		}
+	}
+
+	if len(order) != len(agg.Fields) {
+		return fmt.Errorf("%w: %s: %v", ErrOrder, name, order)
+	}
+
+	// nothing to do if the fields keep their order
+	if slices.IsSorted(order) {
+		return nil
+	}
+
+	def := agg.Definition
+	if def == nil || def.Body.File != r.file || def.Body.End > len(r.src) {
+		return fmt.Errorf("%w: %s", ErrDefinition, name)
+	}
+
+	if slices.ContainsFunc(r.reorders, func(other reorder) bool {
+		return other.def.Body == def.Body
+	}) {
+		return nil
+	}
+
+	r.reorders = append(r.reorders, reorder{name, def, order})
+	return nil
+}
+
+// Changed reports whether any aggregate is to be rewritten.
+func (r *Rewriter) Changed() bool {
+	return len(r.reorders) != 0
+}
+
+// Rewrite returns the source with every scheduled aggregate rewritten. The
+// aggregates are rewritten from the innermost, so that the ones defined
+// inline within others are rewritten as well. The aggregates that cannot be
+// rewritten are left as they are, and the errors describing why are
+// returned alongside with the source.
+func (r *Rewriter) Rewrite() (string, error) {
+	reorders := slices.Clone(r.reorders)
+	slices.SortStableFunc(reorders, func(a, b reorder) int {
+		return (a.def.Body.End - a.def.Body.Start) - (b.def.Body.End - b.def.Body.Start)
+	})
+
+	var (
+		edits []edit
+		errs  []error
+	)
+
+	for _, curr := range reorders {
+		var nested, others []edit
+		for _, other := range edits {
+			if curr.def.Body.Contains(other.span) {
+				nested = append(nested, other)
+			} else {
+				others = append(others, other)
+			}
+		}
+
+		body, err := curr.render(r.src, nested)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", curr.name, err))
+			continue
+		}
+		edits = append(others, edit{curr.def.Body, body})
+	}
+	return apply(r.src, 0, len(r.src), edits), errors.Join(errs...)
+}
+
+// render returns the body of the aggregate with its declarations moved to
+// follow the new order, applying the passed edits to the nested aggregates.
+// The text of each declaration moves along with the comments and any other
+// line preceding it, and the comments following it on the same line.
+// Declarations of multiple fields which do not stay next to each other are
+// split into one declaration for each field.
+func (re reorder) render(src string, nested []edit) (string, error) {
+	var (
+		body   = re.def.Body
+		decls  = re.def.Declarations
+		starts = make([]int, len(decls)) // start of the text of each declaration
+		ends   = make([]int, len(decls))
+		head   = trailingEnd(src, body.Start, body.End)
+		prev   = head
+	)
+
+	for idx, decl := range decls {
+		starts[idx], ends[idx] = prev, trailingEnd(src, decl.Span.End, body.End)
+		prev = ends[idx]
+
+		if !balanced(src[starts[idx]:ends[idx]]) {
+			return "", ErrPreprocessor
+		}
+	}
+
+	type position struct{ decl, declarator int }
+	positions := make(map[int]position)
+	for declIdx, decl := range decls {
+		for idx, field := range decl.Fields {
+			positions[field] = position{declIdx, idx}
+		}
+	}
+
+	pieces := []string{apply(src, body.Start, head, nested)}
+	for idx := 0; idx < len(re.order); {
+		pos, ok := positions[re.order[idx]]
+		if !ok {
+			return "", ErrDefinition
+		}
+
+		// declarations kept as a whole, e.g. `char a, b;` when a and b are
+		// kept next to each other, are moved along with their text
+		decl := decls[pos.decl]
+		if pos.declarator == 0 && len(re.order) >= idx+len(decl.Fields) &&
+			slices.Equal(re.order[idx:idx+len(decl.Fields)], decl.Fields) {
+			pieces = append(pieces, apply(src, starts[pos.decl], ends[pos.decl], nested))
+			idx += len(decl.Fields)
+			continue
+		}
+
+		piece, err := split(src, decl, pos.declarator, starts[pos.decl], ends[pos.decl], nested)
+		if err != nil {
+			return "", err
+		}
+		pieces = append(pieces, piece)
+		idx++
+	}
+	pieces = append(pieces, apply(src, prev, body.End, nested))
+
+	var builder strings.Builder
+	for _, piece := range pieces {
+		// pieces from the same line must be kept apart
+		if builder.Len() != 0 && piece != "" && !isSpace(piece[0]) &&
+			!isSpace(builder.String()[builder.Len()-1]) {
+			builder.WriteByte(' ')
+		}
+		builder.WriteString(piece)
+	}
+	return builder.String(), nil
+}
+
+// split returns the declaration of a single field out of a declaration of
+// multiple ones, spanning from start to end with its comments. The first
+// field keeps the text preceding the declaration, and the last one the
+// comments following it, while the others are indented as the declaration.
+func split(src string, decl layout.Declaration, declarator, start, end int, nested []edit) (string, error) {
+	specifiers := apply(src, decl.Specifiers.Start, decl.Specifiers.End, nested)
+	if strings.Contains(specifiers, "{") {
+		return "", fmt.Errorf("%w: %s", ErrSplit, src[decl.Span.Start:decl.Span.End])
+	}
+
+	var (
+		leading  = src[start:decl.Span.Start]
+		trailing = src[decl.Span.End:end]
+		indent   = leading[strings.LastIndexByte(leading, '\n')+1:]
+		sep      = " "
+	)
+
+	if strings.Contains(trailing, "\n") {
+		sep = "\n"
+	}
+
+	var builder strings.Builder
+	if declarator == 0 {
+		builder.WriteString(leading)
+	} else if sep == "\n" {
+		builder.WriteString(indent)
+	}
+
+	span := decl.Declarators[declarator]
+	builder.WriteString(specifiers)
+	builder.WriteString(apply(src, span.Start, span.End, nested))
+	builder.WriteByte(';')
+
+	if declarator == len(decl.Declarators)-1 {
+		builder.WriteString(trailing)
+	} else {
+		builder.WriteString(sep)
+	}
+	return builder.String(), nil
+}
+
+// trailingEnd returns the end of the comments following the passed offset
+// on the same line, including the newline, if nothing else follows them.
+func trailingEnd(src string, from, limit int) int {
+	end := from
+	for pos := from; pos < limit; {
+		switch {
+		case src[pos] == '\n':
+			return pos + 1
+		case isSpace(src[pos]):
+			pos++
+		case strings.HasPrefix(src[pos:limit], "/*"):
+			closing := strings.Index(src[pos+2:limit], "*/")
+			if closing == -1 {
+				return end
+			}
+			pos += closing + 4
+			end = pos
+		case strings.HasPrefix(src[pos:limit], "//"):
+			newline := strings.IndexByte(src[pos:limit], '\n')
+			if newline == -1 {
+				return limit
+			}
+			return pos + newline + 1
+		default:
+			return end
+		}
+	}
+	return end
+}
+
+// balanced checks whether the preprocessor conditionals found within the
+// passed text are all opened and closed within it, so that the declarations
+// moved along with the text are not moved in or out of a conditional.
+func balanced(text string) bool {
+	depth := 0
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		directive := strings.Fields(strings.TrimSpace(line[1:]) + " ")
+		if len(directive) == 0 {
+			continue
+		}
+
+		switch directive[0] {
+		case "if", "ifdef", "ifndef":
+			depth++
+		case "else", "elif", "elifdef", "elifndef":
+			if depth == 0 {
+				return false
+			}
+		case "endif":
+			if depth--; depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+// apply returns the text of the source between the passed offsets, with
+// the passed edits lying within them applied.
+func apply(src string, start, end int, edits []edit) string {
+	var within []edit
+	for _, curr := range edits {
+		if curr.span.Start >= start && curr.span.End <= end {
+			within = append(within, curr)
+		}
+	}
+
+	slices.SortFunc(within, func(a, b edit) int {
+		return a.span.Start - b.span.Start
+	})
+
+	var builder strings.Builder
+	for _, curr := range within {
+		builder.WriteString(src[start:curr.span.Start])
+		builder.WriteString(curr.text)
+		start = curr.span.End
+	}
+	builder.WriteString(src[start:end])
+	return builder.String()
+}
+
+func isSpace(char byte) bool {
+	return char == ' ' || char == '\t' || char == '\r' || char == '\n'
+}
@@ -0,0 +1,169 @@
+package rewrite
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Abathargh/stropt/abi"
+	"github.com/Abathargh/stropt/layout"
+)
+
+func TestReorder(t *testing.T) {
+	testCases := []struct {
+		test     string
+		name     string
+		order    []int
+		expected string
+		expErr   error
+	}{
+		{
+			"struct r1 { char a; double b; char c; };",
+			"struct r1",
+			[]int{0, 2, 1},
+			"struct r1 { char a; char c; double b; };",
+			nil,
+		},
+		{
+			"struct r2 {\n\tchar a; // first\n\t/* the second */\n\tdouble b __attribute__((aligned(8)));\n\tchar c; /* last */\n};\n",
+			"struct r2",
+			[]int{1, 0, 2},
+			"struct r2 {\n\t/* the second */\n\tdouble b __attribute__((aligned(8)));\n\tchar a; // first\n\tchar c; /* last */\n};\n",
+			nil,
+		},
+		{
+			"struct r3 {\n\tchar a, *p, b; // chars\n\tint i;\n};\n",
+			"struct r3",
+			[]int{1, 3, 0, 2},
+			"struct r3 {\n\tchar *p;\n\tint i;\n\tchar a;\n\tchar b; // chars\n};\n",
+			nil,
+		},
+		{
+			"struct r4 {\n\tchar a, b;\n\tint i;\n};\n",
+			"struct r4",
+			[]int{2, 0, 1},
+			"struct r4 {\n\tint i;\n\tchar a, b;\n};\n",
+			nil,
+		},
+		{
+			"struct r5 {\n\tchar c;\n\tstruct {\n\t\tchar x;\n\t\tint y;\n\t} in;\n#pragma message(\"hi\")\n\tint i;\n};\n",
+			"struct r5",
+			[]int{2, 1, 0},
+			"struct r5 {\n#pragma message(\"hi\")\n\tint i;\n\tstruct {\n\t\tchar x;\n\t\tint y;\n\t} in;\n\tchar c;\n};\n",
+			nil,
+		},
+		{
+			"struct r6 { char a; int b; };",
+			"struct r6",
+			[]int{0, 1},
+			"struct r6 { char a; int b; };",
+			nil,
+		},
+		{
+			"#define FIELDS char a; int b;\nstruct r7 { FIELDS };",
+			"struct r7",
+			[]int{1, 0},
+			"",
+			ErrDefinition,
+		},
+		{
+			"struct r8 {\n\tchar a;\n#ifdef X\n\tint x;\n#else\n\tlong x;\n#endif\n\tchar c;\n};\n",
+			"struct r8",
+			[]int{1, 0, 2},
+			"",
+			ErrPreprocessor,
+		},
+		{
+			"struct r9 { struct { int q; } s1, s2; char c; };",
+			"struct r9",
+			[]int{0, 2, 1},
+			"",
+			ErrSplit,
+		},
+		{
+			"struct r10 { char a; int b; };",
+			"struct r10",
+			[]int{0, 0},
+			"",
+			ErrOrder,
+		},
+	}
+
+	for _, testCase := range testCases {
+		structs, err := layout.ExtractAggregates("", testCase.test, false, abi.DefaultTarget())
+		if err != nil {
+			t.Errorf("Unexpected error when parsing %s: %s", testCase.test, err)
+			continue
+		}
+
+		agg, _ := structs.Lookup(testCase.name)
+		rewriter := New("", testCase.test)
+
+		err = rewriter.Reorder(testCase.name, agg, testCase.order)
+		if err == nil {
+			var rewritten string
+			if rewritten, err = rewriter.Rewrite(); err == nil && rewritten != testCase.expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", testCase.expected, rewritten)
+			}
+		}
+
+		if testCase.expErr == nil && err != nil {
+			t.Errorf("Unexpected error when rewriting %s: %s", testCase.test, err)
+		} else if !errors.Is(err, testCase.expErr) {
+			t.Errorf("Expected error %v: got: %v for '%s'", testCase.expErr, err, testCase.test)
+		}
+	}
+}
+
+func TestReorderNested(t *testing.T) {
+	const test = "struct n1 {\n\tchar c;\n\tstruct {\n\t\tchar x;\n\t\tint y;\n\t\tchar z;\n\t} in;\n\tint i;\n};\n"
+
+	structs, err := layout.ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	var (
+		outer, _ = structs.Lookup("struct n1")
+		inner    = layout.InlineAggregates(outer)[0]
+		rewriter = New("", test)
+	)
+
+	// the outer aggregate is scheduled first, yet rewritten last
+	if err := rewriter.Reorder("struct n1", outer, []int{1, 2, 0}); err != nil {
+		t.Fatalf("Unexpected error when rewriting %s: %s", test, err)
+	}
+
+	if err := rewriter.Reorder("struct n1::in", inner, []int{0, 2, 1}); err != nil {
+		t.Fatalf("Unexpected error when rewriting %s: %s", test, err)
+	}
+
+	const expected = "struct n1 {\n\tstruct {\n\t\tchar x;\n\t\tchar z;\n\t\tint y;\n\t} in;\n\tint i;\n\tchar c;\n};\n"
+
+	rewritten, err := rewriter.Rewrite()
+	if err != nil || rewritten != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s (%v)", expected, rewritten, err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	const (
+		original  = "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+		rewritten = "a\nc\nb\nd\ne\nf\ng\nh\ni\nj\nk\nm\n"
+		expected  = "--- a/test.c\n+++ b/test.c\n" +
+			"@@ -1,6 +1,6 @@\n a\n-b\n c\n+b\n d\n e\n f\n" +
+			"@@ -9,4 +9,4 @@\n i\n j\n k\n-l\n+m\n"
+	)
+
+	if diff := Diff("./test.c", original, rewritten); diff != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, diff)
+	}
+
+	if diff := Diff("test.c", original, original); diff != "" {
+		t.Errorf("Expected no diff: got:\n%s", diff)
+	}
+
+	const noNewline = "--- a/test.c\n+++ b/test.c\n@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file\n"
+	if diff := Diff("test.c", "a", "b"); diff != noNewline {
+		t.Errorf("Expected:\n%s\ngot:\n%s", noNewline, diff)
+	}
+}
@@ -6,15 +6,21 @@ import (
 	"fmt"
 	"os"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 
+	"github.com/Abathargh/stropt/abi"
+	"github.com/Abathargh/stropt/layout"
+	"github.com/Abathargh/stropt/optimize"
+	"github.com/Abathargh/stropt/report"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/lipgloss/table"
 )
 
 const (
-	nameMessage = "usage: stropt [flags] [type name] [source code]"
+	nameMessage = "usage: stropt [flags] [type name] [source code]\n" +
+		"       stropt -all [flags] [source code]"
 	helpMessage = `
 stropt is a struct optimizer program, which analyzes the C types you pass 
 in, and prints back the type size, alignment and layout, including padding 
@@ -27,17 +33,29 @@ code as a string.
 
 If no source code is passed as a string, then it is mandatory to use the 
 "-file" option, and pass an existing file name.
+
+With the "-all" option, every aggregate found within the source code is 
+analyzed in one go, and no "type name" is needed.
 `
 
-	helpUsage     = "show the help message"
-	bareUsage     = "just print the data without table formatting or graphics"
-	versionUsage  = "print the version for this build"
-	verboseUsage  = "print more information, e.g. sub-aggregate metadata"
-	useCompUsage  = "attempts to resolve includes using the system compiler"
-	ptrUsage      = "sets the pointer size/alignment, as comma-separated values"
-	enumUsage     = "sets the enum size/alignment, as comma-separated values"
-	s32bitUsage   = "sets the type size/alignment as on a 32bit system"
-	avrUsage      = "sets the type size/alignment as on a AVR system"
+	helpUsage      = "show the help message"
+	bareUsage      = "just print the data without table formatting or graphics"
+	versionUsage   = "print the version for this build"
+	verboseUsage   = "print more information, e.g. sub-aggregate metadata"
+	useCompUsage   = "attempts to resolve includes using the system compiler"
+	ptrUsage       = "sets the pointer size/alignment, as comma-separated values"
+	enumUsage      = "sets the enum size/alignment, as comma-separated values"
+	shortEnumUsage = "sizes enums as the smallest type fitting their values, " +
+		"as with -fshort-enums"
+	s32bitUsage = "sets the type size/alignment as on a 32bit system, " +
+		"same as -target i386"
+	avrUsage    = "sets the type size/alignment as on a AVR system, same as -target avr"
+	targetUsage = "sets the target ABI by name or target triple, e.g. " +
+		"x86_64, win64 or arm-none-eabi"
+	targetFileUsage  = "loads the target ABI from a YAML or JSON file"
+	listTargetsUsage = "lists the built-in targets"
+	targetsUsage     = "compares the layout across a comma-separated list of " +
+		"targets, e.g. x86_64,arm-linux,avr"
 	charUsage     = "sets the char size/alignment, as comma-separated values"
 	shortUsage    = "sets the short size/alignment, as comma-separated values"
 	intUsage      = "sets the int size/alignment, as comma-separated values"
@@ -49,10 +67,35 @@ If no source code is passed as a string, then it is mandatory to use the
 	longDoubleUsage = "sets the long double size/alignment, as " +
 		"comma-separated values"
 	optimizeUsage = "suggests an optimized layout and shows related statistics"
-	fileUsage     = "pass a file containing the type definitions"
+	fixUsage      = "rewrites the file passed with -file, moving the fields as " +
+		"suggested when optimizing; implies -optimize"
+	diffUsage = "prints the changes -fix would make as a unified diff, " +
+		"instead of the layouts; implies -optimize"
+	recursiveUsage = "optimizes the nested aggregates too, reporting the ones " +
+		"to be changed; implies -optimize"
+	compareUsage = "compares the layout with the one within an old version of " +
+		"the source: a file, a git revision and path, e.g. HEAD:foo.h, or a " +
+		"revision of the file passed with -file, e.g. HEAD~1"
+	lockUsage = "writes the layout to a lockfile, as YAML, or as JSON if its " +
+		"name ends with .json"
+	checkUsage = "checks the layout against a lockfile written with -lock, " +
+		"exiting with an error if it drifted"
+	assertsUsage = "prints a header of C11 and C++ static assertions " +
+		"enforcing the size, alignment and field offsets of the layout"
+	fileUsage   = "pass a file containing the type definitions"
+	formatUsage = "sets the output format: table, json or yaml"
+	allUsage    = "analyzes every aggregate, ending with a summary sorted by " +
+		"padding; no type name is needed"
+	pinUsage = "keeps fields in place when optimizing, as a comma-separated " +
+		"list, or at an index, e.g. magic,crc=3"
+	prefixUsage = "keeps the first N fields in place when optimizing"
+	groupUsage  = "keeps a comma-separated list of fields next to each other " +
+		"when optimizing; can be repeated"
+	orderUsage = "keeps a comma-separated list of fields in order when " +
+		"optimizing; can be repeated"
 
 	entryWidth     = 15
-	titleWidth     = entryWidth*4 + 3 // 4 entries per row + padding
+	titleWidth     = entryWidth*5 + 4 // 5 entries per row + padding
 	structBoxWidth = entryWidth * 2   // 2 boxes per row
 
 	headerColorHex = "#ececec"
@@ -63,6 +106,7 @@ var (
 	ErrSizeAlignParsing = errors.New("could not parse size/alignment")
 	ErrSizeAlignNelem   = errors.New("expected 2 elements")
 	ErrSizeAlignValue   = errors.New("size and alignment must not be zero")
+	ErrFormat           = errors.New("unknown output format")
 
 	headerColor = lipgloss.Color(headerColorHex)
 	entryColor  = lipgloss.Color(entryColorHex)
@@ -96,36 +140,73 @@ var (
 			Width(structBoxWidth).
 			Margin(0, 1, 1, 0).
 			Padding(1, 1, 1, 2).
+			TabWidth(4).
 			Align(lipgloss.Left)
 
 	alignSizeMeta = []struct {
 		name string
-		fun  func(int, int)
+		meta func(*abi.Target) *abi.TypeMeta
 	}{
-		{"ptr", SetPointerAlignSize},
-		{"enum", SetEnumAlignSize},
-		{"char", SetCharAlignSize},
-		{"short", SetShortAlignSize},
-		{"int", SetIntAlignSize},
-		{"long", SetLongAlignSize},
-		{"long long", SetLongLongAlignSize},
-		{"float", SetFloatAlignSize},
-		{"double", SetDoubleAlignSize},
-		{"long double", SetLongDoubleAlignSize},
+		{"ptr", func(t *abi.Target) *abi.TypeMeta { return &t.Pointer }},
+		{"enum", func(t *abi.Target) *abi.TypeMeta { return &t.Enum }},
+		{"char", func(t *abi.Target) *abi.TypeMeta { return &t.Char }},
+		{"short", func(t *abi.Target) *abi.TypeMeta { return &t.Short }},
+		{"int", func(t *abi.Target) *abi.TypeMeta { return &t.Int }},
+		{"long", func(t *abi.Target) *abi.TypeMeta { return &t.Long }},
+		{"long long", func(t *abi.Target) *abi.TypeMeta { return &t.LongLong }},
+		{"float", func(t *abi.Target) *abi.TypeMeta { return &t.Float }},
+		{"double", func(t *abi.Target) *abi.TypeMeta { return &t.Double }},
+		{"long double", func(t *abi.Target) *abi.TypeMeta { return &t.LongDouble }},
 	}
 )
 
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+)
+
+// options holds the command line options that affect how the aggregates are
+// analyzed and reported.
+type options struct {
+	bare      bool
+	verbose   bool
+	optimize  bool
+	recursive bool
+	fix       bool
+	diff      bool
+	compare   string
+	lock      string
+	check     string
+	asserts   bool
+	compiler  bool
+	format    string
+	target    abi.Target
+	targets   []abi.Target
+
+	constraints optimize.Constraints
+}
+
 func main() {
 	var (
-		help     bool
-		bare     bool
-		useComp  bool
-		version  bool
-		verbose  bool
-		optimize bool
+		help    bool
+		version bool
+		all     bool
+		opts    options
+
+		s32bit      bool
+		avr         bool
+		shortEnums  bool
+		listTargets bool
+
+		targetName  string
+		targetFile  string
+		targetsList string
 
-		s32bit bool
-		avr    bool
+		pins   string
+		prefix int
+		groups listFlag
+		orders listFlag
 
 		ptr        string
 		enum       string
@@ -149,15 +230,33 @@ func main() {
 
 	fs := flag.NewFlagSet("stropt", flag.ExitOnError)
 	fs.BoolVar(&help, "help", false, helpUsage)
-	fs.BoolVar(&bare, "bare", false, bareUsage)
-	fs.BoolVar(&useComp, "use-compiler", false, useCompUsage)
+	fs.BoolVar(&opts.bare, "bare", false, bareUsage)
+	fs.BoolVar(&opts.compiler, "use-compiler", false, useCompUsage)
 	fs.BoolVar(&version, "version", false, versionUsage)
-	fs.BoolVar(&verbose, "verbose", false, verboseUsage)
-	fs.BoolVar(&optimize, "optimize", false, optimizeUsage)
+	fs.BoolVar(&opts.verbose, "verbose", false, verboseUsage)
+	fs.BoolVar(&opts.optimize, "optimize", false, optimizeUsage)
+	fs.BoolVar(&opts.recursive, "recursive", false, recursiveUsage)
+	fs.BoolVar(&opts.fix, "fix", false, fixUsage)
+	fs.BoolVar(&opts.diff, "diff", false, diffUsage)
+	fs.StringVar(&opts.compare, "compare", "", compareUsage)
+	fs.StringVar(&opts.lock, "lock", "", lockUsage)
+	fs.StringVar(&opts.check, "check", "", checkUsage)
+	fs.BoolVar(&opts.asserts, "asserts", false, assertsUsage)
+	fs.StringVar(&opts.format, "format", formatTable, formatUsage)
+	fs.BoolVar(&all, "all", false, allUsage)
+	fs.StringVar(&pins, "pin", "", pinUsage)
+	fs.IntVar(&prefix, "prefix", 0, prefixUsage)
+	fs.Var(&groups, "group", groupUsage)
+	fs.Var(&orders, "order", orderUsage)
 	fs.BoolVar(&s32bit, "32bit", false, s32bitUsage)
 	fs.BoolVar(&avr, "avr", false, avrUsage)
+	fs.StringVar(&targetName, "target", "", targetUsage)
+	fs.StringVar(&targetFile, "target-file", "", targetFileUsage)
+	fs.BoolVar(&listTargets, "list-targets", false, listTargetsUsage)
+	fs.StringVar(&targetsList, "targets", "", targetsUsage)
 	fs.StringVar(&ptr, "ptr", "", ptrUsage)
 	fs.StringVar(&enum, "enum", "", enumUsage)
+	fs.BoolVar(&shortEnums, "short-enums", false, shortEnumUsage)
 	fs.StringVar(&char, "char", "", charUsage)
 	fs.StringVar(&short, "short", "", shortUsage)
 	fs.StringVar(&intM, "int", "", intUsage)
@@ -178,14 +277,66 @@ func main() {
 
 	switch {
 	case s32bit:
-		Set32BitSys()
+		targetName = "i386"
 	case avr:
-		SetAvrSys()
-	default:
-		err := handleSizeAlignOptions(flags)
-		if err != nil {
+		targetName = "avr"
+	}
+
+	target, err := selectTarget(targetName, targetFile)
+	if err != nil {
+		logError(err)
+	}
+
+	targets := []abi.Target{target}
+	if targetsList != "" {
+		if targetName != "" || targetFile != "" {
+			logError(fmt.Errorf("%w: cannot use -targets with another target", abi.ErrTarget))
+		}
+
+		if targets, err = lookupTargets(targetsList); err != nil {
+			logError(err)
+		}
+		opts.targets = targets
+	}
+
+	// explicit sizes and alignments are applied on top of the targets
+	for idx := range targets {
+		if err := handleSizeAlignOptions(&targets[idx], flags); err != nil {
 			logError(fmt.Errorf("wrong option value: %w", err))
 		}
+		targets[idx].ShortEnums = targets[idx].ShortEnums || shortEnums
+	}
+	opts.target = targets[0]
+
+	opts.optimize = opts.optimize || opts.recursive || opts.fix || opts.diff
+	switch {
+	case opts.fix && file == "":
+		logError(fmt.Errorf("%w: -fix needs a file passed with -file", ErrFix))
+	case (opts.fix || opts.diff) && targetsList != "":
+		logError(fmt.Errorf("%w: cannot use -targets with -fix or -diff", ErrFix))
+	case opts.compare != "" && (opts.optimize || targetsList != ""):
+		logError(fmt.Errorf("%w: cannot use -compare with -optimize or -targets", ErrCompare))
+	case opts.lock != "" && opts.check != "":
+		logError(fmt.Errorf("%w: cannot use -lock with -check", ErrLock))
+	case (opts.lock != "" || opts.check != "") &&
+		(opts.optimize || opts.compare != "" || targetsList != ""):
+		logError(fmt.Errorf("%w: cannot use -lock or -check with -optimize, "+
+			"-compare or -targets", ErrLock))
+	case opts.asserts && (opts.optimize || opts.compare != "" || opts.lock != "" ||
+		opts.check != "" || targetsList != ""):
+		logError(fmt.Errorf("%w: cannot use -asserts with -optimize, -compare, "+
+			"-lock, -check or -targets", ErrAsserts))
+	}
+
+	opts.constraints, err = parseConstraints(pins, prefix, groups, orders)
+	if err != nil {
+		logError(err)
+	}
+
+	switch opts.format {
+	case formatTable, formatJSON, formatYAML:
+	default:
+		logError(fmt.Errorf("%w: %s", ErrFormat, opts.format))
 	}
 
 	switch {
@@ -199,30 +350,220 @@ func main() {
 		// -version flag, show the current embedded version
 		fmt.Printf("stropt %s\n", Version)
 		return
+	case listTargets:
+		printTargets(opts.bare)
+		return
+	case all && len(fs.Args()) == 0 && file != "":
+		cont, err := os.ReadFile(file)
+		if err != nil {
+			logErrorMessage("failed to open file: %v", err)
+		}
+		stroptAll(file, string(cont), opts)
+	case all && len(fs.Args()) == 1 && file == "":
+		stroptAll("", fs.Arg(0), opts)
+	case all:
+		logErrorMessage(nameMessage)
 	case len(fs.Args()) == 1 && file != "":
 		cont, err := os.ReadFile(file)
 		if err != nil {
 			logErrorMessage("failed to open file: %v", err)
 		}
-		stropt(file, fs.Arg(0), string(cont), bare, verbose, optimize, useComp)
+		stropt(file, fs.Arg(0), string(cont), opts)
 	case len(fs.Args()) == 2:
-		stropt("", fs.Arg(0), fs.Arg(1), bare, verbose, optimize, useComp)
+		stropt("", fs.Arg(0), fs.Arg(1), opts)
 	default:
 		logErrorMessage(nameMessage)
 	}
 }
 
-func stropt(fname, aggName, cont string, bare, verbose, optimize, comp bool) {
-	aggregates, err := ExtractAggregates(fname, cont, comp)
+func stropt(fname, aggName, cont string, opts options) {
+	if opts.compare != "" {
+		stroptCompare(fname, cont, []string{aggName}, opts)
+		return
+	}
+
+	if opts.lock != "" {
+		writeLock(fname, cont, []string{aggName}, opts)
+		return
+	}
+
+	if opts.check != "" {
+		checkLock(fname, cont, []string{aggName}, opts)
+		return
+	}
+
+	if opts.asserts {
+		printAssertions(fname, cont, []string{aggName}, opts)
+		return
+	}
+
+	if opts.fix || opts.diff {
+		fixSource(fname, cont, []string{aggName}, opts)
+		return
+	}
+
+	if opts.targets != nil {
+		stroptTargets(fname, cont, []string{aggName}, opts.targets, opts)
+		return
+	}
+
+	aggregates, err := layout.ExtractAggregates(fname, cont, opts.compiler, opts.target)
 	if err != nil {
 		logError(err)
 	}
 
-	meta, err := aggregates.ResolveMeta(aggName)
+	if opts.format != formatTable {
+		if err := emitReport(aggregates, []string{aggName}, opts, false); err != nil {
+			logError(err)
+		}
+		return
+	}
+
+	if _, err := printReport(aggregates, aggName, opts); err != nil {
+		logError(err)
+	}
+}
+
+// stroptAll reports every aggregate found within the passed source, parsing
+// it only once, and ends with a summary of all of them, sorted by padding.
+// The aggregates whose layout cannot be computed are skipped with a warning.
+func stroptAll(fname, cont string, opts options) {
+	if opts.fix || opts.diff {
+		fixSource(fname, cont, nil, opts)
+		return
+	}
+
+	if opts.compare != "" {
+		stroptCompare(fname, cont, nil, opts)
+		return
+	}
+
+	if opts.lock != "" {
+		writeLock(fname, cont, nil, opts)
+		return
+	}
+
+	if opts.check != "" {
+		checkLock(fname, cont, nil, opts)
+		return
+	}
+
+	if opts.asserts {
+		printAssertions(fname, cont, nil, opts)
+		return
+	}
+
+	if opts.targets != nil {
+		stroptTargets(fname, cont, nil, opts.targets, opts)
+		return
+	}
+
+	aggregates, err := layout.ExtractAggregates(fname, cont, opts.compiler, opts.target)
 	if err != nil {
 		logError(err)
 	}
 
+	var names []string
+	for _, aggregate := range aggregates.Aggregates() {
+		names = append(names, layout.GetAggregateNames(aggregate)[0])
+	}
+
+	if opts.format != formatTable {
+		if err := emitReport(aggregates, names, opts, true); err != nil {
+			logError(err)
+		}
+		return
+	}
+
+	var summary []summaryEntry
+	for _, name := range names {
+		meta, err := printReport(aggregates, name, opts)
+		if err != nil {
+			logWarning(err)
+			continue
+		}
+		summary = append(summary, summaryEntry{name, meta})
+	}
+
+	printSummary(summary, opts.bare)
+}
+
+// emitReport writes the machine-readable report for the aggregates
+// identified by the passed names to the standard output, in the requested
+// format. If skipErrors is set, the aggregates whose layout cannot be
+// computed are skipped with a warning.
+func emitReport(aggregates layout.Context, names []string, opts options, skipErrors bool) error {
+	doc := report.New()
+	for _, name := range names {
+		aggReport, err := newReportEntry(aggregates, name, opts)
+		if err != nil && skipErrors {
+			logWarning(err)
+			continue
+		} else if err != nil {
+			return err
+		}
+		doc.Aggregates = append(doc.Aggregates, aggReport)
+	}
+	return encodeReport(doc, opts.format)
+}
+
+// encodeReport writes the passed report to the standard output, in the
+// requested format.
+func encodeReport(doc report.Report, format string) error {
+	if format == formatYAML {
+		return report.EncodeYAML(os.Stdout, doc)
+	}
+	return report.EncodeJSON(os.Stdout, doc)
+}
+
+// newReportEntry resolves the aggregate identified by name, and its
+// optimized layout if requested, and builds its report.
+func newReportEntry(aggregates layout.Context, name string, opts options) (report.Aggregate, error) {
+	meta, err := aggregates.ResolveMeta(name)
+	if err != nil {
+		return report.Aggregate{}, err
+	}
+
+	if !opts.optimize {
+		return report.NewAggregate(aggregates, name, meta, nil)
+	}
+
+	if opts.recursive {
+		nested, err := optimize.OptimizeRecursive(aggregates, name, meta, opts.constraints)
+		if err != nil {
+			return report.Aggregate{}, err
+		}
+
+		aggReport, err := report.NewAggregate(aggregates, name, meta, &nested.Optimized)
+		aggReport.Nested = report.NewNested(nested.Changes)
+		return aggReport, err
+	}
+
+	optMeta, err := optimize.Optimize(aggregates, name, meta, opts.constraints)
+	if err != nil {
+		return report.Aggregate{}, err
+	}
+	return report.NewAggregate(aggregates, name, meta, &optMeta)
+}
+
+// printReport prints the layout of the aggregate identified by name,
+// followed by the optimized one if requested, and returns its metadata.
+func printReport(aggregates layout.Context, aggName string, opts options) (layout.AggregateMeta, error) {
+	var (
+		bare    = opts.bare
+		verbose = opts.verbose
+	)
+
+	meta, err := aggregates.ResolveMeta(aggName)
+	if err != nil {
+		return layout.AggregateMeta{}, err
+	}
+
+	agg, ok := aggregates.Lookup(aggName)
+	if !ok {
+		return layout.AggregateMeta{}, fmt.Errorf("%w: %v", layout.ErrSymbol, aggName)
+	}
+
 	if bare {
 		fmt.Fprintf(os.Stdout, "(def) ")
 	} else {
@@ -230,33 +571,225 @@ func stropt(fname, aggName, cont string, bare, verbose, optimize, comp bool) {
 	}
 	printAggregateMeta(aggName, meta, false, bare, verbose)
 
-	if optimize {
-		optMeta, err := aggregates.Optimize(aggName, meta)
-		if err != nil {
-			logError(err)
+	for _, diagnostic := range meta.Diagnostics {
+		logWarning(diagnostic)
+	}
+
+	if !opts.optimize {
+		return meta, nil
+	}
+
+	var nested optimize.Nested
+	if opts.recursive {
+		nested, err = optimize.OptimizeRecursive(aggregates, aggName, meta, opts.constraints)
+	} else {
+		nested.Original = meta
+		nested.Optimized, err = optimize.Optimize(aggregates, aggName, meta, opts.constraints)
+	}
+
+	if err != nil {
+		return layout.AggregateMeta{}, err
+	}
+
+	// constraints may move fields without shrinking the layout
+	optMeta := nested.Optimized
+	if len(optimize.Moves(meta.Layout, optMeta.Layout)) == 0 && len(nested.Changes) == 0 {
+		fmt.Println("The passed layout is already minimal")
+	} else {
+		printOptimized(aggName, agg, agg, meta, optMeta, bare, verbose)
+	}
+
+	if opts.recursive {
+		printNested(nested, bare)
+	}
+
+	unpacked, canDrop, err := optimize.DropPacking(aggregates, aggName, meta, opts.constraints)
+	if err != nil {
+		return layout.AggregateMeta{}, err
+	}
+
+	if canDrop {
+		unpackedAgg := *agg
+		unpackedAgg.Packed, unpackedAgg.Pack = false, 0
+
+		fmt.Println("The packing can be dropped without growing the layout")
+		printOptimized(aggName, agg, &unpackedAgg, meta, unpacked, bare, verbose)
+	}
+	return meta, nil
+}
+
+// A summaryEntry holds the metadata of one of the aggregates reported when
+// analyzing all of them.
+type summaryEntry struct {
+	name string
+	meta layout.AggregateMeta
+}
+
+// printSummary prints the size, alignment and padding of the passed
+// aggregates, sorted by descending padding, so that the ones wasting more
+// space come first. Aggregates with the same padding keep their order.
+func printSummary(summary []summaryEntry, bare bool) {
+	slices.SortStableFunc(summary, func(i, j summaryEntry) int {
+		return j.meta.Padding() - i.meta.Padding()
+	})
+
+	if bare {
+		fmt.Fprintf(os.Stdout, "(sum)\n")
+	} else {
+		fmt.Println(titleBox.Render("stropt - summary"))
+	}
+
+	t := table.New().
+		Border(lipgloss.RoundedBorder()).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == -1 {
+				return headerStyle
+			}
+			return rowStyle
+		}).
+		Headers("Aggregate", "Size", "Alignment", "Padding")
+
+	for _, entry := range summary {
+		var (
+			size  = strconv.Itoa(entry.meta.Size)
+			align = strconv.Itoa(entry.meta.Alignment)
+			pad   = formatBits(entry.meta.Padding())
+		)
+
+		if bare {
+			fmt.Fprintf(os.Stdout, "%s, size: %s, alignment: %s, padding: %s\n",
+				entry.name, size, align, pad)
+			continue
+		}
+		t.Row(entry.name, size, align, pad)
+	}
+
+	if !bare {
+		fmt.Println(t)
+	}
+}
+
+// printOptimized prints the optimized layout of the passed aggregate, which
+// is defined as optAgg, alongside with the default one, followed by the moves
+// turning the latter into the former.
+func printOptimized(aggName string, agg, optAgg *layout.Aggregate, meta, optMeta layout.AggregateMeta,
+	bare, verbose bool) {
+	if bare {
+		fmt.Fprintf(os.Stdout, "(opt) ")
+	}
+
+	printAggregateMeta(aggName, optMeta, true, bare, verbose)
+	if !bare {
+		fmt.Println(lipgloss.JoinHorizontal(
+			lipgloss.Top,
+			printAggregate(agg, meta, false),
+			printAggregate(optAgg, optMeta, true),
+		))
+	}
+
+	printMoves(optimize.Moves(meta.Layout, optMeta.Layout), bare)
+}
+
+// printNested prints the nested aggregates to be changed when optimizing
+// recursively, alongside with the moves for each of them, followed by the
+// bytes saved in total.
+func printNested(nested optimize.Nested, bare bool) {
+	if len(nested.Changes) != 0 && !bare {
+		fmt.Printf("Nested types to change (%d):\n", len(nested.Changes))
+	}
+
+	for _, change := range nested.Changes {
+		if bare {
+			fmt.Fprintf(os.Stdout, "(nst) %s, size: %d, optimized size: %d\n",
+				change.Name, change.Original.Size, change.Optimized.Size)
+			for _, move := range change.Moves {
+				fmt.Fprintf(os.Stdout, "(mov) %s\n", move)
+			}
+			continue
 		}
 
-		if optMeta.Size == meta.Size {
-			fmt.Println("The passed layout is already minimal")
-			return
+		fmt.Printf("  %s (%d -> %d bytes)\n", change.Name, change.Original.Size,
+			change.Optimized.Size)
+		for idx, move := range change.Moves {
+			fmt.Printf("    %d. %s\n", idx+1, move)
 		}
+	}
 
+	if bare {
+		fmt.Fprintf(os.Stdout, "(sav) %d\n", nested.Savings())
+		return
+	}
+	fmt.Printf("Total savings: %d bytes (%d -> %d)\n", nested.Savings(),
+		nested.Original.Size, nested.Optimized.Size)
+}
+
+// printMoves prints the moves turning the original layout into the optimized
+// one, in the order they are to be applied.
+func printMoves(moves []optimize.Move, bare bool) {
+	if len(moves) == 0 {
+		return
+	}
+
+	if !bare {
+		fmt.Printf("Suggested moves (%d):\n", len(moves))
+	}
+
+	for idx, move := range moves {
 		if bare {
-			fmt.Fprintf(os.Stdout, "(opt) ")
+			fmt.Fprintf(os.Stdout, "(mov) %s\n", move)
+			continue
 		}
+		fmt.Printf("  %d. %s\n", idx+1, move)
+	}
+}
+
+// selectTarget returns the target loaded from the passed file, if any, or
+// else the built-in one with the passed name or triple, defaulting to the
+// default target.
+func selectTarget(name, file string) (abi.Target, error) {
+	switch {
+	case file != "" && name != "":
+		return abi.Target{}, fmt.Errorf("%w: cannot use both -target and -target-file",
+			abi.ErrTarget)
+	case file != "":
+		return abi.LoadTarget(file)
+	case name != "":
+		return abi.LookupTarget(name)
+	}
+	return abi.DefaultTarget(), nil
+}
+
+// printTargets lists the built-in targets, alongside with the triples that
+// select them.
+func printTargets(bare bool) {
+	t := table.New().
+		Border(lipgloss.RoundedBorder()).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == -1 || col == 0 {
+				return headerStyle
+			}
+			return lipgloss.NewStyle().Foreground(entryColor).Padding(0, 1)
+		}).
+		Headers("Target", "Triples")
 
-		printAggregateMeta(aggName, optMeta, true, bare, verbose)
-		if !bare {
-			fmt.Println(lipgloss.JoinHorizontal(
-				lipgloss.Top,
-				printAggregate(aggName, meta, false),
-				printAggregate(aggName, optMeta, true),
-			))
+	for _, target := range abi.Targets() {
+		triples := strings.Join(target.Triples, ", ")
+		if bare {
+			fmt.Fprintf(os.Stdout, "%s: %s\n", target.Name, triples)
+			continue
 		}
+		t.Row(target.Name, triples)
+	}
+
+	if !bare {
+		fmt.Println(t)
 	}
 }
 
-func handleSizeAlignOptions(flags []string) error {
+// handleSizeAlignOptions overrides the size and alignment of the basic types
+// of the passed target with the ones set through the command line, in the
+// same order as alignSizeMeta.
+func handleSizeAlignOptions(target *abi.Target, flags []string) error {
 	for idx, flag := range flags {
 		if flag == "" {
 			continue
@@ -275,49 +808,139 @@ func handleSizeAlignOptions(flags []string) error {
 			return fmt.Errorf("%s - %s", meta.name, ErrSizeAlignParsing)
 		}
 
-		meta.fun(int(f), int(s))
+		if f <= 0 || s <= 0 {
+			return fmt.Errorf("%s - %s", meta.name, ErrSizeAlignValue)
+		}
+		*meta.meta(target) = abi.TypeMeta{Alignment: int(f), Size: int(s)}
 	}
-	return nil
+	return target.Validate()
 }
 
-func printAggregateMeta(name string, meta AggregateMeta, opt, bare, verbose bool) {
+func printAggregateMeta(name string, meta layout.AggregateMeta, opt, bare, verbose bool) {
 	var (
-		totPadding = 0
+		totPadding = meta.Padding()
 		typeName   = "Name"
 	)
 
-	for _, fLayout := range meta.Layout {
-		totPadding += fLayout.padding
-	}
-
 	if opt {
 		typeName = "Name (opt)"
 	}
 
 	t := makeTable(typeName)
 
-	doPrint(name, meta.Size, meta.Alignment, totPadding, t, bare)
+	// enums also report their underlying type
+	if meta.Underlying != "" {
+		name = fmt.Sprintf("%s : %s", name, meta.Underlying)
+	}
+
+	doPrint(name, "", strconv.Itoa(meta.Size), strconv.Itoa(meta.Alignment),
+		formatBits(totPadding), t, bare)
+
+	for _, row := range layoutRows(meta, verbose) {
+		doPrint(row.name, row.offset, row.size, row.align, row.pad, t, bare)
+	}
 
+	if !bare {
+		fmt.Println(t)
+	}
+}
+
+// A layoutRow holds the layout of a field, formatted as shown in a table.
+// The key identifies the field regardless of its layout, e.g. across
+// targets: it is made of the labels of the field and of its parents, as
+// used within moves.
+type layoutRow struct {
+	name   string
+	key    string
+	offset string
+	size   string
+	align  string
+	pad    string
+}
+
+// layoutRows formats the layout of each field of the passed aggregate, each
+// one followed by the fields of its sub-aggregate, if any.
+func layoutRows(meta layout.AggregateMeta, verbose bool) []layoutRow {
+	var rows []layoutRow
 	for _, fLayout := range meta.Layout {
+		row := newLayoutRow(layoutLabel(fLayout), optimize.Label(fLayout.Field), fLayout, 0)
+		rows = append(rows, row)
+		rows = append(rows, subLayoutRows(row, fLayout, 0, verbose)...)
+	}
+	return rows
+}
+
+// subLayoutRows formats the layout of the fields of a sub-aggregate, naming
+// and keying them after their parent row. Aggregates defined inline are
+// always shown, since they are part of the aggregate definition, while the
+// others are only shown in verbose mode. Offsets are shown from the start of
+// the outermost aggregate, the parent one starting at baseBits within it.
+func subLayoutRows(parent layoutRow, fLayout layout.Layout, baseBits int, verbose bool) []layoutRow {
+	if fLayout.Fields() == nil || (!verbose && !isInline(fLayout.Field)) {
+		return nil
+	}
+
+	var rows []layoutRow
+	baseBits += fLayout.BitOffset()
+	for _, sub := range fLayout.Fields() {
 		var (
-			size  = strconv.Itoa(fLayout.size)
-			align = strconv.Itoa(fLayout.alignment)
-			pad   = strconv.Itoa(fLayout.padding)
+			name = fmt.Sprintf("%s::%s", parent.name, layoutLabel(sub))
+			key  = fmt.Sprintf("%s::%s", parent.key, optimize.Label(sub.Field))
+			row  = newLayoutRow(name, key, sub, baseBits)
 		)
+		rows = append(rows, row)
+		rows = append(rows, subLayoutRows(row, sub, baseBits, verbose)...)
+	}
+	return rows
+}
 
-		t.Row(fLayout.Declaration(), size, align, pad)
+// newLayoutRow formats the layout of a field, which is named and keyed as
+// passed, and belongs to an aggregate starting at baseBits.
+func newLayoutRow(name, key string, fLayout layout.Layout, baseBits int) layoutRow {
+	return layoutRow{
+		name:   name,
+		key:    key,
+		offset: formatOffset(fLayout, baseBits),
+		size:   formatBits(fLayout.BitSize()),
+		align:  strconv.Itoa(fLayout.Alignment()),
+		pad:    formatBits(fLayout.BitPadding()),
+	}
+}
 
-		if fLayout.subAggregate != nil && verbose {
-			for _, sub := range fLayout.subAggregate {
-				name := fmt.Sprintf("%s::%s", fLayout.Declaration(), sub.Declaration())
-				doPrint(name, sub.size, sub.alignment, sub.padding, t, bare)
-			}
-		}
+// formatOffset formats the offset of a field, given the offset in bits of
+// the aggregate it belongs to. Bit-fields also report their offset in bits,
+// since they may share bytes, while enumerators have no offset at all.
+func formatOffset(fLayout layout.Layout, baseBits int) string {
+	bits := baseBits + fLayout.BitOffset()
+
+	switch fLayout.Field.(type) {
+	case layout.BitField:
+		return fmt.Sprintf("%d (bit %d)", bits/8, bits)
+	case layout.EnumEntry:
+		return ""
 	}
+	return strconv.Itoa(bits / 8)
+}
 
-	if !bare {
-		fmt.Println(t)
+// layoutLabel returns the name used for a field within the table, which is
+// its declaration, or its type for anonymous members. Enumerators are shown
+// alongside with their value.
+func layoutLabel(fLayout layout.Layout) string {
+	if entry, isEntry := fLayout.Field.(layout.EnumEntry); isEntry {
+		return fmt.Sprintf("%s = %d", entry.Name, entry.Value)
+	}
+
+	if decl := fLayout.Declaration(); decl != "" {
+		return decl
 	}
+	return fLayout.Type()
+}
+
+// isInline checks whether the passed field is of an aggregate type defined
+// inline within its declaration.
+func isInline(field layout.Field) bool {
+	basic, isBasic := layout.AsBasic(field)
+	return isBasic && basic.Inline != nil
 }
 
 func makeTable(typeName string) *table.Table {
@@ -333,27 +956,45 @@ func makeTable(typeName string) *table.Table {
 				return rowStyle
 			}
 		}).
-		Headers(typeName, "Size", "Alignment", "Padding")
+		Headers(typeName, "Offset", "Size", "Alignment", "Padding")
 }
 
-func doPrint(name string, size, align, pad int, tab *table.Table, bare bool) {
-	if bare {
+// doPrint prints a row of the layout table, or a line in bare mode. Rows
+// with no offset, i.e. the one of the aggregate itself, do not report it in
+// bare mode.
+func doPrint(name, offset, size, align, pad string, tab *table.Table, bare bool) {
+	if bare && offset == "" {
 		fmt.Fprintf(
-			os.Stdout, "%s, size: %d, alignment: %d, padding: %d\n",
+			os.Stdout, "%s, size: %s, alignment: %s, padding: %s\n",
 			name, size, align, pad,
 		)
 		return
 	}
 
-	var (
-		sizeStr  = strconv.Itoa(size)
-		alignStr = strconv.Itoa(align)
-		padStr   = strconv.Itoa(pad)
-	)
-	tab.Row(name, sizeStr, alignStr, padStr)
+	if bare {
+		fmt.Fprintf(
+			os.Stdout, "%s, offset: %s, size: %s, alignment: %s, padding: %s\n",
+			name, offset, size, align, pad,
+		)
+		return
+	}
+
+	tab.Row(name, offset, size, align, pad)
 }
 
-func printAggregate(name string, meta AggregateMeta, opt bool) string {
+// formatBits formats an amount of bits as a number of bytes, falling back to
+// an explicit bit count when it does not make up a whole number of bytes.
+func formatBits(bits int) string {
+	if bits%8 != 0 {
+		return fmt.Sprintf("%d bits", bits)
+	}
+	return strconv.Itoa(bits / 8)
+}
+
+// printAggregate renders the passed layout of an aggregate within a box, as
+// its C definition, preceded by a comment telling whether it is the default
+// or the optimized one.
+func printAggregate(agg *layout.Aggregate, meta layout.AggregateMeta, opt bool) string {
 	var builder RenderBuilder
 
 	if !opt {
@@ -363,22 +1004,77 @@ func printAggregate(name string, meta AggregateMeta, opt bool) string {
 	}
 
 	builder.WriteRune('\n')
-	builder.WriteKeyword(name)
-	builder.WriteBase(" {")
-	builder.WriteRune('\n')
+	writeDefinition(&builder, agg, meta.Layout)
+	return builder.String()
+}
+
+// writeDefinition renders the C definition of the passed aggregate with the
+// passed field layouts, which can be copied back into the source, together
+// with its attributes and the '#pragma pack' directive in effect, if any.
+func writeDefinition(builder *RenderBuilder, agg *layout.Aggregate, layouts []layout.Layout) {
+	// lines are styled one at a time, so that they are not padded
+	for _, line := range strings.Split(layout.OpenDefinition(agg), "\n") {
+		builder.WriteKeyword(line)
+		builder.WriteRune('\n')
+	}
 
-	for _, field := range meta.Layout {
+	writeFields(builder, layouts, "\t", make(map[*layout.Aggregate]bool))
+
+	for idx, line := range strings.Split(layout.CloseDefinition(agg), "\n") {
+		if idx > 0 {
+			builder.WriteRune('\n')
+		}
+		builder.WriteBase(line)
+	}
+}
+
+// writeFields renders the declarations of the passed fields, one per line,
+// with the passed indentation. The body of aggregates defined inline is
+// rendered nested, the first time they are found: the declarators sharing
+// one that follow each other are declared together, e.g. `} a, b;`, while
+// the others refer to it by name, as it is defined already.
+func writeFields(builder *RenderBuilder, layouts []layout.Layout, indent string,
+	defined map[*layout.Aggregate]bool) {
+	for idx := 0; idx < len(layouts); idx++ {
 		var (
-			rType = keywordStyle.Render(field.Type())
-			rDecl = baseStyle.Render(field.Declaration())
+			field = layouts[idx]
+			decl  = layout.FormatDeclarator(field.Field)
+			rType = keywordStyle.Render(layout.Specifiers(field.Field))
 			rSemi = baseStyle.Render(";")
 		)
 
-		fmt.Fprintf(&builder, "\t%s %s%s\n", rType, rDecl, rSemi)
-	}
+		if _, isEntry := field.Field.(layout.EnumEntry); isEntry {
+			fmt.Fprintf(builder, "%s%s\n", indent, baseStyle.Render(layoutLabel(field)+","))
+			continue
+		}
 
-	builder.WriteBase("};")
-	return builder.String()
+		basic, _ := layout.AsBasic(field.Field)
+		if basic.Inline == nil || defined[basic.Inline] {
+			fmt.Fprintf(builder, "%s%s %s%s\n", indent, rType, baseStyle.Render(decl), rSemi)
+			continue
+		}
+
+		defined[basic.Inline] = true
+		fmt.Fprintf(builder, "%s%s %s\n", indent, rType, baseStyle.Render("{"))
+		writeFields(builder, field.Fields(), indent+"\t", defined)
+
+		if decl == "" {
+			fmt.Fprintf(builder, "%s%s\n", indent, baseStyle.Render("};"))
+			continue
+		}
+
+		decls := []string{decl}
+		for ; idx+1 < len(layouts); idx++ {
+			next, _ := layout.AsBasic(layouts[idx+1].Field)
+			if next.Inline != basic.Inline {
+				break
+			}
+			decls = append(decls, layout.FormatDeclarator(layouts[idx+1].Field))
+		}
+
+		rDecl := baseStyle.Render(strings.Join(decls, ", "))
+		fmt.Fprintf(builder, "%s%s %s%s\n", indent, baseStyle.Render("}"), rDecl, rSemi)
+	}
 }
 
 // RenderBuilder is a wrapper around `strings.Builder` which exposes methods
@@ -409,8 +1105,14 @@ func (b *RenderBuilder) WriteComment(s string) {
 }
 
 // String returns the final string built with this builder using the box style.
+// The box grows to fit the longest line, so that declarations are not wrapped.
 func (b *RenderBuilder) String() string {
-	return boxStyle.Render(b.Builder.String())
+	var (
+		content  = b.Builder.String()
+		expanded = strings.ReplaceAll(content, "\t", strings.Repeat(" ", boxStyle.GetTabWidth()))
+		width    = max(structBoxWidth, lipgloss.Width(expanded)+boxStyle.GetHorizontalPadding())
+	)
+	return boxStyle.Width(width).Render(content)
 }
 
 func logError(err error) {
@@ -418,6 +1120,10 @@ func logError(err error) {
 	os.Exit(1)
 }
 
+func logWarning(err error) {
+	fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+}
+
 func logErrorMessage(msg string, args ...any) {
 	fmt.Fprintf(os.Stderr, msg+"\n", args...)
 	os.Exit(1)
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Abathargh/stropt/abi"
+	"github.com/Abathargh/stropt/layout"
+	"github.com/Abathargh/stropt/optimize"
+)
+
+func TestPrintedDefinitions(t *testing.T) {
+	const test = `typedef struct { char a; int b; char c; } t4_t;
+	#pragma pack(2)
+	struct pp { char c; int a; char d; };
+	#pragma pack()
+	struct __attribute__((packed, aligned(4))) pk { char c; long l; short s; };
+	typedef struct tg { char c; double d; char e; } tg_t;
+	typedef union { char c; int (*cb)(const char *, ...); } u_t;
+	struct ni { char a; struct { char x; double y; } pos; int b; };
+	typedef enum { RED, GREEN = 4 } color_t;
+	struct sh { char c; struct sd { char x; } a, b; short s; /* stropt: before b */ };
+	struct sa { char c; struct { char x; short y; } a, b; char e; };`
+
+	expected := []struct {
+		name string
+		decl string
+	}{
+		{"t4_t", "typedef struct {\n\tchar a;\n\tchar c;\n\tint b;\n} t4_t;"},
+		{"struct pp", "#pragma pack(push, 2)\nstruct pp {\n\tchar c;\n\tchar d;\n\tint a;\n};\n#pragma pack(pop)"},
+		{"struct pk", "struct __attribute__((packed, aligned(4))) pk {\n\tchar c;\n\tlong l;\n\tshort s;\n};"},
+		{"tg_t", "typedef struct tg {\n\tchar c;\n\tchar e;\n\tdouble d;\n} tg_t;"},
+		{"u_t", "typedef union {\n\tchar c;\n\tint (*cb)(const char *, ...);\n} u_t;"},
+		{"struct ni", "struct ni {\n\tstruct {\n\t\tchar x;\n\t\tdouble y;\n\t} pos;\n\tchar a;\n\tint b;\n};"},
+		{"color_t", "typedef enum {\n\tRED = 0,\n\tGREEN = 4,\n} color_t;"},
+		{"struct sh", "struct sh {\n\tchar c;\n\tstruct sd {\n\t\tchar x;\n\t} a;\n\tshort s;\n\tstruct sd b;\n};"},
+		{"struct sa", "struct sa {\n\tchar c;\n\tchar e;\n\tstruct {\n\t\tchar x;\n\t\tshort y;\n\t} a, b;\n};"},
+	}
+
+	structs, err := layout.ExtractAggregates("", test, false, abi.DefaultTarget())
+	if err != nil {
+		t.Fatalf("Unexpected error when parsing %s: %s", test, err)
+	}
+
+	compiler, compilerErr := exec.LookPath("cc")
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		compilerErr = fmt.Errorf("the host is not a %s one", abi.DefaultTarget().Name)
+	}
+
+	for _, exp := range expected {
+		meta, err := structs.ResolveMeta(exp.name)
+		if err != nil {
+			t.Errorf("Unexpected error when resolving %s: %s", exp.name, err)
+			continue
+		}
+
+		optMeta, err := optimize.Optimize(structs, exp.name, meta, optimize.Constraints{})
+		if err != nil {
+			t.Errorf("Unexpected error when optimizing %s: %s", exp.name, err)
+			continue
+		}
+
+		agg, _ := structs.Lookup(exp.name)
+
+		var builder RenderBuilder
+		writeDefinition(&builder, agg, optMeta.Layout)
+
+		decl := builder.Builder.String()
+		if decl != exp.decl {
+			t.Errorf("Expected %s to be printed as:\n%s\ngot:\n%s", exp.name, exp.decl, decl)
+			continue
+		}
+
+		if compilerErr != nil {
+			continue
+		}
+
+		// the printed definition must compile to the optimized layout
+		src := fmt.Sprintf("%s\n_Static_assert(sizeof(%s) == %d, \"size\");\n",
+			decl, exp.name, optMeta.Size)
+
+		cmd := exec.Command(compiler, "-std=gnu11", "-fsyntax-only", "-x", "c", "-")
+		cmd.Stdin = strings.NewReader(src)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("Expected the definition of %s to compile with size %d:\n%s\n%s",
+				exp.name, optMeta.Size, src, out)
+		}
+	}
+
+	if compilerErr != nil {
+		t.Skipf("Cannot compile the printed definitions: %s", compilerErr)
+	}
+}